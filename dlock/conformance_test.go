@@ -0,0 +1,93 @@
+package dlock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mredisv2 "github.com/alicebob/miniredis/v2"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benz9527/xboot/dlock"
+	"github.com/benz9527/xboot/dlock/dlocktest"
+)
+
+func TestDLockConformance_RedisDLock(t *testing.T) {
+	const addr = "127.0.0.1:6505"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	require.NoError(t, mredis.StartAddr(addr))
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+
+	dlocktest.Run(t,
+		func(keys []string, ttl time.Duration, retry dlock.RetryStrategy) (dlock.DLocker, error) {
+			return dlock.RedisDLock(context.TODO(),
+				func() redisv9.Scripter {
+					return rclient
+				},
+				dlock.WithRedisDLockTTL(ttl),
+				dlock.WithRedisDLockKeys(keys...),
+				dlock.WithRedisDLockToken("conformance"),
+				dlock.WithRedisDLockRetry(retry),
+			)
+		},
+		// The miniredis TTL is only decreased by fast-forward.
+		dlocktest.WithSuiteExpire(mredis.FastForward),
+	)
+}
+
+func TestDLockConformance_RedisRedLock(t *testing.T) {
+	nodes := make([]*mredisv2.Miniredis, 0, 3)
+	loaders := make([]func() redisv9.Scripter, 0, 3)
+	for _, addr := range []string{"127.0.0.1:6506", "127.0.0.1:6507", "127.0.0.1:6508"} {
+		node := mredisv2.NewMiniRedis()
+		require.NoError(t, node.StartAddr(addr))
+		t.Cleanup(node.Close)
+		rclient := redisv9.NewClient(&redisv9.Options{
+			Addr: addr,
+		})
+		t.Cleanup(func() { _ = rclient.Close() })
+		nodes = append(nodes, node)
+		loaders = append(loaders, func() redisv9.Scripter {
+			return rclient
+		})
+	}
+
+	dlocktest.Run(t,
+		func(keys []string, ttl time.Duration, retry dlock.RetryStrategy) (dlock.DLocker, error) {
+			return dlock.RedisRedLock(context.TODO(), loaders,
+				dlock.WithRedisRedLockTTL(ttl),
+				dlock.WithRedisRedLockKeys(keys...),
+				dlock.WithRedisRedLockToken("conformance"),
+				dlock.WithRedisRedLockRetry(retry),
+			)
+		},
+		dlocktest.WithSuiteExpire(func(ttl time.Duration) {
+			for _, node := range nodes {
+				node.FastForward(ttl)
+			}
+		}),
+	)
+}
+
+func TestDLockConformance_MemDLock(t *testing.T) {
+	registry := dlock.NewMemDLockRegistry(nil)
+	dlocktest.Run(t,
+		func(keys []string, ttl time.Duration, retry dlock.RetryStrategy) (dlock.DLocker, error) {
+			return dlock.MemDLock(context.TODO(), registry,
+				dlock.WithMemDLockTTL(ttl),
+				dlock.WithMemDLockKeys(keys...),
+				dlock.WithMemDLockToken("conformance"),
+				dlock.WithMemDLockRetry(retry),
+			)
+		},
+		dlocktest.WithSuiteTTL(200*time.Millisecond),
+	)
+}
@@ -0,0 +1,231 @@
+// Package dlocktest provides the conformance test suite for the
+// dlock.DLocker implementations, so every backend is verified by
+// the same behavior matrix.
+package dlocktest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/benz9527/xboot/dlock"
+)
+
+// Factory builds a DLocker of the backend. Each call must build a
+// locker with a different token, and the lockers built with the same
+// keys contend for them.
+type Factory func(keys []string, ttl time.Duration, retry dlock.RetryStrategy) (dlock.DLocker, error)
+
+type suiteOptions struct {
+	ttl       time.Duration
+	expire    func(ttl time.Duration)
+	keyPrefix string
+}
+
+type SuiteOption func(opt *suiteOptions)
+
+// WithSuiteTTL sets the lock TTL used by the suite, 1s as default.
+func WithSuiteTTL(ttl time.Duration) SuiteOption {
+	return func(opt *suiteOptions) {
+		opt.ttl = ttl
+	}
+}
+
+// WithSuiteExpire sets the function to make the locks with the TTL
+// expired, such as the miniredis fast-forward. It sleeps the TTL as
+// default.
+func WithSuiteExpire(expire func(ttl time.Duration)) SuiteOption {
+	return func(opt *suiteOptions) {
+		opt.expire = expire
+	}
+}
+
+// WithSuiteKeyPrefix isolates the keys of the suite from others.
+func WithSuiteKeyPrefix(prefix string) SuiteOption {
+	return func(opt *suiteOptions) {
+		opt.keyPrefix = prefix
+	}
+}
+
+// Run runs the behavior matrix against the DLocker factory.
+func Run(t *testing.T, factory Factory, opts ...SuiteOption) {
+	suite := &suiteOptions{
+		ttl:       time.Second,
+		expire:    time.Sleep,
+		keyPrefix: "dlocktest",
+	}
+	for _, o := range opts {
+		o(suite)
+	}
+	key := func(name string) string {
+		return fmt.Sprintf("%s/%s/%s", suite.keyPrefix, t.Name(), name)
+	}
+
+	t.Run("MutualExclusion", func(t *testing.T) {
+		testMutualExclusion(t, factory, suite, key("mutex"))
+	})
+	t.Run("TTLExpiry", func(t *testing.T) {
+		testTTLExpiry(t, factory, suite, key("expiry"))
+	})
+	t.Run("Reentrancy", func(t *testing.T) {
+		testReentrancy(t, factory, suite, key("reentrancy"))
+	})
+	t.Run("MultiKeyAtomicity", func(t *testing.T) {
+		testMultiKeyAtomicity(t, factory, suite, key("multi1"), key("multi2"))
+	})
+	t.Run("NonOwnerUnlock", func(t *testing.T) {
+		testNonOwnerUnlock(t, factory, suite, key("nonowner"))
+	})
+	t.Run("RetryExhaustion", func(t *testing.T) {
+		testRetryExhaustion(t, factory, suite, key("exhaustion"))
+	})
+}
+
+func testMutualExclusion(t *testing.T, factory Factory, suite *suiteOptions, key string) {
+	const lockers = 5
+	var (
+		wg       sync.WaitGroup
+		holders  atomic.Int32
+		maxHeld  atomic.Int32
+		acquired atomic.Int32
+	)
+	errC := make(chan error, lockers)
+	for i := 0; i < lockers; i++ {
+		dl, err := factory([]string{key}, suite.ttl, dlock.EndlessRetry(10*time.Millisecond))
+		require.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*suite.ttl+10*time.Second)
+			defer cancel()
+			if err := dl.LockContext(ctx); err != nil {
+				errC <- err
+				return
+			}
+			held := holders.Add(1)
+			for prev := maxHeld.Load(); held > prev && !maxHeld.CompareAndSwap(prev, held); {
+				prev = maxHeld.Load()
+			}
+			time.Sleep(10 * time.Millisecond)
+			holders.Add(-1)
+			acquired.Add(1)
+			if err := dl.Unlock(); err != nil {
+				errC <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(lockers), acquired.Load())
+	require.Equal(t, int32(1), maxHeld.Load())
+}
+
+func testTTLExpiry(t *testing.T, factory Factory, suite *suiteOptions, key string) {
+	holder, err := factory([]string{key}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+	other, err := factory([]string{key}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+
+	require.NoError(t, holder.Lock())
+	ttl, err := holder.TTL()
+	require.NoError(t, err)
+	require.Greater(t, ttl, time.Duration(0))
+	require.LessOrEqual(t, ttl, suite.ttl)
+	ok, err := other.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	suite.expire(suite.ttl + suite.ttl/2)
+	ok, err = other.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	// The expired holder is not able to unlock the lock of others.
+	require.Error(t, holder.Unlock())
+	require.NoError(t, other.Unlock())
+}
+
+func testReentrancy(t *testing.T, factory Factory, suite *suiteOptions, key string) {
+	dl, err := factory([]string{key}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+	other, err := factory([]string{key}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+
+	require.NoError(t, dl.Lock())
+	require.NoError(t, dl.Lock())
+	ok, err := dl.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = other.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, dl.Unlock())
+}
+
+func testMultiKeyAtomicity(t *testing.T, factory Factory, suite *suiteOptions, key1, key2 string) {
+	holder, err := factory([]string{key2}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+	both, err := factory([]string{key1, key2}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+	single, err := factory([]string{key1}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+
+	require.NoError(t, holder.Lock())
+	err = both.Lock()
+	require.True(t, errors.Is(err, dlock.ErrDLockAcquireFailed))
+	// No partial lock is left.
+	ok, err := single.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, single.Unlock())
+
+	require.NoError(t, holder.Unlock())
+	require.NoError(t, both.Lock())
+	other, err := factory([]string{key1}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+	ok, err = other.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, both.Unlock())
+}
+
+func testNonOwnerUnlock(t *testing.T, factory Factory, suite *suiteOptions, key string) {
+	holder, err := factory([]string{key}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+	other, err := factory([]string{key}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+
+	require.NoError(t, holder.Lock())
+	require.Error(t, other.Unlock())
+	ok, err := other.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Error(t, other.Unlock())
+	// Still held by the holder.
+	ok, err = other.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, holder.Unlock())
+}
+
+func testRetryExhaustion(t *testing.T, factory Factory, suite *suiteOptions, key string) {
+	holder, err := factory([]string{key}, suite.ttl, dlock.NoRetry())
+	require.NoError(t, err)
+	other, err := factory([]string{key}, suite.ttl, dlock.LimitedRetry(10*time.Millisecond, 3))
+	require.NoError(t, err)
+
+	require.NoError(t, holder.Lock())
+	startTime := time.Now()
+	err = other.Lock()
+	require.True(t, errors.Is(err, dlock.ErrDLockAcquireFailed))
+	require.GreaterOrEqual(t, time.Since(startTime), 30*time.Millisecond)
+	require.NoError(t, holder.Unlock())
+}
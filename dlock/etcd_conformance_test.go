@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+package dlock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	tintegration "go.etcd.io/etcd/tests/v3/integration"
+
+	"github.com/benz9527/xboot/dlock"
+	"github.com/benz9527/xboot/dlock/dlocktest"
+)
+
+func TestDLockConformance_EtcdDLock(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	dlocktest.Run(t,
+		func(keys []string, ttl time.Duration, retry dlock.RetryStrategy) (dlock.DLocker, error) {
+			return dlock.EtcdDLock(context.TODO(), cli,
+				dlock.WithEtcdDLockTTL(ttl),
+				dlock.WithEtcdDLockKeys(keys...),
+				dlock.WithEtcdDLockToken("conformance"),
+				dlock.WithEtcdDLockRetry(retry),
+			)
+		},
+		// The etcd lease TTL is in seconds, and the remaining TTL is rounded down.
+		dlocktest.WithSuiteTTL(2*time.Second),
+		// The etcd lessor checks the expired leases periodically.
+		dlocktest.WithSuiteExpire(func(ttl time.Duration) {
+			time.Sleep(ttl + time.Second)
+		}),
+	)
+}
@@ -6,137 +6,365 @@ package dlock
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	concv3 "go.etcd.io/etcd/client/v3/concurrency"
-	"go.uber.org/multierr"
 
 	"github.com/benz9527/xboot/lib/infra"
+	"github.com/benz9527/xboot/xlog"
 )
 
 var _ DLocker = (*etcdDLock)(nil)
 
+// etcdDLock puts the keys with the token as value and the lease
+// granted by each acquisition in a transaction, the same as the
+// redis dlock lua scripts.
+// The lease is not kept alive in background unless the watchdog
+// is enabled, so the lock expires after the TTL without renewal.
 type etcdDLock struct {
 	*etcdDLockOptions
-	session   *concv3.Session
-	mutexes   []*concv3.Mutex
-	startTime time.Time
+	keys      []string // The keys with the namespace.
+	watchdog  *dlockWatchdog
+	stats     *dlockStats
+	leaseLock sync.Mutex
+	leaseID   clientv3.LeaseID
+	leaseTTL  int64  // Seconds.
+	acquired  string // Milliseconds, the acquired time of the keys.
+	locked    atomic.Bool
 }
 
 func (dl *etcdDLock) Lock() error {
-	if len(dl.mutexes) < 1 {
-		return infra.NewErrorStack("etcd dlock is not initialized")
-	}
+	_, err := dl.LockWithFence()
+	return err
+}
+
+func (dl *etcdDLock) LockContext(ctx context.Context) error {
+	_, err := dl.lockWithFence(ctx)
+	return err
+}
 
+// LockWithFence returns the create revision of the first lock key
+// as the fencing token. The revision of etcd is increased monotonically
+// and the create revision of the lock key will not be changed until
+// the lock is released.
+func (dl *etcdDLock) LockWithFence() (uint64, error) {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
+	return dl.lockWithFence(ctx)
+}
+
+func (dl *etcdDLock) lockWithFence(ctx context.Context) (uint64, error) {
+	if ctx == nil {
+		ctx = dl.parentCtx
+	}
 	var (
-		merr                error
-		fallbackUnlockIndex int
-		retry               = dl.strategy
-		ticker              *time.Ticker
+		beginTime = time.Now()
+		attempts  int64
+		fence     uint64
 	)
-	// TODO pay attention to the context has been cancelled.
-	for {
-		for i, mu := range dl.mutexes {
-			if err := /* reentrant */ mu.TryLock(*dl.ctx.Load()); err != nil {
-				merr = multierr.Append(merr, err)
-				fallbackUnlockIndex = i
-				break
-			}
-		}
+	if err := retryAcquire(ctx, dl.strategy, func(ctx context.Context) (err error) {
+		attempts++
+		fence, err = dl.acquire(ctx)
+		return err
+	}); err != nil {
+		dl.stats.RecordAcquireFailed(ctx, beginTime, attempts, err)
+		return 0, infra.WrapErrorStackWithMessage(err, "etcd dlock lock failed")
+	}
+	dl.onLocked()
+	dl.stats.RecordAcquired(ctx, beginTime, attempts, fence)
+	return fence, noErr
+}
 
-		if merr == nil {
-			return noErr
-		} else {
-			for i := fallbackUnlockIndex - 1; i >= 0; i-- {
-				if err := dl.mutexes[i].Unlock(*dl.ctx.Load()); err != nil {
-					return err
-				}
-			}
-		}
-		// Retry.
-		backoff := retry.Next()
-		if backoff.Milliseconds() < 1 {
-			if ticker != nil {
-				return infra.WrapErrorStackWithMessage(multierr.Combine(merr, ErrDLockAcquireFailed), "etcd dlock lock retry reach to max")
-			}
-			// No retry strategy.
-			return noErr
-		}
+func (dl *etcdDLock) TryLock() (bool, error) {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
+	beginTime := time.Now()
+	fence, err := dl.acquire(ctx)
+	if errors.Is(err, errEtcdDLockOccupied) {
+		dl.stats.RecordAcquireFailed(ctx, beginTime, 1, nil)
+		return false, noErr
+	} else if err != nil {
+		dl.stats.RecordAcquireFailed(ctx, beginTime, 1, err)
+		return false, infra.WrapErrorStack(err)
+	}
+	dl.onLocked()
+	dl.stats.RecordAcquired(ctx, beginTime, 1, fence)
+	return true, noErr
+}
 
-		if ticker == nil {
-			ticker = time.NewTicker(backoff)
-			defer ticker.Stop() // Avoid ticker leak.
-		} else {
-			ticker.Reset(backoff)
-		}
+var errEtcdDLockOccupied = errors.New("etcd dlock occupied")
 
-		select {
-		case <-(*dl.ctx.Load()).Done():
-			return infra.WrapErrorStack((*dl.ctx.Load()).Err())
-		case <-ticker.C:
-			// continue
+// isHeldBy compares all keys are held by the token.
+func (dl *etcdDLock) isHeldBy() []clientv3.Cmp {
+	cmps := make([]clientv3.Cmp, 0, len(dl.keys))
+	for _, key := range dl.keys {
+		cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", dl.token))
+	}
+	return cmps
+}
+
+// putKeys puts all keys with the token and the lease, and gets the
+// first key for the fencing token.
+func (dl *etcdDLock) putKeys(leaseID clientv3.LeaseID) []clientv3.Op {
+	ops := make([]clientv3.Op, 0, len(dl.keys)+1)
+	for _, key := range dl.keys {
+		ops = append(ops, clientv3.OpPut(key, dl.token, clientv3.WithLease(leaseID)))
+	}
+	return append(ops, clientv3.OpGet(dl.keys[0]))
+}
+
+// putAcquiredAt puts the acquired time (in milliseconds) of the keys
+// for the inspection. They are put with the same lease as the keys, so
+// they are expired together. The reentrant acquisition and the renewal
+// swap the lease, so they have to be put again with the new lease.
+func (dl *etcdDLock) putAcquiredAt(leaseID clientv3.LeaseID, acquiredAt string) []clientv3.Op {
+	ops := make([]clientv3.Op, 0, len(dl.keys))
+	for _, key := range dl.keys {
+		ops = append(ops, clientv3.OpPut(key+acquiredAtKeySuffix, acquiredAt, clientv3.WithLease(leaseID)))
+	}
+	return ops
+}
+
+// acquire grants a new lease and puts all keys with it if they are
+// free or held by the token (reentrant). The previous lease of the
+// reentrant lock will be revoked.
+func (dl *etcdDLock) acquire(ctx context.Context) (uint64, error) {
+	ttl := etcdLeaseTTL(dl.ttl)
+	grant, err := dl.client.Grant(ctx, ttl)
+	if err != nil {
+		return 0, err
+	}
+	free := make([]clientv3.Cmp, 0, len(dl.keys))
+	for _, key := range dl.keys {
+		free = append(free, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+	}
+	// The fencing token is read from the last op of putKeys, so the
+	// acquired time ops are appended after them.
+	acquiredAt := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	resp, err := dl.client.Txn(ctx).If(free...).
+		Then(append(dl.putKeys(grant.ID), dl.putAcquiredAt(grant.ID, acquiredAt)...)...).
+		Commit()
+	if err == nil && !resp.Succeeded {
+		if prev := dl.loadAcquiredAt(); len(prev) > 0 {
+			acquiredAt = prev
 		}
+		resp, err = dl.client.Txn(ctx).If(dl.isHeldBy()...).
+			Then(append(dl.putKeys(grant.ID), dl.putAcquiredAt(grant.ID, acquiredAt)...)...).
+			Commit()
+	}
+	if err != nil || !resp.Succeeded {
+		dl.revoke(grant.ID)
+		if err != nil {
+			return 0, err
+		}
+		return 0, errEtcdDLockOccupied
+	}
+	dl.swapLease(grant.ID, ttl, acquiredAt)
+	kvs := resp.Responses[len(dl.keys)].GetResponseRange().GetKvs()
+	if len(kvs) <= 0 {
+		return 0, infra.WrapErrorStackWithMessage(ErrDLockLeaseLost, "etcd dlock key not found")
 	}
+	return uint64(kvs[0].CreateRevision), noErr
 }
 
+// swapLease replaces the lease and revokes the previous one.
+func (dl *etcdDLock) swapLease(leaseID clientv3.LeaseID, ttl int64, acquiredAt string) {
+	dl.leaseLock.Lock()
+	prevID := dl.leaseID
+	dl.leaseID, dl.leaseTTL, dl.acquired = leaseID, ttl, acquiredAt
+	dl.leaseLock.Unlock()
+	if prevID != clientv3.NoLease && prevID != leaseID {
+		dl.revoke(prevID)
+	}
+}
+
+// etcdLeaseTTL rounds the TTL up to seconds, the etcd lease TTL is in
+// seconds.
+func etcdLeaseTTL(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+func (dl *etcdDLock) loadLease() (clientv3.LeaseID, int64) {
+	dl.leaseLock.Lock()
+	defer dl.leaseLock.Unlock()
+	return dl.leaseID, dl.leaseTTL
+}
+
+func (dl *etcdDLock) loadAcquiredAt() string {
+	dl.leaseLock.Lock()
+	defer dl.leaseLock.Unlock()
+	return dl.acquired
+}
+
+// revoke revokes the lease in best effort, the lease will be
+// expired after the TTL if it is failed to revoke.
+func (dl *etcdDLock) revoke(leaseID clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
+	_, _ = dl.client.Revoke(ctx, leaseID)
+}
+
+func (dl *etcdDLock) onLocked() {
+	dl.locked.Store(true)
+	dl.watchdog.start(dl.parentCtx, dl.watchdogRenewal, nil)
+}
+
+// Renewal keeps the lease alive once if the new TTL is the same as
+// the lease's, otherwise, re-grants a lease with the new TTL and puts
+// the keys with it.
+// The etcd lease TTL is in seconds, the fractional TTL is rounded up
+// and the sub-second TTL is rejected.
 func (dl *etcdDLock) Renewal(newTTL time.Duration) error {
-	return infra.NewErrorStack("etcd dlock not support to refresh ttl")
+	if newTTL.Seconds() < 1 {
+		return infra.NewErrorStack("renewal etcd dlock with sub-second TTL")
+	}
+	if !dl.locked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "renewal etcd dlock with no lock")
+	}
+	ctx, cancel := context.WithTimeout(dl.parentCtx, newTTL)
+	defer cancel()
+	err := dl.renewal(ctx, newTTL)
+	dl.stats.RecordRenewal(err)
+	return err
 }
 
+func (dl *etcdDLock) renewal(ctx context.Context, newTTL time.Duration) error {
+	leaseID, leaseTTL := dl.loadLease()
+	ttl := etcdLeaseTTL(newTTL)
+	if ttl == leaseTTL {
+		return dl.keepAliveOnce(ctx, leaseID)
+	}
+
+	grant, err := dl.client.Grant(ctx, ttl)
+	if err != nil {
+		return infra.WrapErrorStack(err)
+	}
+	acquiredAt := dl.loadAcquiredAt()
+	resp, err := dl.client.Txn(ctx).If(dl.isHeldBy()...).
+		Then(append(dl.putKeys(grant.ID), dl.putAcquiredAt(grant.ID, acquiredAt)...)...).
+		Commit()
+	if err != nil {
+		dl.revoke(grant.ID)
+		return infra.WrapErrorStack(err)
+	}
+	if !resp.Succeeded {
+		dl.revoke(grant.ID)
+		return infra.WrapErrorStackWithMessage(ErrDLockLeaseLost, "etcd dlock token mismatch, unable to refresh")
+	}
+	dl.swapLease(grant.ID, ttl, acquiredAt)
+	return noErr
+}
+
+// keepAliveOnce resets the lease TTL. The lease not found error means
+// that the lease has been expired or revoked.
+func (dl *etcdDLock) keepAliveOnce(ctx context.Context, leaseID clientv3.LeaseID) error {
+	if _, err := dl.client.KeepAliveOnce(ctx, leaseID); errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return infra.AppendErrorStack(ErrDLockLeaseLost, err)
+	} else if err != nil {
+		return infra.WrapErrorStack(err)
+	}
+	return noErr
+}
+
+func (dl *etcdDLock) watchdogRenewal(ctx context.Context) error {
+	leaseID, _ := dl.loadLease()
+	err := dl.keepAliveOnce(ctx, leaseID)
+	dl.stats.RecordRenewal(err)
+	return err
+}
+
+// TTL returns the remaining TTL of the lease from the server.
+// The etcd lease TTL is in seconds and rounded down.
 func (dl *etcdDLock) TTL() (time.Duration, error) {
-	if dl.startTime.IsZero() {
-		return 0, infra.NewErrorStack("etcd dlock is not initialized or has been  closed")
+	if !dl.locked.Load() {
+		return 0, infra.WrapErrorStackWithMessage(ErrDLockNoInit, "fetch etcd dlock ttl failed")
+	}
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
+	leaseID, _ := dl.loadLease()
+	resp, err := dl.client.TimeToLive(ctx, leaseID)
+	if err != nil {
+		return 0, infra.WrapErrorStack(err)
 	}
-	diff := time.Now().Sub(dl.startTime)
-	return dl.ttl - diff, nil
+	// The TTL is -1 if the lease has been expired or revoked.
+	if resp.TTL < 0 {
+		return 0, infra.WrapErrorStackWithMessage(ErrDLockLeaseLost, "etcd dlock lease expired")
+	}
+	return time.Duration(resp.TTL) * time.Second, noErr
 }
 
 func (dl *etcdDLock) Unlock() error {
-	if len(dl.mutexes) < 1 {
-		return infra.NewErrorStack("etcd dlock is not initialized")
-	}
-	var merr error
-	// TODO pay attention to the context has been cancelled.
-	for _, mu := range dl.mutexes {
-		if err := mu.Unlock(*dl.ctx.Load()); err != nil {
-			merr = multierr.Append(merr, err)
-		}
+	return dl.UnlockContext(dl.parentCtx)
+}
+
+func (dl *etcdDLock) UnlockContext(ctx context.Context) error {
+	if !dl.locked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to unlock a no init etcd dlock")
 	}
-	if merr == nil && dl.session != nil {
-		merr = multierr.Append(merr, dl.session.Close())
-		if cancelPtr := dl.ctxCancel.Load(); cancelPtr != nil {
-			(*cancelPtr)()
-		}
-		dl.startTime = time.Time{} // Zero time
+	if ctx == nil {
+		ctx = dl.parentCtx
+	}
+	// The watchdog is stopped before the release, otherwise its
+	// renewal may report the released lock as lost. It is restarted
+	// if the release fails but the lock may be still held.
+	dl.watchdog.stop()
+	ops := make([]clientv3.Op, 0, 2*len(dl.keys))
+	for _, key := range dl.keys {
+		ops = append(ops, clientv3.OpDelete(key), clientv3.OpDelete(key+acquiredAtKeySuffix))
+	}
+	resp, err := dl.client.Txn(ctx).If(dl.isHeldBy()...).Then(ops...).Commit()
+	if err != nil {
+		dl.watchdog.start(dl.parentCtx, dl.watchdogRenewal, nil)
+		return infra.WrapErrorStack(err)
+	}
+	if !resp.Succeeded {
+		return infra.NewErrorStack("etcd dlock token mismatch, unable to unlock")
 	}
-	return merr
+	dl.locked.Store(false)
+	dl.swapLease(clientv3.NoLease, 0, "")
+	dl.stats.RecordReleased(ctx)
+	return noErr
 }
 
 type etcdDLockOptions struct {
-	client    *clientv3.Client
-	ctx       atomic.Pointer[context.Context]
-	ctxCancel atomic.Pointer[context.CancelFunc]
-	strategy  RetryStrategy
-	keys      []string
-	ttl       time.Duration
+	client      *clientv3.Client
+	parentCtx   context.Context
+	strategy    RetryStrategy
+	onLeaseLost LeaseLostHandler
+	logger      xlog.XLogger
+	namespace   string
+	keys        []string
+	token       string
+	ttl         time.Duration
+	watchdog    bool
+	statsName   string
+	enableStats bool
 }
 
 func EtcdDLockBuilder(ctx context.Context, client *clientv3.Client) *etcdDLockOptions {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	opts := &etcdDLockOptions{client: client}
-	opts.ctx.Store(&ctx)
-	return opts
+	return &etcdDLockOptions{client: client, parentCtx: ctx}
 }
 
+// TTL sets the lease TTL of the lock. The etcd lease TTL is in seconds,
+// the fractional TTL is rounded up and the sub-second TTL is rejected.
 func (opt *etcdDLockOptions) TTL(ttl time.Duration) *etcdDLockOptions {
 	opt.ttl = ttl
 	return opt
 }
 
+func (opt *etcdDLockOptions) Token(token string) *etcdDLockOptions {
+	opt.token = token + "&" + nano()
+	return opt
+}
+
 func (opt *etcdDLockOptions) Keys(keys ...string) *etcdDLockOptions {
 	opt.keys = make([]string, len(keys))
 	for i, key := range keys {
@@ -145,17 +373,59 @@ func (opt *etcdDLockOptions) Keys(keys ...string) *etcdDLockOptions {
 	return opt
 }
 
+// Namespace prefixes the keys with the namespace as is, e.g. "/app/".
+// The locks under the namespace are able to be inspected and force
+// released by the admin with the same namespace.
+func (opt *etcdDLockOptions) Namespace(namespace string) *etcdDLockOptions {
+	opt.namespace = namespace
+	return opt
+}
+
 func (opt *etcdDLockOptions) Retry(strategy RetryStrategy) *etcdDLockOptions {
 	opt.strategy = strategy
 	return opt
 }
 
+// Watchdog enables keeping the lease alive with the build TTL
+// automatically until the lock is released or the context is
+// cancelled.
+// The onLost handler will be called if the lease is lost.
+func (opt *etcdDLockOptions) Watchdog(onLost LeaseLostHandler) *etcdDLockOptions {
+	opt.watchdog = true
+	opt.onLeaseLost = onLost
+	return opt
+}
+
+// Stats enables the OpenTelemetry metrics of the lock, includes
+// the acquisition latency, attempts, failures, hold duration,
+// renewals and lost leases.
+func (opt *etcdDLockOptions) Stats() *etcdDLockOptions {
+	opt.enableStats = true
+	return opt
+}
+
+// StatsName enables the metrics with the name as the "dlock.name"
+// attribute. The name should be in low cardinality, e.g. the job name
+// instead of the lock keys.
+func (opt *etcdDLockOptions) StatsName(name string) *etcdDLockOptions {
+	opt.enableStats = true
+	opt.statsName = name
+	return opt
+}
+
+// Logger logs the acquisition, release, renewal failure and lost
+// lease events of the lock.
+func (opt *etcdDLockOptions) Logger(logger xlog.XLogger) *etcdDLockOptions {
+	opt.logger = logger
+	return opt
+}
+
 func (opt *etcdDLockOptions) Build() (DLocker, error) {
 	if opt.client == nil {
 		return nil, infra.NewErrorStack("etcd dlock client is nil")
 	}
 	if opt.ttl.Seconds() < 1 {
-		return nil, infra.NewErrorStack("etcd dlock with zero second TTL")
+		return nil, infra.NewErrorStack("etcd dlock with sub-second TTL")
 	}
 	if len(opt.keys) <= 0 {
 		return nil, infra.NewErrorStack("etcd dlock with zero keys")
@@ -163,36 +433,24 @@ func (opt *etcdDLockOptions) Build() (DLocker, error) {
 	if opt.strategy == nil {
 		opt.strategy = NoRetry()
 	}
-
-	var (
-		ctx    context.Context
-		cancel context.CancelFunc
-	)
-	ctx, cancel = context.WithTimeout(*opt.ctx.Load(), opt.ttl)
-	if ctx == nil || cancel == nil {
-		return nil, infra.NewErrorStack("etcd dlock build with nil context or nil context cancel function")
+	if len(opt.token) <= 0 {
+		// The token is required to distinguish the holders.
+		opt.Token("")
 	}
-	startTime := time.Now()
-	opt.ctx.Store(&ctx)
-	opt.ctxCancel.Store(&cancel)
-
-	session, err := concv3.NewSession(opt.client,
-		concv3.WithTTL(int(opt.ttl.Seconds())),
-		concv3.WithLease(clientv3.NoLease), // Grant new lease ID by new session.
-	)
-	if err != nil {
-		return nil, infra.WrapErrorStack(err)
-	}
-	var mutexes = make([]*concv3.Mutex, 0, len(opt.keys))
-	for _, prefix := range opt.keys {
-		mutexes = append(mutexes, concv3.NewMutex(session, prefix))
+	// The options keep the keys as is, so they are able to build again.
+	keys := make([]string, len(opt.keys))
+	for i, key := range opt.keys {
+		keys[i] = opt.namespace + key
 	}
-	return &etcdDLock{
+	dl := &etcdDLock{
 		etcdDLockOptions: opt,
-		session:          session,
-		startTime:        startTime,
-		mutexes:          mutexes,
-	}, nil
+		keys:             keys,
+		stats:            newDLockStats("etcd", opt.statsName, keys, opt.token, opt.enableStats, opt.logger),
+	}
+	if opt.watchdog {
+		dl.watchdog = newDLockWatchdog(opt.ttl, dl.stats.leaseLostHandler(opt.onLeaseLost))
+	}
+	return dl, nil
 }
 
 type EtcdDLockOption func(opt *etcdDLockOptions)
@@ -203,18 +461,54 @@ func WithEtcdDLockTTL(ttl time.Duration) EtcdDLockOption {
 	}
 }
 
+func WithEtcdDLockToken(token string) EtcdDLockOption {
+	return func(opt *etcdDLockOptions) {
+		opt.Token(token)
+	}
+}
+
 func WithEtcdDLockKeys(keys ...string) EtcdDLockOption {
 	return func(opt *etcdDLockOptions) {
 		opt.Keys(keys...)
 	}
 }
 
+func WithEtcdDLockNamespace(namespace string) EtcdDLockOption {
+	return func(opt *etcdDLockOptions) {
+		opt.Namespace(namespace)
+	}
+}
+
 func WithEtcdDLockRetry(strategy RetryStrategy) EtcdDLockOption {
 	return func(opt *etcdDLockOptions) {
 		opt.Retry(strategy)
 	}
 }
 
+func WithEtcdDLockWatchdog(onLost LeaseLostHandler) EtcdDLockOption {
+	return func(opt *etcdDLockOptions) {
+		opt.Watchdog(onLost)
+	}
+}
+
+func WithEtcdDLockStats() EtcdDLockOption {
+	return func(opt *etcdDLockOptions) {
+		opt.Stats()
+	}
+}
+
+func WithEtcdDLockStatsName(name string) EtcdDLockOption {
+	return func(opt *etcdDLockOptions) {
+		opt.StatsName(name)
+	}
+}
+
+func WithEtcdDLockLogger(logger xlog.XLogger) EtcdDLockOption {
+	return func(opt *etcdDLockOptions) {
+		opt.Logger(logger)
+	}
+}
+
 func EtcdDLock(ctx context.Context, client *clientv3.Client, opts ...EtcdDLockOption) (DLocker, error) {
 	builderOpts := EtcdDLockBuilder(ctx, client)
 	for _, o := range opts {
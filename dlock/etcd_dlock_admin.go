@@ -0,0 +1,188 @@
+package dlock
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+var _ DLockAdmin = (*etcdDLockAdmin)(nil)
+
+// etcdDLockAdmin ranges the lock keys under the namespace. The lock
+// keys are the keys with lease, and the TTL is the lease's.
+type etcdDLockAdmin struct {
+	*etcdDLockAdminOptions
+}
+
+func (admin *etcdDLockAdmin) List(ctx context.Context) ([]DLockInfo, error) {
+	if ctx == nil {
+		ctx = admin.parentCtx
+	}
+	resp, err := admin.client.Get(ctx, admin.namespace, clientv3.WithPrefix())
+	if err != nil {
+		return nil, infra.WrapErrorStackWithMessage(err, "etcd dlock admin list failed")
+	}
+	acquiredAts := make(map[string]string)
+	for _, kv := range resp.Kvs {
+		if key := string(kv.Key); strings.HasSuffix(key, acquiredAtKeySuffix) {
+			acquiredAts[strings.TrimSuffix(key, acquiredAtKeySuffix)] = string(kv.Value)
+		}
+	}
+	var (
+		infos = make([]DLockInfo, 0, len(resp.Kvs))
+		ttls  = make(map[int64]time.Duration)
+	)
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		if kv.Lease == 0 || strings.HasSuffix(key, acquiredAtKeySuffix) {
+			continue
+		}
+		ttl, ok := ttls[kv.Lease]
+		if !ok {
+			if ttl, err = admin.leaseTTL(ctx, kv.Lease); err != nil {
+				return nil, err
+			}
+			ttls[kv.Lease] = ttl
+		}
+		infos = append(infos, admin.newInfo(key, string(kv.Value), ttl, acquiredAts[key]))
+	}
+	return infos, noErr
+}
+
+func (admin *etcdDLockAdmin) Inspect(ctx context.Context, key string) (DLockInfo, error) {
+	if ctx == nil {
+		ctx = admin.parentCtx
+	}
+	key = admin.namespace + key
+	resp, err := admin.client.Txn(ctx).Then(
+		clientv3.OpGet(key),
+		clientv3.OpGet(key+acquiredAtKeySuffix),
+	).Commit()
+	if err != nil {
+		return DLockInfo{}, infra.WrapErrorStackWithMessage(err, "etcd dlock admin inspect failed")
+	}
+	kvs := resp.Responses[0].GetResponseRange().GetKvs()
+	if len(kvs) <= 0 || kvs[0].Lease == 0 {
+		return DLockInfo{}, infra.WrapErrorStackWithMessage(ErrDLockNotFound, key)
+	}
+	ttl, err := admin.leaseTTL(ctx, kvs[0].Lease)
+	if err != nil {
+		return DLockInfo{}, err
+	}
+	var acquiredAt string
+	if metaKvs := resp.Responses[1].GetResponseRange().GetKvs(); len(metaKvs) > 0 {
+		acquiredAt = string(metaKvs[0].Value)
+	}
+	return admin.newInfo(key, string(kvs[0].Value), ttl, acquiredAt), noErr
+}
+
+// ForceRelease revokes the leases of the keys, so the other keys
+// of the same lock are released too and the holder's watchdog will
+// find the lease lost. Then the keys are deleted.
+func (admin *etcdDLockAdmin) ForceRelease(ctx context.Context, keys ...string) error {
+	if ctx == nil {
+		ctx = admin.parentCtx
+	}
+	gets := make([]clientv3.Op, 0, len(keys))
+	dels := make([]clientv3.Op, 0, 2*len(keys))
+	for _, key := range keys {
+		key = admin.namespace + key
+		gets = append(gets, clientv3.OpGet(key, clientv3.WithKeysOnly()))
+		dels = append(dels, clientv3.OpDelete(key), clientv3.OpDelete(key+acquiredAtKeySuffix))
+	}
+	resp, err := admin.client.Txn(ctx).Then(gets...).Commit()
+	if err != nil {
+		return infra.WrapErrorStackWithMessage(err, "etcd dlock admin force release failed")
+	}
+	revoked := make(map[int64]struct{})
+	for _, r := range resp.Responses {
+		for _, kv := range r.GetResponseRange().GetKvs() {
+			if _, ok := revoked[kv.Lease]; ok || kv.Lease == 0 {
+				continue
+			}
+			revoked[kv.Lease] = struct{}{}
+			// The lease may be expired already.
+			_, _ = admin.client.Revoke(ctx, clientv3.LeaseID(kv.Lease))
+		}
+	}
+	if _, err = admin.client.Txn(ctx).Then(dels...).Commit(); err != nil {
+		return infra.WrapErrorStackWithMessage(err, "etcd dlock admin force release failed")
+	}
+	return noErr
+}
+
+// leaseTTL returns zero if the lease has been expired.
+// The etcd lease TTL is in seconds.
+func (admin *etcdDLockAdmin) leaseTTL(ctx context.Context, leaseID int64) (time.Duration, error) {
+	resp, err := admin.client.TimeToLive(ctx, clientv3.LeaseID(leaseID))
+	if err != nil {
+		return 0, infra.WrapErrorStack(err)
+	}
+	if resp.TTL <= 0 {
+		return 0, noErr
+	}
+	return time.Duration(resp.TTL) * time.Second, noErr
+}
+
+func (admin *etcdDLockAdmin) newInfo(key, token string, ttl time.Duration, acquiredAt string) DLockInfo {
+	info := DLockInfo{
+		Key:   strings.TrimPrefix(key, admin.namespace),
+		Token: token,
+		TTL:   ttl,
+	}
+	if ms, err := strconv.ParseInt(acquiredAt, 10, 64); err == nil {
+		info.AcquiredAt = time.UnixMilli(ms)
+	}
+	return info
+}
+
+type etcdDLockAdminOptions struct {
+	client    *clientv3.Client
+	parentCtx context.Context
+	namespace string
+}
+
+func EtcdDLockAdminBuilder(ctx context.Context, client *clientv3.Client) *etcdDLockAdminOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &etcdDLockAdminOptions{client: client, parentCtx: ctx}
+}
+
+// Namespace is the same as the lockers' namespace.
+func (opt *etcdDLockAdminOptions) Namespace(namespace string) *etcdDLockAdminOptions {
+	opt.namespace = namespace
+	return opt
+}
+
+func (opt *etcdDLockAdminOptions) Build() (DLockAdmin, error) {
+	if opt.client == nil {
+		return nil, infra.NewErrorStack("etcd dlock admin client is nil")
+	}
+	if len(opt.namespace) <= 0 {
+		// Avoid ranging the whole keyspace.
+		return nil, infra.NewErrorStack("etcd dlock admin with empty namespace")
+	}
+	return &etcdDLockAdmin{etcdDLockAdminOptions: opt}, nil
+}
+
+type EtcdDLockAdminOption func(opt *etcdDLockAdminOptions)
+
+func WithEtcdDLockAdminNamespace(namespace string) EtcdDLockAdminOption {
+	return func(opt *etcdDLockAdminOptions) {
+		opt.Namespace(namespace)
+	}
+}
+
+func EtcdDLockAdmin(ctx context.Context, client *clientv3.Client, opts ...EtcdDLockAdminOption) (DLockAdmin, error) {
+	builderOpts := EtcdDLockAdminBuilder(ctx, client)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
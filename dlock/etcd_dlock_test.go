@@ -5,6 +5,7 @@ package dlock
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -40,7 +41,7 @@ func TestEtcdDLock_DataRace(t *testing.T) {
 			return
 		}
 		require.NoError(t, err)
-		require.Error(t, lock1.Renewal(3*time.Second))
+		require.NoError(t, lock1.Renewal(3*time.Second))
 		ttl, err := lock1.TTL()
 		require.NoError(t, err)
 		t.Log("lock1 ttl", ttl)
@@ -74,3 +75,549 @@ func TestEtcdDLock_DataRace(t *testing.T) {
 	_ = cli.Close()
 	clusterv3.Terminate(t)
 }
+
+func TestEtcdDLock_Watchdog(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	lostC := make(chan error, 1)
+	lock, err := EtcdDLock(context.TODO(), cli,
+		WithEtcdDLockTTL(3*time.Second),
+		WithEtcdDLockKeys("testKey3"),
+		WithEtcdDLockWatchdog(func(err error) {
+			lostC <- err
+		}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, lock.Lock())
+	// The failed release keeps the watchdog running.
+	cancelledCtx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	require.Error(t, lock.UnlockContext(cancelledCtx))
+
+	// Revoke the lease behind the lock.
+	leaseID, _ := lock.(*etcdDLock).loadLease()
+	_, err = cli.Revoke(context.TODO(), leaseID)
+	require.NoError(t, err)
+	select {
+	case err := <-lostC:
+		require.True(t, errors.Is(err, ErrDLockLeaseLost))
+	case <-time.After(3 * time.Second):
+		t.Fatal("watchdog did not report lost lease")
+	}
+}
+
+func TestEtcdDLock_Fence(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	prevFence := uint64(0)
+	for i := 0; i < 3; i++ {
+		lock, err := EtcdDLock(context.TODO(), cli,
+			WithEtcdDLockTTL(2*time.Second),
+			WithEtcdDLockKeys("testKey4", "testKey5"),
+		)
+		require.NoError(t, err)
+		fence, err := lock.LockWithFence()
+		require.NoError(t, err)
+		require.Greater(t, fence, prevFence)
+		prevFence = fence
+		require.NoError(t, lock.Unlock())
+	}
+}
+
+func TestEtcdRWDLock(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	reader1, err := EtcdRWDLockBuilder(ctx, cli).
+		TTL(2 * time.Second).
+		Key("testRWKey2").
+		Build()
+	require.NoError(t, err)
+	reader2, err := EtcdRWDLock(ctx, cli,
+		WithEtcdRWDLockTTL(2*time.Second),
+		WithEtcdRWDLockKey("testRWKey2"),
+	)
+	require.NoError(t, err)
+	writer, err := EtcdRWDLock(ctx, cli,
+		WithEtcdRWDLockTTL(2*time.Second),
+		WithEtcdRWDLockKey("testRWKey2"),
+		WithEtcdRWDLockRetry(LimitedRetry(10*time.Millisecond, 2)),
+	)
+	require.NoError(t, err)
+
+	require.Error(t, reader1.RUnlock())
+	require.Error(t, writer.Unlock())
+
+	// Shared readers.
+	require.NoError(t, reader1.RLock())
+	require.NoError(t, reader2.RLock())
+	require.True(t, errors.Is(writer.Lock(), ErrDLockAcquireFailed))
+	require.NoError(t, reader1.RUnlock())
+	require.True(t, errors.Is(writer.Lock(), ErrDLockAcquireFailed))
+	require.NoError(t, reader2.RUnlock())
+
+	// Exclusive writer.
+	require.NoError(t, writer.Lock())
+	require.True(t, errors.Is(reader1.RLock(), ErrDLockAcquireFailed))
+	// The writer is able to downgrade to a reader.
+	require.NoError(t, writer.RLock())
+	require.NoError(t, writer.RUnlock())
+	require.NoError(t, writer.Unlock())
+	require.NoError(t, reader1.RLock())
+	require.NoError(t, reader1.RUnlock())
+
+	// The sessions are closed once released, so are their leases.
+	leases, err := cli.Leases(ctx)
+	require.NoError(t, err)
+	require.Empty(t, leases.Leases)
+}
+
+func TestEtcdRWDLock_WriterNotStarved(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	newReader := func() RWDLocker {
+		reader, err := EtcdRWDLock(ctx, cli,
+			WithEtcdRWDLockTTL(1500*time.Millisecond),
+			WithEtcdRWDLockKey("testRWKey3"),
+		)
+		require.NoError(t, err)
+		return reader
+	}
+	reader1, reader2 := newReader(), newReader()
+	writer, err := EtcdRWDLock(ctx, cli,
+		WithEtcdRWDLockTTL(1500*time.Millisecond),
+		WithEtcdRWDLockKey("testRWKey3"),
+		WithEtcdRWDLockRetry(LimitedRetry(10*time.Millisecond, 500)),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, reader1.RLock())
+	// The fractional TTL is rounded up.
+	leases, err := cli.Leases(ctx)
+	require.NoError(t, err)
+	require.Len(t, leases.Leases, 1)
+	ttl, err := cli.TimeToLive(ctx, leases.Leases[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), ttl.GrantedTTL)
+
+	lockedC := make(chan error, 1)
+	go func() {
+		lockedC <- writer.Lock()
+	}()
+	require.Eventually(t, func() bool {
+		resp, err := cli.Get(ctx, "testRWKey3"+etcdRWDLockWritePrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		return err == nil && resp.Count == 1
+	}, time.Second, 10*time.Millisecond)
+	// The waiting writer keeps its key, so the later reader is blocked.
+	require.True(t, errors.Is(reader2.RLock(), ErrDLockAcquireFailed))
+	require.NoError(t, reader1.RUnlock())
+	require.NoError(t, <-lockedC)
+	require.NoError(t, writer.Unlock())
+	require.NoError(t, reader2.RLock())
+	require.NoError(t, reader2.RUnlock())
+}
+
+func TestEtcdDSemaphore(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	sem1, err := EtcdDSemaphoreBuilder(ctx, cli).
+		TTL(2 * time.Second).
+		Key("testSemKey2").
+		Permits(3).
+		Build()
+	require.NoError(t, err)
+	sem2, err := EtcdDSemaphore(ctx, cli,
+		WithEtcdDSemaphoreTTL(2*time.Second),
+		WithEtcdDSemaphoreKey("testSemKey2"),
+		WithEtcdDSemaphorePermits(3),
+		WithEtcdDSemaphoreRetry(LimitedRetry(10*time.Millisecond, 2)),
+	)
+	require.NoError(t, err)
+
+	require.Error(t, sem1.Release())
+	require.Error(t, sem1.Acquire(ctx, 4))
+	require.NoError(t, sem1.Acquire(ctx, 2))
+	require.Error(t, sem1.Acquire(ctx, 1))
+	require.True(t, errors.Is(sem2.Acquire(ctx, 2), ErrDLockAcquireFailed))
+	require.NoError(t, sem1.Release())
+	require.NoError(t, sem2.Acquire(ctx, 2))
+	require.NoError(t, sem2.Release())
+
+	// The sessions are closed once released, so are their leases.
+	leases, err := cli.Leases(ctx)
+	require.NoError(t, err)
+	require.Empty(t, leases.Leases)
+
+	sem3, err := EtcdDSemaphore(ctx, cli,
+		WithEtcdDSemaphoreTTL(1500*time.Millisecond),
+		WithEtcdDSemaphoreKey("testSemKey2"),
+		WithEtcdDSemaphorePermits(1),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sem3.Acquire(ctx, 1))
+	// The fractional TTL is rounded up.
+	leases, err = cli.Leases(ctx)
+	require.NoError(t, err)
+	require.Len(t, leases.Leases, 1)
+	ttl, err := cli.TimeToLive(ctx, leases.Leases[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), ttl.GrantedTTL)
+	require.NoError(t, sem3.Release())
+}
+
+func TestEtcdDLock_TryLock(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	lock1, err := EtcdDLock(context.TODO(), cli,
+		WithEtcdDLockTTL(2*time.Second),
+		WithEtcdDLockKeys("testKey1_4", "testKey2_4"),
+	)
+	require.NoError(t, err)
+	lock2, err := EtcdDLock(context.TODO(), cli,
+		WithEtcdDLockTTL(2*time.Second),
+		WithEtcdDLockKeys("testKey2_4"),
+		WithEtcdDLockRetry(EndlessRetry(10*time.Millisecond)),
+	)
+	require.NoError(t, err)
+
+	ok, err := lock1.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = lock2.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	err = lock2.LockContext(ctx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	require.NoError(t, lock1.UnlockContext(context.TODO()))
+	require.NoError(t, lock2.LockContext(context.TODO()))
+	require.NoError(t, lock2.UnlockContext(context.TODO()))
+}
+
+func TestEtcdLeaderElector(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	electedC := make(chan string, 4)
+	revokedC := make(chan error, 4)
+	e1, err := EtcdLeaderElectorBuilder(ctx, cli).
+		TTL(2 * time.Second).
+		Key("testLeaderKey2").
+		Candidate("node1").
+		OnElected(func() {
+			electedC <- "node1"
+		}).
+		OnRevoked(func(err error) {
+			revokedC <- err
+		}).
+		Build()
+	require.NoError(t, err)
+	e2, err := EtcdLeaderElector(ctx, cli,
+		WithEtcdLeaderElectorTTL(2*time.Second),
+		WithEtcdLeaderElectorKey("testLeaderKey2"),
+		WithEtcdLeaderElectorCandidate("node2"),
+		WithEtcdLeaderElectorOnElected(func() {
+			electedC <- "node2"
+		}),
+		WithEtcdLeaderElectorOnRevoked(func(err error) {
+			revokedC <- err
+		}),
+	)
+	require.NoError(t, err)
+
+	require.Error(t, e1.Resign(ctx))
+	require.NoError(t, e1.Campaign(ctx))
+	require.True(t, e1.IsLeader())
+	require.Equal(t, "node1", <-electedC)
+
+	campaignCtx, campaignCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer campaignCancel()
+	require.Error(t, e2.Campaign(campaignCtx))
+	require.False(t, e2.IsLeader())
+
+	campaignC := make(chan error, 1)
+	go func() {
+		campaignC <- e2.Campaign(ctx)
+	}()
+	require.NoError(t, e1.Resign(ctx))
+	require.NoError(t, <-revokedC)
+	require.NoError(t, <-campaignC)
+	require.True(t, e2.IsLeader())
+	require.Equal(t, "node2", <-electedC)
+
+	// The leadership is revoked if the session lease is lost.
+	_, err = cli.Revoke(ctx, e2.(*etcdLeaderElector).session.Lease())
+	require.NoError(t, err)
+	select {
+	case err := <-revokedC:
+		require.True(t, errors.Is(err, ErrDLockLeaseLost))
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership was not revoked")
+	}
+	require.False(t, e2.IsLeader())
+	require.NoError(t, e2.Campaign(ctx))
+	require.True(t, e2.IsLeader())
+
+	// The sessions are closed by the resignations and the new terms.
+	require.NoError(t, e2.Resign(ctx))
+	leases, err := cli.Leases(ctx)
+	require.NoError(t, err)
+	require.Empty(t, leases.Leases)
+}
+
+func TestEtcdDLock_Renewal(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	lock, err := EtcdDLock(context.TODO(), cli,
+		WithEtcdDLockTTL(2*time.Second),
+		WithEtcdDLockKeys("testKey6", "testKey7"),
+		WithEtcdDLockToken("test1"),
+	)
+	require.NoError(t, err)
+	require.Error(t, lock.Renewal(5*time.Second))
+	_, err = EtcdDLock(context.TODO(), cli,
+		WithEtcdDLockTTL(500*time.Millisecond),
+		WithEtcdDLockKeys("testKey6"),
+	)
+	require.Error(t, err)
+	fence, err := lock.LockWithFence()
+	require.NoError(t, err)
+	prevLeaseID, _ := lock.(*etcdDLock).loadLease()
+
+	// Re-grant the lease with the new TTL.
+	require.Error(t, lock.Renewal(500*time.Millisecond))
+	// The fractional TTL is rounded up.
+	require.NoError(t, lock.Renewal(2500*time.Millisecond))
+	_, leaseTTL := lock.(*etcdDLock).loadLease()
+	require.Equal(t, int64(3), leaseTTL)
+	require.NoError(t, lock.Renewal(5*time.Second))
+	ttl, err := lock.TTL()
+	require.NoError(t, err)
+	require.Greater(t, ttl, 3*time.Second)
+	leaseID, _ := lock.(*etcdDLock).loadLease()
+	require.NotEqual(t, prevLeaseID, leaseID)
+	resp, err := cli.TimeToLive(context.TODO(), prevLeaseID)
+	require.NoError(t, err)
+	require.Less(t, resp.TTL, int64(0))
+
+	// Keep the lease alive with the same TTL.
+	require.NoError(t, lock.Renewal(5*time.Second))
+	prevLeaseID = leaseID
+	leaseID, _ = lock.(*etcdDLock).loadLease()
+	require.Equal(t, prevLeaseID, leaseID)
+
+	// The fencing token is not changed by the renewal.
+	kvs, err := cli.Get(context.TODO(), "testKey6")
+	require.NoError(t, err)
+	require.Equal(t, fence, uint64(kvs.Kvs[0].CreateRevision))
+	require.NoError(t, lock.Unlock())
+	kvs, err = cli.Get(context.TODO(), "testKey7")
+	require.NoError(t, err)
+	require.Empty(t, kvs.Kvs)
+
+	// The lease is lost.
+	require.NoError(t, lock.Lock())
+	leaseID, _ = lock.(*etcdDLock).loadLease()
+	_, err = cli.Revoke(context.TODO(), leaseID)
+	require.NoError(t, err)
+	require.True(t, errors.Is(lock.Renewal(2*time.Second), ErrDLockLeaseLost))
+	_, err = lock.TTL()
+	require.True(t, errors.Is(err, ErrDLockLeaseLost))
+}
+
+func TestEtcdDLock_AcquiredAtExpiry(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	lock, err := EtcdDLock(context.TODO(), cli,
+		WithEtcdDLockTTL(time.Second),
+		WithEtcdDLockKeys("testKey11"),
+	)
+	require.NoError(t, err)
+	assertAcquiredAt := func() string {
+		resp, err := cli.Get(context.TODO(), "testKey11"+acquiredAtKeySuffix)
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, 1)
+		leaseID, _ := lock.(*etcdDLock).loadLease()
+		require.Equal(t, int64(leaseID), resp.Kvs[0].Lease)
+		return string(resp.Kvs[0].Value)
+	}
+	require.NoError(t, lock.Lock())
+	acquiredAt := assertAcquiredAt()
+
+	// Put with the swapped lease and kept the acquired time.
+	require.NoError(t, lock.Lock())
+	require.Equal(t, acquiredAt, assertAcquiredAt())
+	require.NoError(t, lock.Renewal(2*time.Second))
+	require.Equal(t, acquiredAt, assertAcquiredAt())
+
+	// Expired with the lease without the release.
+	require.Eventually(t, func() bool {
+		resp, err := cli.Get(context.TODO(), "testKey11", clientv3.WithPrefix())
+		require.NoError(t, err)
+		return len(resp.Kvs) == 0
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestEtcdDLockAdmin(t *testing.T) {
+	tintegration.BeforeTest(t)
+	clusterv3 := tintegration.NewClusterV3(t, &tintegration.ClusterConfig{
+		Size: 1,
+	})
+	defer clusterv3.Terminate(t)
+	clients := make([]*clientv3.Client, 0, 2)
+	cliConstructor := tintegration.MakeSingleNodeClients(t, clusterv3, &clients)
+	cli := cliConstructor()
+	defer func() { _ = cli.Close() }()
+
+	_, err := EtcdDLockAdmin(context.TODO(), cli)
+	require.Error(t, err)
+	admin, err := EtcdDLockAdmin(context.TODO(), cli, WithEtcdDLockAdminNamespace("/app/"))
+	require.NoError(t, err)
+
+	lostC := make(chan error, 1)
+	lock, err := EtcdDLock(context.TODO(), cli,
+		WithEtcdDLockTTL(3*time.Second),
+		WithEtcdDLockKeys("testKey8", "testKey9"),
+		WithEtcdDLockNamespace("/app/"),
+		WithEtcdDLockToken("test1"),
+		WithEtcdDLockWatchdog(func(err error) {
+			lostC <- err
+		}),
+	)
+	require.NoError(t, err)
+	// Build again with the same options keeps the keys prefixed once.
+	builder := EtcdDLockBuilder(context.TODO(), cli).
+		TTL(3 * time.Second).
+		Keys("testKey8").
+		Namespace("/app/")
+	for i := 0; i < 2; i++ {
+		built, err := builder.Build()
+		require.NoError(t, err)
+		require.Equal(t, []string{"/app/testKey8"}, built.(*etcdDLock).keys)
+	}
+	beforeLocked := time.Now().Add(-time.Second)
+	require.NoError(t, lock.Lock())
+	// Reentrant acquisition keeps the acquired time.
+	require.NoError(t, lock.Lock())
+	others, err := EtcdDLock(context.TODO(), cli,
+		WithEtcdDLockTTL(3*time.Second),
+		WithEtcdDLockKeys("testKey8"),
+	)
+	require.NoError(t, err)
+	// Out of the namespace.
+	require.NoError(t, others.Lock())
+	defer func() { _ = others.Unlock() }()
+
+	infos, err := admin.List(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	for _, info := range infos {
+		require.Contains(t, []string{"testKey8", "testKey9"}, info.Key)
+		require.Equal(t, lock.(*etcdDLock).token, info.Token)
+		require.Greater(t, info.TTL, time.Duration(0))
+		require.True(t, info.AcquiredAt.After(beforeLocked))
+	}
+	info, err := admin.Inspect(context.TODO(), "testKey9")
+	require.NoError(t, err)
+	require.Equal(t, "testKey9", info.Key)
+	_, err = admin.Inspect(context.TODO(), "testKey10")
+	require.True(t, errors.Is(err, ErrDLockNotFound))
+
+	// The other keys of the lock are released with the revoked lease.
+	require.NoError(t, admin.ForceRelease(context.TODO(), "testKey8"))
+	infos, err = admin.List(context.TODO())
+	require.NoError(t, err)
+	require.Empty(t, infos)
+	resp, err := cli.Get(context.TODO(), "/app/", clientv3.WithPrefix())
+	require.NoError(t, err)
+	// The acquired time keys are expired with the revoked lease.
+	require.Empty(t, resp.Kvs)
+	select {
+	case err := <-lostC:
+		require.True(t, errors.Is(err, ErrDLockLeaseLost))
+	case <-time.After(3 * time.Second):
+		t.Fatal("watchdog did not report lost lease")
+	}
+	require.Error(t, lock.Unlock())
+}
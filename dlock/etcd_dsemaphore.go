@@ -0,0 +1,228 @@
+package dlock
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	concv3 "go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/multierr"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+var _ DSemaphore = (*etcdDSemaphore)(nil)
+
+// etcdDSemaphore puts the holder key with acquired permits as value
+// under the common prefix with the session lease. The holders are
+// ordered by the create revision, the permits are granted if the sum
+// of permits held by the holders created before (included) is not
+// greater than the limit.
+// The session is opened by the acquisition and closed by the release,
+// so its lease and keepalive are not leaked. The holder key is named by
+// the lease of the session.
+type etcdDSemaphore struct {
+	*etcdDSemaphoreOptions
+	session  *concv3.Session
+	myKey    string
+	lock     sync.Mutex
+	acquired int64
+}
+
+// openSession has to be called with the lock.
+func (sem *etcdDSemaphore) openSession() error {
+	if sem.session != nil {
+		return nil
+	}
+	session, err := concv3.NewSession(sem.client,
+		concv3.WithTTL(int(etcdLeaseTTL(sem.ttl))),
+		concv3.WithLease(clientv3.NoLease), // Grant new lease ID by new session.
+		concv3.WithContext(sem.ctx),        // Stop keeping alive if the context is cancelled.
+	)
+	if err != nil {
+		return err
+	}
+	sem.session = session
+	sem.myKey = fmt.Sprintf("%s/%x", sem.prefix, session.Lease())
+	return nil
+}
+
+// closeSession revokes the lease of the session.
+// It has to be called with the lock.
+func (sem *etcdDSemaphore) closeSession() error {
+	if sem.session == nil {
+		return nil
+	}
+	err := sem.session.Close()
+	sem.session = nil
+	return err
+}
+
+func (sem *etcdDSemaphore) tryAcquire(ctx context.Context, n int64) error {
+	resp, err := sem.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(sem.myKey), "=", 0)).
+		Then(clientv3.OpPut(sem.myKey, strconv.FormatInt(n, 10), clientv3.WithLease(sem.session.Lease()))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return infra.NewErrorStack("etcd dsemaphore holder key exists")
+	}
+	myRev := resp.Header.Revision
+
+	holders, err := sem.client.Get(ctx, sem.prefix+"/",
+		clientv3.WithPrefix(),
+		clientv3.WithMaxCreateRev(myRev),
+	)
+	if err != nil {
+		_, _ = sem.client.Delete(ctx, sem.myKey)
+		return err
+	}
+	var held int64
+	for _, kv := range holders.Kvs {
+		permits, err := strconv.ParseInt(string(kv.Value), 10, 64)
+		if err != nil {
+			continue
+		}
+		held += permits
+	}
+	if held > sem.permits {
+		if _, err = sem.client.Delete(ctx, sem.myKey); err != nil {
+			return err
+		}
+		return infra.NewErrorStack("etcd dsemaphore permits exhausted")
+	}
+	return noErr
+}
+
+func (sem *etcdDSemaphore) Acquire(ctx context.Context, n int64) error {
+	if n <= 0 || n > sem.permits {
+		return infra.NewErrorStack("etcd dsemaphore acquire with invalid permits")
+	}
+	if ctx == nil {
+		ctx = sem.ctx
+	}
+	sem.lock.Lock()
+	defer sem.lock.Unlock()
+	if sem.acquired > 0 {
+		return infra.NewErrorStack("etcd dsemaphore permits have been acquired")
+	}
+	if err := sem.openSession(); err != nil {
+		return infra.WrapErrorStackWithMessage(err, "etcd dsemaphore open session failed")
+	}
+	if err := retryAcquire(ctx, sem.strategy, func(ctx context.Context) error {
+		return sem.tryAcquire(ctx, n)
+	}); err != nil {
+		return infra.WrapErrorStackWithMessage(multierr.Append(err, sem.closeSession()), "etcd dsemaphore acquire failed")
+	}
+	sem.acquired = n
+	return noErr
+}
+
+func (sem *etcdDSemaphore) Release() error {
+	sem.lock.Lock()
+	defer sem.lock.Unlock()
+	if sem.acquired <= 0 {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to release a no acquired dsemaphore")
+	}
+	if _, err := sem.client.Delete(sem.ctx, sem.myKey); err != nil {
+		return infra.WrapErrorStack(err)
+	}
+	sem.acquired = 0
+	return infra.WrapErrorStack(sem.closeSession())
+}
+
+type etcdDSemaphoreOptions struct {
+	client   *clientv3.Client
+	ctx      context.Context
+	strategy RetryStrategy
+	prefix   string
+	permits  int64
+	ttl      time.Duration
+}
+
+func EtcdDSemaphoreBuilder(ctx context.Context, client *clientv3.Client) *etcdDSemaphoreOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &etcdDSemaphoreOptions{client: client, ctx: ctx}
+}
+
+// TTL sets the session lease TTL of the holder.
+func (opt *etcdDSemaphoreOptions) TTL(ttl time.Duration) *etcdDSemaphoreOptions {
+	opt.ttl = ttl
+	return opt
+}
+
+func (opt *etcdDSemaphoreOptions) Key(prefix string) *etcdDSemaphoreOptions {
+	opt.prefix = prefix
+	return opt
+}
+
+// Permits sets the max number of permits held by all holders.
+func (opt *etcdDSemaphoreOptions) Permits(permits int64) *etcdDSemaphoreOptions {
+	opt.permits = permits
+	return opt
+}
+
+func (opt *etcdDSemaphoreOptions) Retry(strategy RetryStrategy) *etcdDSemaphoreOptions {
+	opt.strategy = strategy
+	return opt
+}
+
+func (opt *etcdDSemaphoreOptions) Build() (DSemaphore, error) {
+	if opt.client == nil {
+		return nil, infra.NewErrorStack("etcd dsemaphore client is nil")
+	}
+	if opt.ttl.Seconds() < 1 {
+		return nil, infra.NewErrorStack("etcd dsemaphore with zero second TTL")
+	}
+	if len(opt.prefix) <= 0 {
+		return nil, infra.NewErrorStack("etcd dsemaphore with empty key")
+	}
+	if opt.permits <= 0 {
+		return nil, infra.NewErrorStack("etcd dsemaphore with zero permits")
+	}
+	if opt.strategy == nil {
+		opt.strategy = NoRetry()
+	}
+	return &etcdDSemaphore{etcdDSemaphoreOptions: opt}, nil
+}
+
+type EtcdDSemaphoreOption func(opt *etcdDSemaphoreOptions)
+
+func WithEtcdDSemaphoreTTL(ttl time.Duration) EtcdDSemaphoreOption {
+	return func(opt *etcdDSemaphoreOptions) {
+		opt.TTL(ttl)
+	}
+}
+
+func WithEtcdDSemaphoreKey(prefix string) EtcdDSemaphoreOption {
+	return func(opt *etcdDSemaphoreOptions) {
+		opt.Key(prefix)
+	}
+}
+
+func WithEtcdDSemaphorePermits(permits int64) EtcdDSemaphoreOption {
+	return func(opt *etcdDSemaphoreOptions) {
+		opt.Permits(permits)
+	}
+}
+
+func WithEtcdDSemaphoreRetry(strategy RetryStrategy) EtcdDSemaphoreOption {
+	return func(opt *etcdDSemaphoreOptions) {
+		opt.Retry(strategy)
+	}
+}
+
+func EtcdDSemaphore(ctx context.Context, client *clientv3.Client, opts ...EtcdDSemaphoreOption) (DSemaphore, error) {
+	builderOpts := EtcdDSemaphoreBuilder(ctx, client)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
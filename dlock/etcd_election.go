@@ -0,0 +1,247 @@
+package dlock
+
+// References:
+// https://github.com/etcd-io/etcd/blob/main/client/v3/concurrency/election.go
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	concv3 "go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/multierr"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+var _ LeaderElector = (*etcdLeaderElector)(nil)
+
+// etcdLeaderElector campaigns by the etcd election with the session
+// lease. The leadership will be revoked once the session is done,
+// and the next campaign will grant a new session. The session is closed
+// by the resignation, so the leases are not piled up across the terms.
+type etcdLeaderElector struct {
+	*etcdLeaderElectorOptions
+	session  *concv3.Session
+	election *concv3.Election
+	termC    chan struct{}
+	lock     sync.Mutex
+	leader   atomic.Bool
+}
+
+// newTerm grants a new session if there is no session or the previous
+// one is done, the previous one is closed.
+// It has to be called with the lock.
+func (e *etcdLeaderElector) newTerm() error {
+	if e.session != nil {
+		select {
+		case <-e.session.Done():
+			// The lease may have been revoked or expired.
+			_ = e.closeTerm()
+		default:
+			return noErr
+		}
+	}
+	session, err := concv3.NewSession(e.client,
+		concv3.WithTTL(int(etcdLeaseTTL(e.ttl))),
+		concv3.WithLease(clientv3.NoLease), // Grant new lease ID by new session.
+		concv3.WithContext(e.ctx),          // Stop keeping alive if the context is cancelled.
+	)
+	if err != nil {
+		return err
+	}
+	e.session = session
+	e.election = concv3.NewElection(session, e.prefix)
+	return noErr
+}
+
+// closeTerm closes the session to revoke its lease and stop its keepalive.
+// It has to be called with the lock.
+func (e *etcdLeaderElector) closeTerm() error {
+	if e.session == nil {
+		return noErr
+	}
+	err := e.session.Close()
+	e.session, e.election = nil, nil
+	return err
+}
+
+// Campaign and Resign call the handlers outside the lock, so it
+// is safe to campaign or resign in the handlers.
+func (e *etcdLeaderElector) Campaign(ctx context.Context) error {
+	if ctx == nil {
+		ctx = e.ctx
+	}
+	e.lock.Lock()
+	if e.leader.Load() {
+		e.lock.Unlock()
+		return noErr
+	}
+	if err := e.newTerm(); err != nil {
+		e.lock.Unlock()
+		return infra.WrapErrorStackWithMessage(err, "etcd leader campaign failed")
+	}
+	if err := e.election.Campaign(ctx, e.candidate); err != nil {
+		e.lock.Unlock()
+		return infra.WrapErrorStackWithMessage(err, "etcd leader campaign failed")
+	}
+	termC := make(chan struct{})
+	e.termC = termC
+	e.leader.Store(true)
+	go e.watch(e.session, termC)
+	e.lock.Unlock()
+
+	if e.onElected != nil {
+		e.onElected()
+	}
+	return noErr
+}
+
+// watch revokes the leadership if the session is done during the term.
+func (e *etcdLeaderElector) watch(session *concv3.Session, termC chan struct{}) {
+	select {
+	case <-termC:
+	case <-session.Done():
+		e.lock.Lock()
+		select {
+		case <-termC:
+			// Resigned.
+			e.lock.Unlock()
+			return
+		default:
+		}
+		close(termC)
+		e.leader.Store(false)
+		e.lock.Unlock()
+
+		if e.onRevoked != nil {
+			e.onRevoked(infra.WrapErrorStackWithMessage(ErrDLockLeaseLost, "etcd leader session done"))
+		}
+	}
+}
+
+func (e *etcdLeaderElector) Resign(ctx context.Context) error {
+	if ctx == nil {
+		ctx = e.ctx
+	}
+	e.lock.Lock()
+	if !e.leader.Load() {
+		e.lock.Unlock()
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "etcd leader resign without leadership")
+	}
+	close(e.termC)
+	err := multierr.Append(e.election.Resign(ctx), e.closeTerm())
+	e.leader.Store(false)
+	e.lock.Unlock()
+
+	if e.onRevoked != nil {
+		e.onRevoked(nil)
+	}
+	if err != nil {
+		return infra.WrapErrorStack(err)
+	}
+	return noErr
+}
+
+func (e *etcdLeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+type etcdLeaderElectorOptions struct {
+	client    *clientv3.Client
+	ctx       context.Context
+	onElected LeaderElectedHandler
+	onRevoked LeaderRevokedHandler
+	prefix    string
+	candidate string
+	ttl       time.Duration
+}
+
+func EtcdLeaderElectorBuilder(ctx context.Context, client *clientv3.Client) *etcdLeaderElectorOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &etcdLeaderElectorOptions{client: client, ctx: ctx}
+}
+
+// TTL sets the session lease TTL of the leadership.
+func (opt *etcdLeaderElectorOptions) TTL(ttl time.Duration) *etcdLeaderElectorOptions {
+	opt.ttl = ttl
+	return opt
+}
+
+func (opt *etcdLeaderElectorOptions) Key(prefix string) *etcdLeaderElectorOptions {
+	opt.prefix = prefix
+	return opt
+}
+
+// Candidate sets the identity of this candidate, such as the hostname.
+func (opt *etcdLeaderElectorOptions) Candidate(candidate string) *etcdLeaderElectorOptions {
+	opt.candidate = candidate
+	return opt
+}
+
+func (opt *etcdLeaderElectorOptions) OnElected(handler LeaderElectedHandler) *etcdLeaderElectorOptions {
+	opt.onElected = handler
+	return opt
+}
+
+func (opt *etcdLeaderElectorOptions) OnRevoked(handler LeaderRevokedHandler) *etcdLeaderElectorOptions {
+	opt.onRevoked = handler
+	return opt
+}
+
+func (opt *etcdLeaderElectorOptions) Build() (LeaderElector, error) {
+	if opt.client == nil {
+		return nil, infra.NewErrorStack("etcd leader elector client is nil")
+	}
+	if opt.ttl.Seconds() < 1 {
+		return nil, infra.NewErrorStack("etcd leader elector with zero second TTL")
+	}
+	if len(opt.prefix) <= 0 {
+		return nil, infra.NewErrorStack("etcd leader elector with empty key")
+	}
+	return &etcdLeaderElector{etcdLeaderElectorOptions: opt}, nil
+}
+
+type EtcdLeaderElectorOption func(opt *etcdLeaderElectorOptions)
+
+func WithEtcdLeaderElectorTTL(ttl time.Duration) EtcdLeaderElectorOption {
+	return func(opt *etcdLeaderElectorOptions) {
+		opt.TTL(ttl)
+	}
+}
+
+func WithEtcdLeaderElectorKey(prefix string) EtcdLeaderElectorOption {
+	return func(opt *etcdLeaderElectorOptions) {
+		opt.Key(prefix)
+	}
+}
+
+func WithEtcdLeaderElectorCandidate(candidate string) EtcdLeaderElectorOption {
+	return func(opt *etcdLeaderElectorOptions) {
+		opt.Candidate(candidate)
+	}
+}
+
+func WithEtcdLeaderElectorOnElected(handler LeaderElectedHandler) EtcdLeaderElectorOption {
+	return func(opt *etcdLeaderElectorOptions) {
+		opt.OnElected(handler)
+	}
+}
+
+func WithEtcdLeaderElectorOnRevoked(handler LeaderRevokedHandler) EtcdLeaderElectorOption {
+	return func(opt *etcdLeaderElectorOptions) {
+		opt.OnRevoked(handler)
+	}
+}
+
+func EtcdLeaderElector(ctx context.Context, client *clientv3.Client, opts ...EtcdLeaderElectorOption) (LeaderElector, error) {
+	builderOpts := EtcdLeaderElectorBuilder(ctx, client)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
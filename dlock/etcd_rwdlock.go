@@ -0,0 +1,257 @@
+package dlock
+
+// References:
+// https://github.com/etcd-io/etcd/blob/main/client/v3/experimental/recipes/rwmutex.go
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	concv3 "go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/multierr"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+const (
+	etcdRWDLockReadPrefix  = "/read/"
+	etcdRWDLockWritePrefix = "/write/"
+)
+
+var _ RWDLocker = (*etcdRWDLock)(nil)
+
+// etcdRWDLock puts the readers and writers keys under the common prefix
+// with the session lease. The lock is ordered by the create revision:
+//  1. A reader is blocked by the writers created before it.
+//  2. A writer is blocked by the readers and writers created before it.
+//
+// The same session is able to downgrade or upgrade the lock.
+// The session is opened by the first lock and closed once both the read
+// and write locks are released, so its lease and keepalive are not
+// leaked. The keys are named by the lease of the session.
+type etcdRWDLock struct {
+	*etcdRWDLockOptions
+	sessionLock sync.Mutex
+	session     *concv3.Session
+	readKey     string
+	writeKey    string
+	rlocked     atomic.Bool
+	wlocked     atomic.Bool
+}
+
+// openSession returns the session and the keys named by its lease.
+func (dl *etcdRWDLock) openSession() (clientv3.LeaseID, string, string, error) {
+	dl.sessionLock.Lock()
+	defer dl.sessionLock.Unlock()
+	if dl.session == nil {
+		session, err := concv3.NewSession(dl.client,
+			concv3.WithTTL(int(etcdLeaseTTL(dl.ttl))),
+			concv3.WithLease(clientv3.NoLease), // Grant new lease ID by new session.
+			concv3.WithContext(dl.ctx),         // Stop keeping alive if the context is cancelled.
+		)
+		if err != nil {
+			return clientv3.NoLease, "", "", err
+		}
+		dl.session = session
+		dl.readKey = fmt.Sprintf("%s%s%x", dl.prefix, etcdRWDLockReadPrefix, session.Lease())
+		dl.writeKey = fmt.Sprintf("%s%s%x", dl.prefix, etcdRWDLockWritePrefix, session.Lease())
+	}
+	return dl.session.Lease(), dl.readKey, dl.writeKey, nil
+}
+
+// closeSession closes the session if neither the read nor the write
+// lock is held. The lease is revoked by the close.
+func (dl *etcdRWDLock) closeSession() error {
+	dl.sessionLock.Lock()
+	defer dl.sessionLock.Unlock()
+	if dl.session == nil || dl.rlocked.Load() || dl.wlocked.Load() {
+		return nil
+	}
+	err := dl.session.Close()
+	dl.session = nil
+	return err
+}
+
+// tryAcquire puts the key with session lease if it is absent and returns
+// the create revision of the key and whether the key is created by this call.
+func (dl *etcdRWDLock) tryAcquire(ctx context.Context, leaseID clientv3.LeaseID, key string) (int64, bool, error) {
+	resp, err := dl.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, "", clientv3.WithLease(leaseID))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return 0, false, err
+	}
+	if resp.Succeeded {
+		return resp.Header.Revision, true, nil
+	}
+	kvs := resp.Responses[0].GetResponseRange().GetKvs()
+	if len(kvs) <= 0 {
+		return 0, false, infra.NewErrorStack("etcd rw dlock key lost during acquisition")
+	}
+	return kvs[0].CreateRevision, false, nil
+}
+
+// acquire checks whether there are blockers (created before the key)
+// under the prefix. The key is kept across the retries, so the waiters
+// are ordered by its create revision instead of a new one by each
+// attempt, and the writer is not starved by the readers coming later.
+// The key will be deleted if the acquisition fails and it is created by
+// the acquisition.
+func (dl *etcdRWDLock) acquire(leaseID clientv3.LeaseID, key, blockerPrefix, ownKey string) error {
+	var created bool
+	err := retryAcquire(dl.ctx, dl.strategy, func(ctx context.Context) error {
+		// The key is put once, the create revision is unchanged.
+		rev, ok, err := dl.tryAcquire(ctx, leaseID, key)
+		if err != nil {
+			return err
+		}
+		created = created || ok
+		resp, err := dl.client.Get(ctx, blockerPrefix,
+			clientv3.WithPrefix(),
+			clientv3.WithMaxCreateRev(rev-1),
+			clientv3.WithKeysOnly(),
+		)
+		if err != nil {
+			return err
+		}
+		for _, kv := range resp.Kvs {
+			if string(kv.Key) == ownKey || string(kv.Key) == key {
+				continue
+			}
+			return infra.NewErrorStack(fmt.Sprintf("etcd rw dlock occupied by %s", kv.Key))
+		}
+		return noErr
+	})
+	if err != nil && created {
+		if _, derr := dl.client.Delete(dl.ctx, key); derr != nil {
+			return multierr.Append(err, derr)
+		}
+	}
+	return err
+}
+
+func (dl *etcdRWDLock) RLock() error {
+	leaseID, readKey, writeKey, err := dl.openSession()
+	if err != nil {
+		return infra.WrapErrorStackWithMessage(err, "etcd rw dlock open session failed")
+	}
+	if err = dl.acquire(leaseID, readKey, dl.prefix+etcdRWDLockWritePrefix, writeKey); err != nil {
+		return infra.WrapErrorStackWithMessage(multierr.Append(err, dl.closeSession()), "etcd rw dlock read lock failed")
+	}
+	dl.rlocked.Store(true)
+	return noErr
+}
+
+func (dl *etcdRWDLock) RUnlock() error {
+	if !dl.rlocked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to read unlock a no init rw dlock")
+	}
+	if _, err := dl.client.Delete(dl.ctx, dl.readKey); err != nil {
+		return infra.WrapErrorStack(err)
+	}
+	dl.rlocked.Store(false)
+	return infra.WrapErrorStack(dl.closeSession())
+}
+
+func (dl *etcdRWDLock) Lock() error {
+	leaseID, readKey, writeKey, err := dl.openSession()
+	if err != nil {
+		return infra.WrapErrorStackWithMessage(err, "etcd rw dlock open session failed")
+	}
+	if err = dl.acquire(leaseID, writeKey, dl.prefix+"/", readKey); err != nil {
+		return infra.WrapErrorStackWithMessage(multierr.Append(err, dl.closeSession()), "etcd rw dlock write lock failed")
+	}
+	dl.wlocked.Store(true)
+	return noErr
+}
+
+func (dl *etcdRWDLock) Unlock() error {
+	if !dl.wlocked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to write unlock a no init rw dlock")
+	}
+	if _, err := dl.client.Delete(dl.ctx, dl.writeKey); err != nil {
+		return infra.WrapErrorStack(err)
+	}
+	dl.wlocked.Store(false)
+	return infra.WrapErrorStack(dl.closeSession())
+}
+
+type etcdRWDLockOptions struct {
+	client   *clientv3.Client
+	ctx      context.Context
+	strategy RetryStrategy
+	prefix   string
+	ttl      time.Duration
+}
+
+func EtcdRWDLockBuilder(ctx context.Context, client *clientv3.Client) *etcdRWDLockOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &etcdRWDLockOptions{client: client, ctx: ctx}
+}
+
+func (opt *etcdRWDLockOptions) TTL(ttl time.Duration) *etcdRWDLockOptions {
+	opt.ttl = ttl
+	return opt
+}
+
+func (opt *etcdRWDLockOptions) Key(prefix string) *etcdRWDLockOptions {
+	opt.prefix = prefix
+	return opt
+}
+
+func (opt *etcdRWDLockOptions) Retry(strategy RetryStrategy) *etcdRWDLockOptions {
+	opt.strategy = strategy
+	return opt
+}
+
+func (opt *etcdRWDLockOptions) Build() (RWDLocker, error) {
+	if opt.client == nil {
+		return nil, infra.NewErrorStack("etcd rw dlock client is nil")
+	}
+	if opt.ttl.Seconds() < 1 {
+		return nil, infra.NewErrorStack("etcd rw dlock with zero second TTL")
+	}
+	if len(opt.prefix) <= 0 {
+		return nil, infra.NewErrorStack("etcd rw dlock with empty key")
+	}
+	if opt.strategy == nil {
+		opt.strategy = NoRetry()
+	}
+	return &etcdRWDLock{etcdRWDLockOptions: opt}, nil
+}
+
+type EtcdRWDLockOption func(opt *etcdRWDLockOptions)
+
+func WithEtcdRWDLockTTL(ttl time.Duration) EtcdRWDLockOption {
+	return func(opt *etcdRWDLockOptions) {
+		opt.TTL(ttl)
+	}
+}
+
+func WithEtcdRWDLockKey(prefix string) EtcdRWDLockOption {
+	return func(opt *etcdRWDLockOptions) {
+		opt.Key(prefix)
+	}
+}
+
+func WithEtcdRWDLockRetry(strategy RetryStrategy) EtcdRWDLockOption {
+	return func(opt *etcdRWDLockOptions) {
+		opt.Retry(strategy)
+	}
+}
+
+func EtcdRWDLock(ctx context.Context, client *clientv3.Client, opts ...EtcdRWDLockOption) (RWDLocker, error) {
+	builderOpts := EtcdRWDLockBuilder(ctx, client)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
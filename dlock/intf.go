@@ -1,23 +1,117 @@
 package dlock
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type DLocker interface {
 	Lock() error
+	// LockContext acquires the lock until it succeeds, the retry
+	// strategy reaches to max or the context is cancelled.
+	LockContext(ctx context.Context) error
+	// TryLock attempts to acquire the lock once without retry.
+	// It returns false with nil error if the lock is occupied.
+	// The acquisition is bounded by the TTL without the caller context,
+	// and so is LockWithFence.
+	TryLock() (bool, error)
+	// LockWithFence acquires the lock and returns a fencing token.
+	// The fencing token is increased monotonically by each acquisition
+	// of the (first) lock key, so the downstream storage is able to
+	// reject the writes with stale tokens.
+	LockWithFence() (uint64, error)
 	Unlock() error
+	UnlockContext(ctx context.Context) error
 	Renewal(newTTL time.Duration) error
 	TTL() (time.Duration, error)
 }
 
+// RWDLocker is a shared/exclusive distributed lock.
+// Many readers are able to hold the lock at the same time,
+// but the writer holds the lock exclusively.
+type RWDLocker interface {
+	RLock() error
+	RUnlock() error
+	Lock() error
+	Unlock() error
+}
+
+// DSemaphore is a distributed counting semaphore which caps
+// the concurrent holders across processes.
+type DSemaphore interface {
+	// Acquire acquires n permits until it succeeds, the retry
+	// strategy reaches to max or the context is cancelled.
+	Acquire(ctx context.Context, n int64) error
+	// Release releases all permits held by this holder.
+	Release() error
+}
+
+// LeaderElector campaigns for the leadership across processes,
+// so only one candidate runs the singleton work at the same time.
+type LeaderElector interface {
+	// Campaign blocks until this candidate is elected, the retry
+	// strategy reaches to max or the context is cancelled.
+	Campaign(ctx context.Context) error
+	// Resign gives up the leadership.
+	Resign(ctx context.Context) error
+	IsLeader() bool
+}
+
+// LeaderElectedHandler will be called once the candidate is elected.
+type LeaderElectedHandler func()
+
+// LeaderRevokedHandler will be called once the leadership is revoked.
+// The err is nil if the leader resigns by itself, otherwise, it wraps
+// the ErrDLockLeaseLost.
+type LeaderRevokedHandler func(err error)
+
+// RetryStrategy returns the backoff of the next retry.
+// The backoff less than 1ms means no more retry.
 type RetryStrategy interface {
 	Next() time.Duration
 }
 
+// RetryStrategyFactory creates a strategy with fresh state for each
+// acquisition, so the stateful strategy is reusable across acquisitions.
+// The lockers call NewAttempt before each acquisition if the strategy
+// implements it.
+type RetryStrategyFactory interface {
+	NewAttempt() RetryStrategy
+}
+
+// DLockInfo is the holder metadata of a locked key.
+type DLockInfo struct {
+	// Key is the lock key without the namespace.
+	Key   string
+	Token string
+	TTL   time.Duration
+	// AcquiredAt is zero if the lock is acquired without the metadata.
+	AcquiredAt time.Time
+}
+
+// DLockAdmin inspects and force-releases the dlocks under the namespace.
+// The keys are without the namespace, the same as the lockers' keys.
+type DLockAdmin interface {
+	// List returns the held locks under the namespace.
+	List(ctx context.Context) ([]DLockInfo, error)
+	// Inspect returns the ErrDLockNotFound if the key is not held.
+	Inspect(ctx context.Context, key string) (DLockInfo, error)
+	// ForceRelease releases the keys whoever holds them. The holders'
+	// watchdog will regard their leases as lost.
+	ForceRelease(ctx context.Context, keys ...string) error
+}
+
+// LeaseLostHandler will be called by the watchdog if the dlock's
+// lease is lost, so the holder is able to abort its critical section.
+type LeaseLostHandler func(err error)
+
 type DLockErr string
 
 const (
 	ErrDLockAcquireFailed DLockErr = "failed to acquire dlock"
 	ErrDLockNoInit        DLockErr = "no init the dlock"
+	ErrDLockLeaseLost     DLockErr = "dlock lease lost"
+	ErrDLockNotFound      DLockErr = "dlock not found"
 )
 
 var (
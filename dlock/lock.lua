@@ -2,14 +2,36 @@
 -- https://www.redisio.com/en/redis-lua.html
 --
 -- Try to set keys with values and time to live (in seconds) if they don't exist. They will be used as a lock.
--- lock.lua value tokenLength ttl
+-- lock.lua value tokenLength ttl lockKeyCount waiterTTL acquiredAtSuffix
+--
+-- KEYS layout:
+-- KEYS[1..lockKeyCount] are the lock keys.
+-- KEYS[lockKeyCount+1] is the fencing token counter key (optional).
+-- KEYS[lockKeyCount+2] is the waiters queue key ordered by arrival (optional, fair mode).
+-- KEYS[lockKeyCount+3] is the waiters expiry key (optional, fair mode).
+
+local lockKeyCount = tonumber(ARGV[4]) or #KEYS
+local lockKeys = {}
+for i = 1, lockKeyCount do
+    table.insert(lockKeys, KEYS[i])
+end
+local fenceKey = KEYS[lockKeyCount + 1]
+local queueKey = KEYS[lockKeyCount + 2]
+local waitersKey = KEYS[lockKeyCount + 3]
+local waiterTTL = tonumber(ARGV[5])
+local isFair = queueKey and waitersKey and waiterTTL
+-- The acquired time metadata key is the lock key with the suffix (optional).
+local acquiredAtSuffix = ARGV[6]
 
 -- PEXIRE key milliseconds
 -- 1: OK
 -- 0: Not exist or set failed.
 local function updateLockTTL(ttl)
-    for _, k in ipairs(KEYS) do
+    for _, k in ipairs(lockKeys) do
         redis.call("PEXPIRE", k, ttl)
+        if acquiredAtSuffix then
+            redis.call("PEXPIRE", k .. acquiredAtSuffix, ttl)
+        end
     end
 end
 
@@ -17,7 +39,7 @@ end
 -- If start/end is negative, it means the start position is the end of the string.
 local function isReentrant()
     local offset = tonumber(ARGV[2])
-    for _, k in ipairs(KEYS) do
+    for _, k in ipairs(lockKeys) do
         if redis.call("GETRANGE", k, 0, offset - 1) ~= string.sub(ARGV[1], 1, offset) then
             return false
         end
@@ -26,9 +48,38 @@ local function isReentrant()
 end
 
 
+-- Reentrant, the keys have been held by the same token.
+local reentrant = isReentrant()
+
+-- Fair mode, the waiters are granted the lock in arrival order.
+-- A waiter will be regarded as stale and removed from the queue
+-- if it doesn't retry in waiterTTL milliseconds.
+if isFair and not reentrant then
+    -- TIME
+    -- 1: Unix timestamp in seconds.
+    -- 2: Microseconds.
+    local now = redis.call("TIME")
+    local nowUs = tonumber(now[1]) * 1000000 + tonumber(now[2])
+    local nowMs = math.floor(nowUs / 1000)
+    local staleWaiters = redis.call("ZRANGEBYSCORE", waitersKey, "-inf", nowMs)
+    for _, w in ipairs(staleWaiters) do
+        redis.call("ZREM", queueKey, w)
+        redis.call("ZREM", waitersKey, w)
+    end
+    -- ZADD key NX score member
+    -- Keep the arrival order of the waiter.
+    redis.call("ZADD", queueKey, "NX", nowUs, ARGV[1])
+    redis.call("ZADD", waitersKey, nowMs + waiterTTL, ARGV[1])
+    redis.call("PEXPIRE", queueKey, waiterTTL)
+    redis.call("PEXPIRE", waitersKey, waiterTTL)
+    if redis.call("ZRANGE", queueKey, 0, 0)[1] ~= ARGV[1] then
+        return redis.error_reply("dlock queued")
+    end
+end
+
 -- Start to lock keys as a distributed lock.
 local argvSet = {}
-for _, k in ipairs(KEYS) do
+for _, k in ipairs(lockKeys) do
     table.insert(argvSet, k)
     table.insert(argvSet, ARGV[1])
 end
@@ -37,22 +88,36 @@ end
 -- 1: OK
 -- 0: One of the keys exist or set failed.
 --
--- MSET key1 value1 key2 value2 ...
--- Always return OK.
---
 -- Check the lock if it has been occupied.
 -- Lua scripts is atomic and can't be interrupted.
 -- So the set key and set expire operation divded
 -- into two steps is fine.
 -- Redis Lua5.1 only support unpack() function,
 -- so we can't use table.unpack() here.
-if redis.call("MSETNX", unpack(argvSet)) == 0 then
+if not reentrant and redis.call("MSETNX", unpack(argvSet)) == 0 then
     return redis.error_reply("dlock occupied")
 end
-if not isReentrant() then
-    return redis.error_reply("dlock reentrant failed")
+-- SET key value PX milliseconds
+-- Records the acquired time (in milliseconds) for the inspection,
+-- and it lives as long as the lock key.
+if acquiredAtSuffix and not reentrant then
+    local now = redis.call("TIME")
+    local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+    for _, k in ipairs(lockKeys) do
+        redis.call("SET", k .. acquiredAtSuffix, nowMs, "PX", ARGV[3])
+    end
 end
--- Really acquires a lock.
-redis.call("MSET", unpack(argvSet))
+-- Really acquires (or reenters) a lock.
 updateLockTTL(ARGV[3])
+if isFair then
+    redis.call("ZREM", queueKey, ARGV[1])
+    redis.call("ZREM", waitersKey, ARGV[1])
+end
+
+-- INCR key
+-- The fencing token is increased monotonically by each
+-- acquisition and never expires.
+if fenceKey then
+    return redis.call("INCR", fenceKey)
+end
 return redis.status_reply("OK")
@@ -0,0 +1,8 @@
+-- Remove the waiter from the fair queue if it gives up.
+-- lockdequeue.lua value
+--
+-- KEYS[1] is the waiters queue key.
+-- KEYS[2] is the waiters expiry key.
+redis.call("ZREM", KEYS[1], ARGV[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+return redis.status_reply("OK")
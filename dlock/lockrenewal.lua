@@ -16,6 +16,10 @@ end
 local function updateLockTTL(ttl)
     for _, k in ipairs(KEYS) do
         redis.call("PEXPIRE", k, ttl)
+        -- The acquired time metadata key (optional).
+        if ARGV[3] then
+            redis.call("PEXPIRE", k .. ARGV[3], ttl)
+        end
     end
 end
 
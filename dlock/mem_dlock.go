@@ -0,0 +1,343 @@
+package dlock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+// memDLockLease is the lease of a locked key.
+type memDLockLease struct {
+	token     string
+	expiredAt time.Time
+}
+
+// MemDLockRegistry is a process-local keyed registry with expiring
+// leases. The in-memory dlocks built by the same registry contend
+// for the same keys.
+type MemDLockRegistry struct {
+	lock   sync.Mutex
+	clock  hrtime.Clock
+	leases map[string]*memDLockLease
+	fences map[string]uint64
+}
+
+// NewMemDLockRegistry creates a registry with the clock to expire
+// the leases. The SDK clock will be used if the clock is nil.
+func NewMemDLockRegistry(clock hrtime.Clock) *MemDLockRegistry {
+	if clock == nil {
+		clock = hrtime.SdkClock
+	}
+	return &MemDLockRegistry{
+		clock:  clock,
+		leases: make(map[string]*memDLockLease),
+		fences: make(map[string]uint64),
+	}
+}
+
+var defaultMemDLockRegistry = NewMemDLockRegistry(nil)
+
+// loadLeases returns the alive leases of the keys, and the expired
+// leases are removed.
+// Must be called with the lock held.
+func (r *MemDLockRegistry) loadLeases(now time.Time, keys []string) []*memDLockLease {
+	leases := make([]*memDLockLease, len(keys))
+	for i, key := range keys {
+		lease, ok := r.leases[key]
+		if !ok {
+			continue
+		}
+		if !lease.expiredAt.After(now) {
+			delete(r.leases, key)
+			continue
+		}
+		leases[i] = lease
+	}
+	return leases
+}
+
+// isHeldBy checks all keys are held by the token.
+// Must be called with the lock held.
+func (r *MemDLockRegistry) isHeldBy(now time.Time, keys []string, token string) bool {
+	for _, lease := range r.loadLeases(now, keys) {
+		if lease == nil || lease.token != token {
+			return false
+		}
+	}
+	return true
+}
+
+// acquire locks all keys if they are free or held by the token
+// (reentrant), and returns the fencing token of the first key.
+func (r *MemDLockRegistry) acquire(keys []string, token string, ttl time.Duration) (uint64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.clock.NowInUTC()
+	for _, lease := range r.loadLeases(now, keys) {
+		if lease != nil && lease.token != token {
+			return 0, infra.NewErrorStack("dlock occupied")
+		}
+	}
+	for _, key := range keys {
+		r.leases[key] = &memDLockLease{token: token, expiredAt: now.Add(ttl)}
+	}
+	r.fences[keys[0]]++
+	return r.fences[keys[0]], noErr
+}
+
+func (r *MemDLockRegistry) renewal(keys []string, token string, ttl time.Duration) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.clock.NowInUTC()
+	if !r.isHeldBy(now, keys, token) {
+		return infra.NewErrorStack("dlock token mismatch, unable to refresh")
+	}
+	for _, key := range keys {
+		r.leases[key].expiredAt = now.Add(ttl)
+	}
+	return noErr
+}
+
+// ttl returns the min TTL of the keys.
+func (r *MemDLockRegistry) ttl(keys []string, token string) (time.Duration, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.clock.NowInUTC()
+	if !r.isHeldBy(now, keys, token) {
+		return 0, infra.NewErrorStack("dlock token mismatch, unable to fetch ttl")
+	}
+	var minTTL time.Duration
+	for _, key := range keys {
+		if ttl := r.leases[key].expiredAt.Sub(now); minTTL == 0 || ttl < minTTL {
+			minTTL = ttl
+		}
+	}
+	return minTTL, noErr
+}
+
+func (r *MemDLockRegistry) release(keys []string, token string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.isHeldBy(r.clock.NowInUTC(), keys, token) {
+		return infra.NewErrorStack("dlock token mismatch, unable to unlock")
+	}
+	for _, key := range keys {
+		delete(r.leases, key)
+	}
+	return noErr
+}
+
+var _ DLocker = (*memDLock)(nil)
+
+// memDLock has the same TTL, reentrancy and multi-key semantics
+// as the redis dlock, but the keys are locked in the process.
+type memDLock struct {
+	*memDLockOptions
+	watchdog *dlockWatchdog
+	locked   atomic.Bool
+}
+
+func (dl *memDLock) Lock() error {
+	_, err := dl.LockWithFence()
+	return err
+}
+
+func (dl *memDLock) LockContext(ctx context.Context) error {
+	_, err := dl.lockWithFence(ctx)
+	return err
+}
+
+func (dl *memDLock) LockWithFence() (uint64, error) {
+	ctx, cancel := context.WithTimeout(dl.ctx, dl.ttl)
+	defer cancel()
+	return dl.lockWithFence(ctx)
+}
+
+func (dl *memDLock) lockWithFence(ctx context.Context) (uint64, error) {
+	if ctx == nil {
+		ctx = dl.ctx
+	}
+	var fence uint64
+	if err := retryAcquire(ctx, dl.strategy, func(ctx context.Context) (err error) {
+		fence, err = dl.registry.acquire(dl.keys, dl.token, dl.ttl)
+		return err
+	}); err != nil {
+		return 0, infra.WrapErrorStackWithMessage(err, "mem dlock lock failed")
+	}
+	dl.onLocked()
+	return fence, noErr
+}
+
+func (dl *memDLock) TryLock() (bool, error) {
+	if _, err := dl.registry.acquire(dl.keys, dl.token, dl.ttl); err != nil {
+		// Occupied by others.
+		return false, noErr
+	}
+	dl.onLocked()
+	return true, noErr
+}
+
+func (dl *memDLock) onLocked() {
+	dl.locked.Store(true)
+	dl.watchdog.start(dl.ctx, dl.watchdogRenewal, nil)
+}
+
+func (dl *memDLock) Renewal(newTTL time.Duration) error {
+	if newTTL.Milliseconds() <= 0 {
+		return infra.NewErrorStack("renewal dlock with zero ms TTL")
+	}
+	if !dl.locked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "renewal dlock with no lock")
+	}
+	return dl.registry.renewal(dl.keys, dl.token, newTTL)
+}
+
+func (dl *memDLock) watchdogRenewal(ctx context.Context) error {
+	if err := dl.registry.renewal(dl.keys, dl.token, dl.ttl); err != nil {
+		return infra.AppendErrorStack(ErrDLockLeaseLost, err)
+	}
+	return noErr
+}
+
+func (dl *memDLock) TTL() (time.Duration, error) {
+	if !dl.locked.Load() {
+		return 0, infra.WrapErrorStackWithMessage(ErrDLockNoInit, "fetch dlock ttl failed")
+	}
+	return dl.registry.ttl(dl.keys, dl.token)
+}
+
+func (dl *memDLock) Unlock() error {
+	return dl.UnlockContext(dl.ctx)
+}
+
+func (dl *memDLock) UnlockContext(ctx context.Context) error {
+	if !dl.locked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to unlock a no init dlock")
+	}
+	dl.watchdog.stop()
+	if err := dl.registry.release(dl.keys, dl.token); err != nil {
+		return err
+	}
+	dl.locked.Store(false)
+	return noErr
+}
+
+type memDLockOptions struct {
+	ctx         context.Context
+	registry    *MemDLockRegistry
+	strategy    RetryStrategy
+	keys        []string
+	onLeaseLost LeaseLostHandler
+	token       string
+	ttl         time.Duration
+	watchdog    bool
+}
+
+// MemDLockBuilder builds the in-memory dlock for tests and single-node
+// mode. The process-wide default registry will be used if the registry
+// is nil.
+func MemDLockBuilder(ctx context.Context, registry *MemDLockRegistry) *memDLockOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if registry == nil {
+		registry = defaultMemDLockRegistry
+	}
+	return &memDLockOptions{ctx: ctx, registry: registry}
+}
+
+func (opt *memDLockOptions) TTL(ttl time.Duration) *memDLockOptions {
+	opt.ttl = ttl
+	return opt
+}
+
+func (opt *memDLockOptions) Token(token string) *memDLockOptions {
+	opt.token = token + "&" + nano()
+	return opt
+}
+
+func (opt *memDLockOptions) Keys(keys ...string) *memDLockOptions {
+	opt.keys = make([]string, len(keys))
+	for i, key := range keys {
+		opt.keys[i] = key
+	}
+	return opt
+}
+
+func (opt *memDLockOptions) Retry(strategy RetryStrategy) *memDLockOptions {
+	opt.strategy = strategy
+	return opt
+}
+
+// Watchdog enables renewing the lock with the build TTL automatically
+// until the lock is released or the context is cancelled.
+// The onLost handler will be called if the lock's lease is lost.
+func (opt *memDLockOptions) Watchdog(onLost LeaseLostHandler) *memDLockOptions {
+	opt.watchdog = true
+	opt.onLeaseLost = onLost
+	return opt
+}
+
+func (opt *memDLockOptions) Build() (DLocker, error) {
+	if opt.ttl.Milliseconds() <= 0 {
+		return nil, infra.NewErrorStack("mem dlock with zero ms TTL")
+	}
+	if len(opt.keys) <= 0 {
+		return nil, infra.NewErrorStack("mem dlock with zero keys")
+	}
+	if opt.strategy == nil {
+		opt.strategy = NoRetry()
+	}
+	if len(opt.token) <= 0 {
+		opt.Token("")
+	}
+	dl := &memDLock{memDLockOptions: opt}
+	if opt.watchdog {
+		dl.watchdog = newDLockWatchdog(opt.ttl, opt.onLeaseLost)
+	}
+	return dl, nil
+}
+
+type MemDLockOption func(opt *memDLockOptions)
+
+func WithMemDLockTTL(ttl time.Duration) MemDLockOption {
+	return func(opt *memDLockOptions) {
+		opt.TTL(ttl)
+	}
+}
+
+func WithMemDLockKeys(keys ...string) MemDLockOption {
+	return func(opt *memDLockOptions) {
+		opt.Keys(keys...)
+	}
+}
+
+func WithMemDLockToken(token string) MemDLockOption {
+	return func(opt *memDLockOptions) {
+		opt.Token(token)
+	}
+}
+
+func WithMemDLockRetry(strategy RetryStrategy) MemDLockOption {
+	return func(opt *memDLockOptions) {
+		opt.Retry(strategy)
+	}
+}
+
+func WithMemDLockWatchdog(onLost LeaseLostHandler) MemDLockOption {
+	return func(opt *memDLockOptions) {
+		opt.Watchdog(onLost)
+	}
+}
+
+func MemDLock(ctx context.Context, registry *MemDLockRegistry, opts ...MemDLockOption) (DLocker, error) {
+	builderOpts := MemDLockBuilder(ctx, registry)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
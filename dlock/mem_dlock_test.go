@@ -0,0 +1,108 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+)
+
+type testMemClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *testMemClock) NowIn(offset hrtime.TimeZoneOffset) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *testMemClock) NowInDefaultTZ() time.Time {
+	return c.NowIn(0)
+}
+
+func (c *testMemClock) NowInUTC() time.Time {
+	return c.NowIn(0)
+}
+
+func (c *testMemClock) MonotonicElapsed() time.Duration {
+	return 0
+}
+
+func (c *testMemClock) Since(beginTime time.Time) time.Duration {
+	return c.NowIn(0).Sub(beginTime)
+}
+
+func (c *testMemClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestMemDLock(t *testing.T) {
+	clock := &testMemClock{now: time.Now()}
+	registry := NewMemDLockRegistry(clock)
+
+	lock1, err := MemDLockBuilder(context.TODO(), registry).
+		TTL(time.Second).
+		Keys("testKey1", "testKey2").
+		Token("test1").
+		Build()
+	require.NoError(t, err)
+	lock2, err := MemDLock(context.TODO(), registry,
+		WithMemDLockTTL(time.Second),
+		WithMemDLockKeys("testKey2", "testKey3"),
+		WithMemDLockToken("test2"),
+		WithMemDLockRetry(LimitedRetry(5*time.Millisecond, 2)),
+	)
+	require.NoError(t, err)
+
+	require.Error(t, lock1.Unlock())
+	_, err = lock1.TTL()
+	require.True(t, errors.Is(err, ErrDLockNoInit))
+
+	fence1, err := lock1.LockWithFence()
+	require.NoError(t, err)
+	// Reentrant.
+	fence2, err := lock1.LockWithFence()
+	require.NoError(t, err)
+	require.Greater(t, fence2, fence1)
+
+	// Multi-key conflict.
+	require.True(t, errors.Is(lock2.Lock(), ErrDLockAcquireFailed))
+	ok, err := lock2.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	clock.advance(400 * time.Millisecond)
+	ttl, err := lock1.TTL()
+	require.NoError(t, err)
+	require.Equal(t, 600*time.Millisecond, ttl)
+	require.NoError(t, lock1.Renewal(2*time.Second))
+	ttl, err = lock1.TTL()
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, ttl)
+
+	// Expired.
+	clock.advance(2 * time.Second)
+	ok, err = lock2.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	// The expired holder is not able to unlock.
+	require.Error(t, lock1.Unlock())
+	require.Error(t, lock1.Renewal(time.Second))
+	require.NoError(t, lock2.UnlockContext(context.TODO()))
+	require.NoError(t, lock1.LockContext(context.TODO()))
+	require.NoError(t, lock1.Unlock())
+
+	_, err = MemDLock(context.TODO(), nil, WithMemDLockKeys("testKey1"))
+	require.Error(t, err)
+	_, err = MemDLock(context.TODO(), nil, WithMemDLockTTL(time.Second))
+	require.Error(t, err)
+}
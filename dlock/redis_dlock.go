@@ -9,14 +9,15 @@ import (
 	"context"
 	_ "embed"
 	"errors"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
-	"go.uber.org/multierr"
 
 	"github.com/benz9527/xboot/lib/id"
 	"github.com/benz9527/xboot/lib/infra"
+	"github.com/benz9527/xboot/xlog"
 )
 
 //go:embed lock.lua
@@ -31,67 +32,212 @@ var luaDLockRenewalTTLScript string
 //go:embed lockttl.lua
 var luaDLockLoadTTLScript string
 
+//go:embed lockdequeue.lua
+var luaDLockDequeueScript string
+
 var (
 	luaDLockAcquire    = redis.NewScript(luaDLockAcquireScript)
 	luaDLockRelease    = redis.NewScript(luaDLockReleaseScript)
 	luaDLockRenewalTTL = redis.NewScript(luaDLockRenewalTTLScript)
 	luaDLockLoadTTL    = redis.NewScript(luaDLockLoadTTLScript)
+	luaDLockDequeue    = redis.NewScript(luaDLockDequeueScript)
 )
 
-const randomTokenLength = 16
+const (
+	randomTokenLength = 16
+	fenceKeySuffix    = ":fence"
+	queueKeySuffix    = ":queue"
+	waitersKeySuffix  = ":waiters"
+	releasedChSuffix  = ":released"
+	// The acquired time metadata key suffix of the lock key.
+	acquiredAtKeySuffix = ":acquired"
+)
 
 var nano, _ = id.ClassicNanoID(randomTokenLength)
 
+// redisSubscriber is implemented by the *redis.Client,
+// *redis.ClusterClient and redis.UniversalClient.
+type redisSubscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
 var _ DLocker = (*redisDLock)(nil)
 
-// TODO: Enable watchdog to renewal lock automatically
 type redisDLock struct {
 	*redisDLockOptions
-	locked atomic.Bool
+	keys     []string // The keys with the namespace.
+	watchdog *dlockWatchdog
+	stats    *dlockStats
+	locked   atomic.Bool
 }
 
 func (dl *redisDLock) Lock() error {
-	retry := dl.strategy
+	_, err := dl.LockWithFence()
+	return err
+}
+
+func (dl *redisDLock) LockContext(ctx context.Context) error {
+	_, err := dl.lockWithFence(ctx)
+	return err
+}
+
+func (dl *redisDLock) LockWithFence() (uint64, error) {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
+	return dl.lockWithFence(ctx)
+}
+
+func (dl *redisDLock) lockWithFence(ctx context.Context) (uint64, error) {
+	if ctx == nil {
+		ctx = dl.parentCtx
+	}
 	var (
-		ticker *time.Ticker
-		merr   error
-	)
-	for {
-		if _, err := luaDLockAcquire.Eval(
-			*dl.ctx.Load(), // TODO pay attention to the context has been cancelled.
-			dl.scripterLoader(),
-			dl.keys,
-			dl.token, len(dl.token), dl.ttl.Milliseconds(),
-		).Result(); err != nil && !errors.Is(err, redis.Nil) {
-			merr = multierr.Append(merr, err)
-		} else if err == nil || errors.Is(err, redis.Nil) {
-			dl.locked.Store(true)
-			return noErr
+		beginTime = time.Now()
+		attempts  int64
+		fence     uint64
+		acquire   = func(ctx context.Context) (err error) {
+			attempts++
+			fence, err = dl.acquire(ctx)
+			return err
 		}
+		err error
+	)
+	if pubsub := dl.subscribe(ctx); pubsub != nil {
+		defer func() { _ = pubsub.Close() }()
+		err = retryAcquireOrWake(ctx, dl.strategy, pubsub.Channel(), acquire)
+	} else {
+		err = retryAcquire(ctx, dl.strategy, acquire)
+	}
+	if err != nil {
+		dl.dequeue()
+		dl.stats.RecordAcquireFailed(ctx, beginTime, attempts, err)
+		return 0, infra.WrapErrorStackWithMessage(err, "redis dlock lock failed")
+	}
+	dl.onLocked()
+	dl.stats.RecordAcquired(ctx, beginTime, attempts, fence)
+	return fence, noErr
+}
 
-		backoff := retry.Next()
-		if backoff.Milliseconds() < 1 {
-			if ticker != nil {
-				return infra.WrapErrorStackWithMessage(multierr.Combine(merr, ErrDLockAcquireFailed), "redis dlock lock retry reach to max")
-			}
-			// No retry strategy.
-			return noErr
-		}
+func (dl *redisDLock) TryLock() (bool, error) {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
+	beginTime := time.Now()
+	fence, err := dl.acquire(ctx)
+	if err != nil {
+		dl.dequeue()
+	}
+	if isRedisDLockOccupied(err) {
+		dl.stats.RecordAcquireFailed(ctx, beginTime, 1, nil)
+		return false, noErr
+	} else if err != nil {
+		dl.stats.RecordAcquireFailed(ctx, beginTime, 1, err)
+		return false, infra.WrapErrorStack(err)
+	}
+	dl.onLocked()
+	dl.stats.RecordAcquired(ctx, beginTime, 1, fence)
+	return true, noErr
+}
 
-		if ticker == nil {
-			ticker = time.NewTicker(backoff)
-			defer ticker.Stop() // Avoid ticker leak.
-		} else {
-			ticker.Reset(backoff)
-		}
+func (dl *redisDLock) acquire(ctx context.Context) (uint64, error) {
+	keys := append(dl.keys, dl.fenceKey)
+	args := []any{
+		dl.token, len(dl.token), dl.ttl.Milliseconds(), len(dl.keys),
+		dl.waiterTTL.Milliseconds(), acquiredAtKeySuffix,
+	}
+	if dl.fair {
+		keys = append(keys, dl.queueKey, dl.waitersKey)
+	}
+	res, err := luaDLockAcquire.Eval(
+		ctx,
+		dl.scripterLoader(),
+		keys,
+		args...,
+	).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+	fence, _ := res.(int64)
+	return uint64(fence), noErr
+}
 
-		select {
-		case <-(*dl.ctx.Load()).Done():
-			return infra.WrapErrorStack((*dl.ctx.Load()).Err())
-		case <-ticker.C:
-			// continue
-		}
+// The error replies of the lock script if the lock is not acquired.
+const (
+	redisDLockOccupiedReply = "dlock occupied"
+	redisDLockQueuedReply   = "dlock queued"
+)
+
+// The error reply prefix of the unlock and renewal scripts if the lock
+// is held by others.
+const redisDLockMismatchedReply = "dlock token mismatch"
+
+// isRedisDLockOccupied returns true if the lock is occupied by others or
+// the waiter is queued behind the others. The other redis errors (e.g.
+// NOSCRIPT, WRONGTYPE, OOM and READONLY) are the server errors.
+func isRedisDLockOccupied(err error) bool {
+	var rerr redis.Error
+	if !errors.As(err, &rerr) {
+		return false
+	}
+	msg := rerr.Error()
+	return strings.HasSuffix(msg, redisDLockOccupiedReply) || strings.HasSuffix(msg, redisDLockQueuedReply)
+}
+
+// isRedisDLockMismatched returns true if the lock is not held by the token.
+func isRedisDLockMismatched(err error) bool {
+	var rerr redis.Error
+	if !errors.As(err, &rerr) {
+		return false
+	}
+	return strings.Contains(rerr.Error(), redisDLockMismatchedReply)
+}
+
+// subscribe subscribes the released channels of the keys if notify
+// is enabled. It returns nil if the subscription is failed, and the
+// waiter falls back to the backoff polling.
+func (dl *redisDLock) subscribe(ctx context.Context) *redis.PubSub {
+	if !dl.notify {
+		return nil
+	}
+	sub, ok := dl.scripterLoader().(redisSubscriber)
+	if !ok {
+		return nil
+	}
+	channels := make([]string, 0, len(dl.keys))
+	for _, key := range dl.keys {
+		channels = append(channels, key+releasedChSuffix)
+	}
+	pubsub := sub.Subscribe(ctx, channels...)
+	// Wait for the subscription confirmation, otherwise the
+	// notification may be missed before the first acquisition.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil
+	}
+	return pubsub
+}
+
+// dequeue removes the waiter from the fair queue if it gives up,
+// otherwise the followers have to wait until it is stale.
+func (dl *redisDLock) dequeue() {
+	if !dl.fair {
+		return
 	}
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.waiterTTL)
+	defer cancel()
+	_, _ = luaDLockDequeue.Eval(
+		ctx,
+		dl.scripterLoader(),
+		[]string{dl.queueKey, dl.waitersKey},
+		dl.token,
+	).Result()
+}
+
+// onLocked refreshes the lock context which lives as long as the lock.
+func (dl *redisDLock) onLocked() {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	dl.resetCtx(ctx, cancel)
+	dl.locked.Store(true)
+	dl.watchdog.start(dl.parentCtx, dl.watchdogRenewal, nil)
 }
 
 func (dl *redisDLock) Renewal(newTTL time.Duration) error {
@@ -101,34 +247,66 @@ func (dl *redisDLock) Renewal(newTTL time.Duration) error {
 	if !dl.locked.Load() {
 		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "renewal dlock with no lock")
 	}
-	var (
-		ctx    context.Context
-		cancel context.CancelFunc
-	)
-	// TODO pay attention to the context has been cancelled.
-	ctx, cancel = context.WithTimeout(*dl.ctx.Load(), newTTL)
-	if ctx != nil && cancel != nil {
-		if _, err := luaDLockRenewalTTL.Eval(
-			ctx,
-			dl.scripterLoader(),
-			dl.keys,
-			dl.token, newTTL.Milliseconds(),
-		).Result(); err != nil && !errors.Is(err, redis.Nil) {
-			return err
-		}
-		dl.ctx.Store(&ctx)
-		dl.ctxCancel.Store(&cancel)
-		return noErr
+	ctx, cancel := context.WithTimeout(dl.parentCtx, newTTL)
+	if ctx == nil || cancel == nil {
+		return infra.NewErrorStack("refresh dlock ttl with nil context or nil context cancel function")
+	}
+	if err := dl.renewal(ctx, newTTL); err != nil {
+		cancel()
+		dl.stats.RecordRenewal(err)
+		return err
+	}
+	dl.resetCtx(ctx, cancel)
+	dl.stats.RecordRenewal(nil)
+	return noErr
+}
+
+func (dl *redisDLock) renewal(ctx context.Context, newTTL time.Duration) error {
+	if _, err := luaDLockRenewalTTL.Eval(
+		ctx,
+		dl.scripterLoader(),
+		dl.keys,
+		dl.token, newTTL.Milliseconds(), acquiredAtKeySuffix,
+	).Result(); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	return noErr
+}
+
+// watchdogRenewal renews the lock with the build TTL.
+// The token mismatch error replied by the lua script means
+// that the lock has been expired or occupied by others.
+func (dl *redisDLock) watchdogRenewal(ctx context.Context) error {
+	err := dl.renewal(ctx, dl.ttl)
+	dl.stats.RecordRenewal(err)
+	var rerr redis.Error
+	if errors.As(err, &rerr) {
+		return infra.AppendErrorStack(ErrDLockLeaseLost, err)
+	} else if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	dl.resetCtx(ctx, cancel)
+	return noErr
+}
+
+// resetCtx replaces the lock context and releases the previous one.
+func (dl *redisDLock) resetCtx(ctx context.Context, cancel context.CancelFunc) {
+	dl.ctx.Store(&ctx)
+	if prev := dl.ctxCancel.Swap(&cancel); prev != nil && *prev != nil {
+		(*prev)()
 	}
-	return infra.NewErrorStack("refresh dlock ttl with nil context or nil context cancel function")
 }
 
 func (dl *redisDLock) TTL() (time.Duration, error) {
 	if !dl.locked.Load() {
 		return 0, infra.WrapErrorStackWithMessage(ErrDLockNoInit, "fetch dlock ttl failed")
 	}
+	// The lock context is cancelled by each renewal, so it is not used here.
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
 	res, err := luaDLockLoadTTL.Eval(
-		*dl.ctx.Load(), // TODO pay attention to the context has been cancelled.
+		ctx,
 		dl.scripterLoader(),
 		dl.keys,
 		dl.token,
@@ -146,38 +324,67 @@ func (dl *redisDLock) TTL() (time.Duration, error) {
 }
 
 func (dl *redisDLock) Unlock() error {
+	return dl.UnlockContext(dl.parentCtx)
+}
+
+func (dl *redisDLock) UnlockContext(ctx context.Context) error {
 	if !dl.locked.Load() {
 		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to unlock a no init dlock")
 	}
+	if ctx == nil {
+		ctx = dl.parentCtx
+	}
+	// The watchdog is stopped before the release, otherwise its
+	// renewal may report the released lock as lost. It is restarted
+	// if the release fails but the lock may be still held.
+	dl.watchdog.stop()
 	if _, err := luaDLockRelease.Eval(
-		*dl.ctx.Load(), // TODO pay attention to the context has been cancelled.
+		ctx,
 		dl.scripterLoader(),
 		dl.keys,
-		dl.token,
+		dl.token, releasedChSuffix, acquiredAtKeySuffix,
 	).Result(); err != nil && !errors.Is(err, redis.Nil) {
+		if !isRedisDLockMismatched(err) {
+			dl.watchdog.start(dl.parentCtx, dl.watchdogRenewal, nil)
+		}
 		return err
 	}
+	dl.locked.Store(false)
 	if cancel := *dl.ctxCancel.Load(); cancel != nil {
 		cancel()
 	}
+	dl.stats.RecordReleased(ctx)
 	return noErr
 }
 
 type redisDLockOptions struct {
+	parentCtx      context.Context
 	ctx            atomic.Pointer[context.Context]
 	ctxCancel      atomic.Pointer[context.CancelFunc]
 	scripterLoader func() redis.Scripter
 	strategy       RetryStrategy
 	keys           []string
+	fenceKey       string
+	queueKey       string
+	waitersKey     string
+	onLeaseLost    LeaseLostHandler
+	logger         xlog.XLogger
+	namespace      string
 	token          string
 	ttl            time.Duration
+	waiterTTL      time.Duration
+	watchdog       bool
+	fair           bool
+	notify         bool
+	statsName      string
+	enableStats    bool
 }
 
 func RedisDLockBuilder(ctx context.Context, scripter func() redis.Scripter) *redisDLockOptions {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	opts := &redisDLockOptions{scripterLoader: scripter}
+	opts := &redisDLockOptions{parentCtx: ctx, scripterLoader: scripter}
 	opts.ctx.Store(&ctx)
 	return opts
 }
@@ -200,11 +407,74 @@ func (opt *redisDLockOptions) Keys(keys ...string) *redisDLockOptions {
 	return opt
 }
 
+// Namespace prefixes the keys with the namespace as is, e.g. "app:".
+// The locks under the namespace are able to be inspected and force
+// released by the admin with the same namespace.
+func (opt *redisDLockOptions) Namespace(namespace string) *redisDLockOptions {
+	opt.namespace = namespace
+	return opt
+}
+
 func (opt *redisDLockOptions) Retry(strategy RetryStrategy) *redisDLockOptions {
 	opt.strategy = strategy
 	return opt
 }
 
+// Watchdog enables renewing the lock with the build TTL automatically
+// until the lock is released or the context is cancelled.
+// The onLost handler will be called if the lock's lease is lost.
+func (opt *redisDLockOptions) Watchdog(onLost LeaseLostHandler) *redisDLockOptions {
+	opt.watchdog = true
+	opt.onLeaseLost = onLost
+	return opt
+}
+
+// Fair enables granting the lock to the waiters in arrival order.
+// The waiter will be removed from the queue if it doesn't retry in
+// the waiterTTL (the lock TTL as default), so the waiterTTL should
+// be greater than the retry backoff.
+// The lockers without fair mode are still able to acquire the lock
+// before the queued waiters.
+func (opt *redisDLockOptions) Fair(waiterTTL time.Duration) *redisDLockOptions {
+	opt.fair = true
+	opt.waiterTTL = waiterTTL
+	return opt
+}
+
+// Notify enables waking up the waiters by the release notification
+// instead of waiting for the retry backoff. The waiters subscribe the
+// released channels of the keys during the acquisition, and fall back
+// to the backoff polling if the notification is missed (e.g. the lock
+// is expired). So it requires the scripter to support the pub/sub.
+func (opt *redisDLockOptions) Notify() *redisDLockOptions {
+	opt.notify = true
+	return opt
+}
+
+// Stats enables the OpenTelemetry metrics of the lock, includes
+// the acquisition latency, attempts, failures, hold duration,
+// renewals and lost leases.
+func (opt *redisDLockOptions) Stats() *redisDLockOptions {
+	opt.enableStats = true
+	return opt
+}
+
+// StatsName enables the metrics with the name as the "dlock.name"
+// attribute. The name should be in low cardinality, e.g. the job name
+// instead of the lock keys.
+func (opt *redisDLockOptions) StatsName(name string) *redisDLockOptions {
+	opt.enableStats = true
+	opt.statsName = name
+	return opt
+}
+
+// Logger logs the acquisition, release, renewal failure and lost
+// lease events of the lock.
+func (opt *redisDLockOptions) Logger(logger xlog.XLogger) *redisDLockOptions {
+	opt.logger = logger
+	return opt
+}
+
 func (opt *redisDLockOptions) Build() (DLocker, error) {
 	if opt.scripterLoader == nil {
 		return nil, infra.NewErrorStack("redis dlock scripter loader is nil")
@@ -218,17 +488,45 @@ func (opt *redisDLockOptions) Build() (DLocker, error) {
 	if opt.strategy == nil {
 		opt.strategy = NoRetry()
 	}
+	if opt.notify {
+		if _, ok := opt.scripterLoader().(redisSubscriber); !ok {
+			return nil, infra.NewErrorStack("redis dlock notify with scripter not support to subscribe")
+		}
+	}
+	// The options keep the keys as is, so they are able to build again.
+	keys := make([]string, len(opt.keys))
+	for i, key := range opt.keys {
+		keys[i] = opt.namespace + key
+	}
+	// The fencing token is scoped to the first key.
+	opt.fenceKey = keys[0] + fenceKeySuffix
+	if opt.fair {
+		// The waiters are queued by the first key.
+		opt.queueKey = keys[0] + queueKeySuffix
+		opt.waitersKey = keys[0] + waitersKeySuffix
+		if opt.waiterTTL.Milliseconds() <= 0 {
+			opt.waiterTTL = opt.ttl
+		}
+	}
 	var (
 		ctx    context.Context
 		cancel context.CancelFunc
 	)
-	ctx, cancel = context.WithTimeout(*opt.ctx.Load(), opt.ttl)
+	ctx, cancel = context.WithTimeout(opt.parentCtx, opt.ttl)
 	if ctx == nil || cancel == nil {
 		return nil, infra.NewErrorStack("redis dlock build with nil context or nil context cancel function")
 	}
 	opt.ctx.Store(&ctx)
 	opt.ctxCancel.Store(&cancel)
-	return &redisDLock{redisDLockOptions: opt}, nil
+	dl := &redisDLock{
+		redisDLockOptions: opt,
+		keys:              keys,
+		stats:             newDLockStats("redis", opt.statsName, keys, opt.token, opt.enableStats, opt.logger),
+	}
+	if opt.watchdog {
+		dl.watchdog = newDLockWatchdog(opt.ttl, dl.stats.leaseLostHandler(opt.onLeaseLost))
+	}
+	return dl, nil
 }
 
 type RedisDLockOption func(opt *redisDLockOptions)
@@ -251,12 +549,54 @@ func WithRedisDLockToken(token string) RedisDLockOption {
 	}
 }
 
+func WithRedisDLockNamespace(namespace string) RedisDLockOption {
+	return func(opt *redisDLockOptions) {
+		opt.Namespace(namespace)
+	}
+}
+
 func WithRedisDLockRetry(strategy RetryStrategy) RedisDLockOption {
 	return func(opt *redisDLockOptions) {
 		opt.Retry(strategy)
 	}
 }
 
+func WithRedisDLockWatchdog(onLost LeaseLostHandler) RedisDLockOption {
+	return func(opt *redisDLockOptions) {
+		opt.Watchdog(onLost)
+	}
+}
+
+func WithRedisDLockFair(waiterTTL time.Duration) RedisDLockOption {
+	return func(opt *redisDLockOptions) {
+		opt.Fair(waiterTTL)
+	}
+}
+
+func WithRedisDLockNotify() RedisDLockOption {
+	return func(opt *redisDLockOptions) {
+		opt.Notify()
+	}
+}
+
+func WithRedisDLockStats() RedisDLockOption {
+	return func(opt *redisDLockOptions) {
+		opt.Stats()
+	}
+}
+
+func WithRedisDLockStatsName(name string) RedisDLockOption {
+	return func(opt *redisDLockOptions) {
+		opt.StatsName(name)
+	}
+}
+
+func WithRedisDLockLogger(logger xlog.XLogger) RedisDLockOption {
+	return func(opt *redisDLockOptions) {
+		opt.Logger(logger)
+	}
+}
+
 func RedisDLock(ctx context.Context, scripter func() redis.Scripter, opts ...RedisDLockOption) (DLocker, error) {
 	builderOpts := RedisDLockBuilder(ctx, scripter)
 	for _, o := range opts {
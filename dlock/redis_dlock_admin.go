@@ -0,0 +1,244 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+// redisDLockMetaKeySuffixes are the suffixes of the keys those are
+// not the lock keys but the metadata of the locks.
+var redisDLockMetaKeySuffixes = []string{
+	fenceKeySuffix,
+	queueKeySuffix,
+	waitersKeySuffix,
+	acquiredAtKeySuffix,
+}
+
+var _ DLockAdmin = (*redisDLockAdmin)(nil)
+
+// redisDLockAdmin scans the lock keys under the namespace. The keys
+// are scanned from all masters if the client is a cluster client.
+type redisDLockAdmin struct {
+	*redisDLockAdminOptions
+}
+
+func (admin *redisDLockAdmin) List(ctx context.Context) ([]DLockInfo, error) {
+	if ctx == nil {
+		ctx = admin.parentCtx
+	}
+	keys, err := admin.scan(ctx)
+	if err != nil {
+		return nil, infra.WrapErrorStackWithMessage(err, "redis dlock admin list failed")
+	}
+	return admin.inspect(ctx, keys...)
+}
+
+func (admin *redisDLockAdmin) Inspect(ctx context.Context, key string) (DLockInfo, error) {
+	if ctx == nil {
+		ctx = admin.parentCtx
+	}
+	infos, err := admin.inspect(ctx, admin.namespace+key)
+	if err != nil {
+		return DLockInfo{}, err
+	}
+	if len(infos) <= 0 {
+		return DLockInfo{}, infra.WrapErrorStackWithMessage(ErrDLockNotFound, key)
+	}
+	return infos[0], noErr
+}
+
+// ForceRelease deletes the keys and notifies the waiters.
+// The keys are deleted one by one, so the keys of a lock are
+// not required to be in the same redis cluster slot.
+func (admin *redisDLockAdmin) ForceRelease(ctx context.Context, keys ...string) error {
+	if ctx == nil {
+		ctx = admin.parentCtx
+	}
+	if _, err := admin.clientLoader().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			key = admin.namespace + key
+			pipe.Del(ctx, key)
+			pipe.Del(ctx, key+acquiredAtKeySuffix)
+			pipe.Publish(ctx, key+releasedChSuffix, "")
+		}
+		return nil
+	}); err != nil {
+		return infra.WrapErrorStackWithMessage(err, "redis dlock admin force release failed")
+	}
+	return noErr
+}
+
+// scan returns the lock keys under the namespace.
+func (admin *redisDLockAdmin) scan(ctx context.Context) ([]string, error) {
+	var (
+		lock    sync.Mutex
+		keys    []string
+		match   = escapeRedisPattern(admin.namespace) + "*"
+		scanAll = func(ctx context.Context, client redis.Cmdable) error {
+			iter := client.Scan(ctx, 0, match, admin.scanCount).Iterator()
+			for iter.Next(ctx) {
+				if isRedisDLockMetaKey(iter.Val()) {
+					continue
+				}
+				lock.Lock()
+				keys = append(keys, iter.Val())
+				lock.Unlock()
+			}
+			return iter.Err()
+		}
+	)
+	client := admin.clientLoader()
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		if err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scanAll(ctx, client)
+		}); err != nil {
+			return nil, err
+		}
+		return keys, noErr
+	}
+	if err := scanAll(ctx, client); err != nil {
+		return nil, err
+	}
+	return keys, noErr
+}
+
+// inspect loads the holders of the keys (with the namespace). The key
+// is skipped if it is released or not a string key (e.g. the readers
+// of the rw dlock).
+func (admin *redisDLockAdmin) inspect(ctx context.Context, keys ...string) ([]DLockInfo, error) {
+	type inspectCmds struct {
+		typ        *redis.StatusCmd
+		token      *redis.StringCmd
+		ttl        *redis.DurationCmd
+		acquiredAt *redis.StringCmd
+	}
+	cmds := make([]inspectCmds, 0, len(keys))
+	if _, err := admin.clientLoader().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, inspectCmds{
+				typ:        pipe.Type(ctx, key),
+				token:      pipe.Get(ctx, key),
+				ttl:        pipe.PTTL(ctx, key),
+				acquiredAt: pipe.Get(ctx, key+acquiredAtKeySuffix),
+			})
+		}
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) && !isRedisWrongTypeErr(err) {
+		return nil, infra.WrapErrorStackWithMessage(err, "redis dlock admin inspect failed")
+	}
+	infos := make([]DLockInfo, 0, len(keys))
+	for i, key := range keys {
+		if cmds[i].typ.Val() != "string" || cmds[i].token.Err() != nil {
+			continue
+		}
+		info := DLockInfo{
+			Key:   strings.TrimPrefix(key, admin.namespace),
+			Token: cmds[i].token.Val(),
+		}
+		if ttl := cmds[i].ttl.Val(); ttl > 0 {
+			info.TTL = ttl
+		}
+		if ms, err := strconv.ParseInt(cmds[i].acquiredAt.Val(), 10, 64); err == nil {
+			info.AcquiredAt = time.UnixMilli(ms)
+		}
+		infos = append(infos, info)
+	}
+	return infos, noErr
+}
+
+func isRedisDLockMetaKey(key string) bool {
+	for _, suffix := range redisDLockMetaKeySuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRedisWrongTypeErr(err error) bool {
+	var rerr redis.Error
+	return errors.As(err, &rerr) && strings.HasPrefix(rerr.Error(), "WRONGTYPE")
+}
+
+// escapeRedisPattern escapes the glob-style special characters.
+func escapeRedisPattern(pattern string) string {
+	var sb strings.Builder
+	for _, c := range pattern {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+type redisDLockAdminOptions struct {
+	parentCtx    context.Context
+	clientLoader func() redis.UniversalClient
+	namespace    string
+	scanCount    int64
+}
+
+func RedisDLockAdminBuilder(ctx context.Context, client func() redis.UniversalClient) *redisDLockAdminOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &redisDLockAdminOptions{parentCtx: ctx, clientLoader: client}
+}
+
+// Namespace is the same as the lockers' namespace.
+func (opt *redisDLockAdminOptions) Namespace(namespace string) *redisDLockAdminOptions {
+	opt.namespace = namespace
+	return opt
+}
+
+// ScanCount is the count hint of each SCAN, 100 as default.
+func (opt *redisDLockAdminOptions) ScanCount(count int64) *redisDLockAdminOptions {
+	opt.scanCount = count
+	return opt
+}
+
+func (opt *redisDLockAdminOptions) Build() (DLockAdmin, error) {
+	if opt.clientLoader == nil {
+		return nil, infra.NewErrorStack("redis dlock admin client loader is nil")
+	}
+	if len(opt.namespace) <= 0 {
+		// Avoid scanning the whole keyspace.
+		return nil, infra.NewErrorStack("redis dlock admin with empty namespace")
+	}
+	if opt.scanCount <= 0 {
+		opt.scanCount = 100
+	}
+	return &redisDLockAdmin{redisDLockAdminOptions: opt}, nil
+}
+
+type RedisDLockAdminOption func(opt *redisDLockAdminOptions)
+
+func WithRedisDLockAdminNamespace(namespace string) RedisDLockAdminOption {
+	return func(opt *redisDLockAdminOptions) {
+		opt.Namespace(namespace)
+	}
+}
+
+func WithRedisDLockAdminScanCount(count int64) RedisDLockAdminOption {
+	return func(opt *redisDLockAdminOptions) {
+		opt.ScanCount(count)
+	}
+}
+
+func RedisDLockAdmin(ctx context.Context, client func() redis.UniversalClient, opts ...RedisDLockAdminOption) (DLockAdmin, error) {
+	builderOpts := RedisDLockAdminBuilder(ctx, client)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
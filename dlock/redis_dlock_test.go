@@ -12,6 +12,11 @@ import (
 	"github.com/redis/go-redis/v9"
 	redisv9 "github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/benz9527/xboot/xlog"
 )
 
 func TestRedisDLock_MiniRedis(t *testing.T) {
@@ -313,4 +318,572 @@ func TestRedisDLock_SingleRedis_DataRace(t *testing.T) {
 	}()
 	wg.Wait()
 }
- 
\ No newline at end of file
+ 
+func TestRedisDLock_MiniRedis_Watchdog(t *testing.T) {
+	const addr = "127.0.0.1:6482"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	err := mredis.StartAddr(addr)
+	require.NoError(t, err)
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+
+	lostC := make(chan error, 1)
+	lock, err := RedisDLockBuilder(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+	).TTL(300*time.Millisecond).
+		Keys("testKey1_5", "testKey2_5").
+		Token("test1").
+		Watchdog(func(err error) {
+			lostC <- err
+		}).
+		Build()
+	require.NoError(t, err)
+
+	err = lock.Lock()
+	require.NoError(t, err)
+	// The miniredis TTL is only decreased by fast-forward.
+	mredis.FastForward(250 * time.Millisecond)
+	require.LessOrEqual(t, mredis.TTL("testKey1_5"), 50*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return mredis.TTL("testKey1_5") > 250*time.Millisecond
+	}, time.Second, 20*time.Millisecond)
+
+	// The lock has been occupied by others.
+	mredis.Del("testKey1_5")
+	select {
+	case err := <-lostC:
+		require.True(t, errors.Is(err, ErrDLockLeaseLost))
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not report lost lease")
+	}
+	require.Error(t, lock.Unlock())
+
+	lock, err = RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(300*time.Millisecond),
+		WithRedisDLockKeys("testKey1_6", "testKey2_6"),
+		WithRedisDLockToken("test2"),
+		WithRedisDLockWatchdog(func(err error) {
+			lostC <- err
+		}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, lock.Lock())
+	// The failed release keeps the lock renewed by the watchdog.
+	cancelledCtx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	require.Error(t, lock.UnlockContext(cancelledCtx))
+	require.True(t, mredis.Exists("testKey1_6"))
+	mredis.FastForward(250 * time.Millisecond)
+	require.Eventually(t, func() bool {
+		return mredis.TTL("testKey1_6") > 250*time.Millisecond
+	}, time.Second, 20*time.Millisecond)
+	time.Sleep(400 * time.Millisecond)
+	ttl, err := lock.TTL()
+	require.NoError(t, err)
+	require.Greater(t, ttl, time.Duration(0))
+	require.NoError(t, lock.Unlock())
+	select {
+	case err := <-lostC:
+		t.Fatalf("unexpected lost lease after unlock: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRedisDLock_MiniRedis_Fence(t *testing.T) {
+	const addr = "127.0.0.1:6483"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	err := mredis.StartAddr(addr)
+	require.NoError(t, err)
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+
+	prevFence := uint64(0)
+	for i := 0; i < 3; i++ {
+		lock, err := RedisDLock(context.TODO(),
+			func() redis.Scripter {
+				return rclient
+			},
+			WithRedisDLockTTL(200*time.Millisecond),
+			WithRedisDLockKeys("testKey1_7", "testKey2_7"),
+			WithRedisDLockToken("test1"),
+		)
+		require.NoError(t, err)
+		fence, err := lock.LockWithFence()
+		require.NoError(t, err)
+		require.Greater(t, fence, prevFence)
+		prevFence = fence
+
+		// The stale holder is not able to acquire with the same keys.
+		stale, err := RedisDLock(context.TODO(),
+			func() redis.Scripter {
+				return rclient
+			},
+			WithRedisDLockTTL(200*time.Millisecond),
+			WithRedisDLockKeys("testKey1_7"),
+			WithRedisDLockToken("test2"),
+		)
+		require.NoError(t, err)
+		_, err = stale.LockWithFence()
+		require.True(t, errors.Is(err, ErrDLockAcquireFailed))
+		require.NoError(t, lock.Unlock())
+	}
+	fence, err := rclient.Get(context.TODO(), "testKey1_7"+fenceKeySuffix).Uint64()
+	require.NoError(t, err)
+	require.Equal(t, prevFence, fence)
+}
+
+func TestRedisDLock_MiniRedis_Context(t *testing.T) {
+	const addr = "127.0.0.1:6501"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	err := mredis.StartAddr(addr)
+	require.NoError(t, err)
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+
+	lock1, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_8"),
+		WithRedisDLockToken("test1"),
+	)
+	require.NoError(t, err)
+	lock2, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_8"),
+		WithRedisDLockToken("test2"),
+		WithRedisDLockRetry(EndlessRetry(10*time.Millisecond)),
+	)
+	require.NoError(t, err)
+
+	ok, err := lock1.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = lock2.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	err = lock2.LockContext(ctx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	require.NoError(t, lock1.UnlockContext(context.TODO()))
+	require.Error(t, lock1.UnlockContext(context.TODO()))
+	require.NoError(t, lock2.LockContext(context.TODO()))
+	require.NoError(t, lock2.Unlock())
+
+	// Able to lock again after unlock.
+	require.NoError(t, lock2.Lock())
+	ttl, err := lock2.TTL()
+	require.NoError(t, err)
+	require.Greater(t, ttl, time.Duration(0))
+	require.NoError(t, lock2.Unlock())
+
+	// The server errors are not regarded as occupied.
+	require.NoError(t, rclient.HSet(context.TODO(), "testKey2_8", "field", "value").Err())
+	lock3, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey2_8"),
+	)
+	require.NoError(t, err)
+	ok, err = lock3.TryLock()
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+func TestRedisDLock_MiniRedis_Fair(t *testing.T) {
+	const addr = "127.0.0.1:6503"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	err := mredis.StartAddr(addr)
+	require.NoError(t, err)
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+	scripter := func() redis.Scripter {
+		return rclient
+	}
+
+	holder, err := RedisDLock(context.TODO(), scripter,
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_9"),
+		WithRedisDLockToken("holder"),
+		WithRedisDLockFair(0),
+	)
+	require.NoError(t, err)
+	require.NoError(t, holder.Lock())
+
+	const waiters = 4
+	var (
+		orderLock sync.Mutex
+		order     = make([]int, 0, waiters)
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < waiters; i++ {
+		waiter, err := RedisDLock(context.TODO(), scripter,
+			WithRedisDLockTTL(time.Second),
+			WithRedisDLockKeys("testKey1_9"),
+			WithRedisDLockToken("waiter"),
+			WithRedisDLockRetry(EndlessRetry(15*time.Millisecond)),
+			WithRedisDLockFair(500*time.Millisecond),
+		)
+		require.NoError(t, err)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, waiter.LockContext(context.TODO()))
+			orderLock.Lock()
+			order = append(order, i)
+			orderLock.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			require.NoError(t, waiter.Unlock())
+		}(i)
+		// Enqueue in order.
+		require.Eventually(t, func() bool {
+			members, _ := mredis.ZMembers("testKey1_9" + queueKeySuffix)
+			return len(members) == i+1
+		}, time.Second, 5*time.Millisecond)
+	}
+	require.NoError(t, holder.Unlock())
+	wg.Wait()
+	require.Equal(t, []int{0, 1, 2, 3}, order)
+
+	// The waiter gives up, the followers are not blocked.
+	giveUp, err := RedisDLock(context.TODO(), scripter,
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_9"),
+		WithRedisDLockToken("giveUp"),
+		WithRedisDLockFair(0),
+	)
+	require.NoError(t, err)
+	require.NoError(t, holder.Lock())
+	ok, err := giveUp.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.False(t, mredis.Exists("testKey1_9"+queueKeySuffix))
+	require.NoError(t, holder.Unlock())
+
+	// The stale waiter will be removed from the queue.
+	_, err = mredis.ZAdd("testKey1_9"+queueKeySuffix, 0, "stale")
+	require.NoError(t, err)
+	_, err = mredis.ZAdd("testKey1_9"+waitersKeySuffix, float64(time.Now().Add(200*time.Millisecond).UnixMilli()), "stale")
+	require.NoError(t, err)
+	follower, err := RedisDLock(context.TODO(), scripter,
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_9"),
+		WithRedisDLockToken("follower"),
+		WithRedisDLockRetry(EndlessRetry(20*time.Millisecond)),
+		WithRedisDLockFair(0),
+	)
+	require.NoError(t, err)
+	startTime := time.Now()
+	require.NoError(t, follower.LockContext(context.TODO()))
+	require.GreaterOrEqual(t, time.Since(startTime), 150*time.Millisecond)
+	require.NoError(t, follower.Unlock())
+}
+
+func TestRedisDLock_MiniRedis_Notify(t *testing.T) {
+	const addr = "127.0.0.1:6504"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	err := mredis.StartAddr(addr)
+	require.NoError(t, err)
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+
+	// The scripter without pub/sub.
+	_, err = RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return struct{ redis.Scripter }{rclient}
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_10"),
+		WithRedisDLockNotify(),
+	)
+	require.Error(t, err)
+
+	holder, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_10", "testKey2_10"),
+		WithRedisDLockToken("holder"),
+	)
+	require.NoError(t, err)
+	waiter, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey2_10"),
+		WithRedisDLockToken("waiter"),
+		// The backoff is much longer than the waiting.
+		WithRedisDLockRetry(EndlessRetry(5*time.Second)),
+		WithRedisDLockNotify(),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, holder.Lock())
+	lockedC := make(chan error, 1)
+	go func() {
+		lockedC <- waiter.LockContext(context.TODO())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	startTime := time.Now()
+	require.NoError(t, holder.Unlock())
+	select {
+	case err := <-lockedC:
+		require.NoError(t, err)
+		require.Less(t, time.Since(startTime), time.Second)
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter was not notified")
+	}
+	require.NoError(t, waiter.Unlock())
+}
+
+func TestRedisDLock_MiniRedis_Stats(t *testing.T) {
+	const addr = "127.0.0.1:6509"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	err := mredis.StartAddr(addr)
+	require.NoError(t, err)
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+
+	reader := metric.NewManualReader()
+	mp := metric.NewMeterProvider(metric.WithReader(reader))
+	prevMP := otel.GetMeterProvider()
+	otel.SetMeterProvider(mp)
+	defer func() {
+		otel.SetMeterProvider(prevMP)
+		_ = mp.Shutdown(context.TODO())
+	}()
+	logger := xlog.NewXLogger(
+		xlog.WithXLoggerStdOutWriter(),
+		xlog.WithXLoggerLevel(xlog.LogLevelDebug),
+	)
+	defer logger.Close()
+
+	holder, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_11"),
+		WithRedisDLockToken("test1"),
+		WithRedisDLockStatsName("holder"),
+		WithRedisDLockLogger(logger),
+	)
+	require.NoError(t, err)
+	waiter, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_11"),
+		WithRedisDLockToken("test2"),
+		WithRedisDLockRetry(LimitedRetry(5*time.Millisecond, 2)),
+		WithRedisDLockStats(),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, holder.Lock())
+	require.True(t, errors.Is(waiter.Lock(), ErrDLockAcquireFailed))
+	ok, err := waiter.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, holder.Renewal(2*time.Second))
+	require.NoError(t, holder.Unlock())
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.TODO(), &rm))
+	counts := make(map[string]uint64)
+	failures := make(map[string]int64)
+	names := make(map[string]struct{})
+	for _, sm := range rm.ScopeMetrics {
+		require.Equal(t, DLockStatsName+"/redis", sm.Scope.Name)
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[int64]:
+				for _, dp := range data.DataPoints {
+					counts[m.Name] += dp.Count
+					// The raw key is not the attribute.
+					_, ok := dp.Attributes.Value("dlock.key")
+					require.False(t, ok)
+					if name, ok := dp.Attributes.Value("dlock.name"); ok {
+						names[name.AsString()] = struct{}{}
+					}
+				}
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					counts[m.Name] += uint64(dp.Value)
+					if reason, ok := dp.Attributes.Value("dlock.acquire.failed.reason"); ok {
+						failures[reason.AsString()] += dp.Value
+					}
+				}
+			}
+		}
+	}
+	require.Equal(t, uint64(3), counts["dlock.acquire.latency"])
+	require.Equal(t, uint64(3), counts["dlock.acquire.attempts"])
+	require.Equal(t, uint64(2), counts["dlock.acquire.failed.count"])
+	require.Equal(t, int64(1), failures[dlockAcquireFailedExhausted])
+	require.Equal(t, int64(1), failures[dlockAcquireFailedOccupied])
+	require.Equal(t, uint64(1), counts["dlock.hold.duration"])
+	require.Equal(t, uint64(1), counts["dlock.renewal.count"])
+	require.Equal(t, map[string]struct{}{"holder": {}}, names)
+}
+
+func TestRedisDLockAdmin_MiniRedis(t *testing.T) {
+	const addr = "127.0.0.1:6510"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	err := mredis.StartAddr(addr)
+	require.NoError(t, err)
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+
+	_, err = RedisDLockAdmin(context.TODO(), func() redis.UniversalClient {
+		return rclient
+	})
+	require.Error(t, err)
+	admin, err := RedisDLockAdmin(context.TODO(),
+		func() redis.UniversalClient {
+			return rclient
+		},
+		WithRedisDLockAdminNamespace("app:"),
+		WithRedisDLockAdminScanCount(1),
+	)
+	require.NoError(t, err)
+
+	lostC := make(chan error, 1)
+	lock, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(300*time.Millisecond),
+		WithRedisDLockKeys("testKey1_12", "testKey2_12"),
+		WithRedisDLockNamespace("app:"),
+		WithRedisDLockToken("test1"),
+		WithRedisDLockWatchdog(func(err error) {
+			lostC <- err
+		}),
+	)
+	require.NoError(t, err)
+	// Build again with the same options keeps the keys prefixed once.
+	builder := RedisDLockBuilder(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+	).TTL(300 * time.Millisecond).
+		Keys("testKey1_12").
+		Namespace("app:")
+	for i := 0; i < 2; i++ {
+		built, err := builder.Build()
+		require.NoError(t, err)
+		require.Equal(t, []string{"app:testKey1_12"}, built.(*redisDLock).keys)
+	}
+	beforeLocked := time.Now().Add(-time.Second)
+	require.NoError(t, lock.Lock())
+	require.True(t, mredis.Exists("app:testKey1_12"))
+	require.True(t, mredis.Exists("app:testKey1_12"+acquiredAtKeySuffix))
+	// Out of the namespace.
+	others, err := RedisDLock(context.TODO(),
+		func() redis.Scripter {
+			return rclient
+		},
+		WithRedisDLockTTL(time.Second),
+		WithRedisDLockKeys("testKey1_12"),
+	)
+	require.NoError(t, err)
+	require.NoError(t, others.Lock())
+	defer func() { _ = others.Unlock() }()
+	// Not a string lock key.
+	mredis.HSet("app:testKey3_12", "reader", "1")
+
+	infos, err := admin.List(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	for _, info := range infos {
+		require.Contains(t, []string{"testKey1_12", "testKey2_12"}, info.Key)
+		require.Equal(t, lock.(*redisDLock).token, info.Token)
+		require.Greater(t, info.TTL, time.Duration(0))
+		require.True(t, info.AcquiredAt.After(beforeLocked))
+	}
+	info, err := admin.Inspect(context.TODO(), "testKey2_12")
+	require.NoError(t, err)
+	require.Equal(t, "testKey2_12", info.Key)
+	_, err = admin.Inspect(context.TODO(), "testKey4_12")
+	require.True(t, errors.Is(err, ErrDLockNotFound))
+	_, err = admin.Inspect(context.TODO(), "testKey3_12")
+	require.True(t, errors.Is(err, ErrDLockNotFound))
+
+	require.NoError(t, admin.ForceRelease(context.TODO(), "testKey1_12", "testKey2_12"))
+	require.False(t, mredis.Exists("app:testKey1_12"))
+	require.False(t, mredis.Exists("app:testKey1_12"+acquiredAtKeySuffix))
+	infos, err = admin.List(context.TODO())
+	require.NoError(t, err)
+	require.Empty(t, infos)
+	select {
+	case err := <-lostC:
+		require.True(t, errors.Is(err, ErrDLockLeaseLost))
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not report lost lease")
+	}
+
+	// The metadata is released with the lock.
+	require.NoError(t, lock.Lock())
+	require.NoError(t, lock.Unlock())
+	require.False(t, mredis.Exists("app:testKey2_12"+acquiredAtKeySuffix))
+}
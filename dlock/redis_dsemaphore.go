@@ -0,0 +1,189 @@
+package dlock
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+//go:embed semacquire.lua
+var luaDSemaphoreAcquireScript string
+
+//go:embed semrelease.lua
+var luaDSemaphoreReleaseScript string
+
+var (
+	luaDSemaphoreAcquire = redis.NewScript(luaDSemaphoreAcquireScript)
+	luaDSemaphoreRelease = redis.NewScript(luaDSemaphoreReleaseScript)
+)
+
+var _ DSemaphore = (*redisDSemaphore)(nil)
+
+// redisDSemaphore manages the permits by a sorted set, each
+// permit is a member (token#index) scored by its expired at ms.
+type redisDSemaphore struct {
+	*redisDSemaphoreOptions
+	lock     sync.Mutex
+	acquired int64
+}
+
+func (sem *redisDSemaphore) Acquire(ctx context.Context, n int64) error {
+	if n <= 0 || n > sem.permits {
+		return infra.NewErrorStack("redis dsemaphore acquire with invalid permits")
+	}
+	if ctx == nil {
+		ctx = sem.ctx
+	}
+	sem.lock.Lock()
+	defer sem.lock.Unlock()
+	if sem.acquired > 0 {
+		return infra.NewErrorStack("redis dsemaphore permits have been acquired")
+	}
+	if err := retryAcquire(ctx, sem.strategy, func(ctx context.Context) error {
+		if _, err := luaDSemaphoreAcquire.Eval(
+			ctx,
+			sem.scripterLoader(),
+			[]string{sem.key},
+			sem.token, n, sem.permits, sem.ttl.Milliseconds(),
+		).Result(); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		return noErr
+	}); err != nil {
+		return infra.WrapErrorStackWithMessage(err, "redis dsemaphore acquire failed")
+	}
+	sem.acquired = n
+	return noErr
+}
+
+func (sem *redisDSemaphore) Release() error {
+	sem.lock.Lock()
+	defer sem.lock.Unlock()
+	if sem.acquired <= 0 {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to release a no acquired dsemaphore")
+	}
+	// Reset whatever, the permits will be expired at last.
+	n := sem.acquired
+	sem.acquired = 0
+	if _, err := luaDSemaphoreRelease.Eval(
+		sem.ctx,
+		sem.scripterLoader(),
+		[]string{sem.key},
+		sem.token, n,
+	).Result(); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	return noErr
+}
+
+type redisDSemaphoreOptions struct {
+	ctx            context.Context
+	scripterLoader func() redis.Scripter
+	strategy       RetryStrategy
+	key            string
+	token          string
+	permits        int64
+	ttl            time.Duration
+}
+
+func RedisDSemaphoreBuilder(ctx context.Context, scripter func() redis.Scripter) *redisDSemaphoreOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &redisDSemaphoreOptions{ctx: ctx, scripterLoader: scripter}
+}
+
+// TTL sets the max lifetime of the acquired permits.
+func (opt *redisDSemaphoreOptions) TTL(ttl time.Duration) *redisDSemaphoreOptions {
+	opt.ttl = ttl
+	return opt
+}
+
+func (opt *redisDSemaphoreOptions) Token(token string) *redisDSemaphoreOptions {
+	opt.token = token + "&" + nano()
+	return opt
+}
+
+func (opt *redisDSemaphoreOptions) Key(key string) *redisDSemaphoreOptions {
+	opt.key = key
+	return opt
+}
+
+// Permits sets the max number of permits held by all holders.
+func (opt *redisDSemaphoreOptions) Permits(permits int64) *redisDSemaphoreOptions {
+	opt.permits = permits
+	return opt
+}
+
+func (opt *redisDSemaphoreOptions) Retry(strategy RetryStrategy) *redisDSemaphoreOptions {
+	opt.strategy = strategy
+	return opt
+}
+
+func (opt *redisDSemaphoreOptions) Build() (DSemaphore, error) {
+	if opt.scripterLoader == nil {
+		return nil, infra.NewErrorStack("redis dsemaphore scripter loader is nil")
+	}
+	if opt.ttl.Milliseconds() <= 0 {
+		return nil, infra.NewErrorStack("redis dsemaphore with zero ms TTL")
+	}
+	if len(opt.key) <= 0 {
+		return nil, infra.NewErrorStack("redis dsemaphore with empty key")
+	}
+	if opt.permits <= 0 {
+		return nil, infra.NewErrorStack("redis dsemaphore with zero permits")
+	}
+	if len(opt.token) <= 0 {
+		opt.Token("")
+	}
+	if opt.strategy == nil {
+		opt.strategy = NoRetry()
+	}
+	return &redisDSemaphore{redisDSemaphoreOptions: opt}, nil
+}
+
+type RedisDSemaphoreOption func(opt *redisDSemaphoreOptions)
+
+func WithRedisDSemaphoreTTL(ttl time.Duration) RedisDSemaphoreOption {
+	return func(opt *redisDSemaphoreOptions) {
+		opt.TTL(ttl)
+	}
+}
+
+func WithRedisDSemaphoreKey(key string) RedisDSemaphoreOption {
+	return func(opt *redisDSemaphoreOptions) {
+		opt.Key(key)
+	}
+}
+
+func WithRedisDSemaphoreToken(token string) RedisDSemaphoreOption {
+	return func(opt *redisDSemaphoreOptions) {
+		opt.Token(token)
+	}
+}
+
+func WithRedisDSemaphorePermits(permits int64) RedisDSemaphoreOption {
+	return func(opt *redisDSemaphoreOptions) {
+		opt.Permits(permits)
+	}
+}
+
+func WithRedisDSemaphoreRetry(strategy RetryStrategy) RedisDSemaphoreOption {
+	return func(opt *redisDSemaphoreOptions) {
+		opt.Retry(strategy)
+	}
+}
+
+func RedisDSemaphore(ctx context.Context, scripter func() redis.Scripter, opts ...RedisDSemaphoreOption) (DSemaphore, error) {
+	builderOpts := RedisDSemaphoreBuilder(ctx, scripter)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
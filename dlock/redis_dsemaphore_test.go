@@ -0,0 +1,73 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDSemaphore_MiniRedis(t *testing.T) {
+	require.NotEmpty(t, luaDSemaphoreAcquireScript)
+	require.NotEmpty(t, luaDSemaphoreReleaseScript)
+
+	const addr = "127.0.0.1:6500"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	require.NoError(t, mredis.StartAddr(addr))
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+	scripter := func() redis.Scripter {
+		return rclient
+	}
+
+	sem1, err := RedisDSemaphoreBuilder(context.TODO(), scripter).
+		TTL(300 * time.Millisecond).
+		Key("testSemKey1").
+		Permits(3).
+		Token("sem1").
+		Build()
+	require.NoError(t, err)
+	sem2, err := RedisDSemaphore(context.TODO(), scripter,
+		WithRedisDSemaphoreTTL(300*time.Millisecond),
+		WithRedisDSemaphoreKey("testSemKey1"),
+		WithRedisDSemaphorePermits(3),
+		WithRedisDSemaphoreToken("sem2"),
+		WithRedisDSemaphoreRetry(LimitedRetry(10*time.Millisecond, 2)),
+	)
+	require.NoError(t, err)
+
+	require.Error(t, sem1.Release())
+	require.Error(t, sem1.Acquire(context.TODO(), 0))
+	require.Error(t, sem1.Acquire(context.TODO(), 4))
+
+	require.NoError(t, sem1.Acquire(context.TODO(), 2))
+	require.Error(t, sem1.Acquire(context.TODO(), 1))
+	require.True(t, errors.Is(sem2.Acquire(context.TODO(), 2), ErrDLockAcquireFailed))
+	require.NoError(t, sem1.Release())
+	require.NoError(t, sem2.Acquire(context.TODO(), 2))
+	require.NoError(t, sem2.Release())
+
+	// The expired permits are purged.
+	require.NoError(t, sem1.Acquire(context.TODO(), 3))
+	time.Sleep(400 * time.Millisecond)
+	sem3, err := RedisDSemaphore(context.TODO(), scripter,
+		WithRedisDSemaphoreTTL(300*time.Millisecond),
+		WithRedisDSemaphoreKey("testSemKey1"),
+		WithRedisDSemaphorePermits(3),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sem3.Acquire(context.TODO(), 3))
+	require.Error(t, sem1.Release())
+	require.NoError(t, sem3.Release())
+}
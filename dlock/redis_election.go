@@ -0,0 +1,198 @@
+package dlock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+var _ LeaderElector = (*redisLeaderElector)(nil)
+
+// redisLeaderElector regards the holder of the dlock as the leader.
+// The dlock's lease is kept alive by the watchdog, and the leadership
+// will be revoked once the watchdog reports the lease lost.
+type redisLeaderElector struct {
+	*redisLeaderElectorOptions
+	dlock  DLocker
+	lock   sync.Mutex
+	leader atomic.Bool
+}
+
+// Campaign and Resign call the handlers outside the lock, so it
+// is safe to campaign or resign in the handlers.
+func (e *redisLeaderElector) Campaign(ctx context.Context) error {
+	e.lock.Lock()
+	if e.leader.Load() {
+		e.lock.Unlock()
+		return noErr
+	}
+	if err := e.dlock.LockContext(ctx); err != nil {
+		e.lock.Unlock()
+		return infra.WrapErrorStackWithMessage(err, "redis leader campaign failed")
+	}
+	e.leader.Store(true)
+	e.lock.Unlock()
+
+	if e.onElected != nil {
+		e.onElected()
+	}
+	return noErr
+}
+
+func (e *redisLeaderElector) Resign(ctx context.Context) error {
+	e.lock.Lock()
+	if !e.leader.Load() {
+		e.lock.Unlock()
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "redis leader resign without leadership")
+	}
+	err := e.dlock.UnlockContext(ctx)
+	e.leader.Store(false)
+	e.lock.Unlock()
+
+	if e.onRevoked != nil {
+		e.onRevoked(nil)
+	}
+	return err
+}
+
+func (e *redisLeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *redisLeaderElector) revoke(err error) {
+	if e.leader.CompareAndSwap(true, false) && e.onRevoked != nil {
+		e.onRevoked(err)
+	}
+}
+
+type redisLeaderElectorOptions struct {
+	ctx            context.Context
+	scripterLoader func() redis.Scripter
+	strategy       RetryStrategy
+	onElected      LeaderElectedHandler
+	onRevoked      LeaderRevokedHandler
+	key            string
+	candidate      string
+	ttl            time.Duration
+}
+
+func RedisLeaderElectorBuilder(ctx context.Context, scripter func() redis.Scripter) *redisLeaderElectorOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &redisLeaderElectorOptions{ctx: ctx, scripterLoader: scripter}
+}
+
+// TTL sets the leadership lease TTL. The lease is renewed at 1/3 TTL
+// interval by the watchdog.
+func (opt *redisLeaderElectorOptions) TTL(ttl time.Duration) *redisLeaderElectorOptions {
+	opt.ttl = ttl
+	return opt
+}
+
+func (opt *redisLeaderElectorOptions) Key(key string) *redisLeaderElectorOptions {
+	opt.key = key
+	return opt
+}
+
+// Candidate sets the identity of this candidate, such as the hostname.
+func (opt *redisLeaderElectorOptions) Candidate(candidate string) *redisLeaderElectorOptions {
+	opt.candidate = candidate
+	return opt
+}
+
+// Retry sets the campaign retry strategy. The candidate campaigns
+// endlessly at 1/3 TTL interval as default.
+func (opt *redisLeaderElectorOptions) Retry(strategy RetryStrategy) *redisLeaderElectorOptions {
+	opt.strategy = strategy
+	return opt
+}
+
+func (opt *redisLeaderElectorOptions) OnElected(handler LeaderElectedHandler) *redisLeaderElectorOptions {
+	opt.onElected = handler
+	return opt
+}
+
+func (opt *redisLeaderElectorOptions) OnRevoked(handler LeaderRevokedHandler) *redisLeaderElectorOptions {
+	opt.onRevoked = handler
+	return opt
+}
+
+func (opt *redisLeaderElectorOptions) Build() (LeaderElector, error) {
+	if opt.scripterLoader == nil {
+		return nil, infra.NewErrorStack("redis leader elector scripter loader is nil")
+	}
+	if opt.ttl.Milliseconds() <= 0 {
+		return nil, infra.NewErrorStack("redis leader elector with zero ms TTL")
+	}
+	if len(opt.key) <= 0 {
+		return nil, infra.NewErrorStack("redis leader elector with empty key")
+	}
+	if opt.strategy == nil {
+		opt.strategy = EndlessRetry(opt.ttl / watchdogRenewalDivisor)
+	}
+	e := &redisLeaderElector{redisLeaderElectorOptions: opt}
+	dlock, err := RedisDLockBuilder(opt.ctx, opt.scripterLoader).
+		TTL(opt.ttl).
+		Keys(opt.key).
+		Token(opt.candidate).
+		Retry(opt.strategy).
+		Watchdog(e.revoke).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	e.dlock = dlock
+	return e, nil
+}
+
+type RedisLeaderElectorOption func(opt *redisLeaderElectorOptions)
+
+func WithRedisLeaderElectorTTL(ttl time.Duration) RedisLeaderElectorOption {
+	return func(opt *redisLeaderElectorOptions) {
+		opt.TTL(ttl)
+	}
+}
+
+func WithRedisLeaderElectorKey(key string) RedisLeaderElectorOption {
+	return func(opt *redisLeaderElectorOptions) {
+		opt.Key(key)
+	}
+}
+
+func WithRedisLeaderElectorCandidate(candidate string) RedisLeaderElectorOption {
+	return func(opt *redisLeaderElectorOptions) {
+		opt.Candidate(candidate)
+	}
+}
+
+func WithRedisLeaderElectorRetry(strategy RetryStrategy) RedisLeaderElectorOption {
+	return func(opt *redisLeaderElectorOptions) {
+		opt.Retry(strategy)
+	}
+}
+
+func WithRedisLeaderElectorOnElected(handler LeaderElectedHandler) RedisLeaderElectorOption {
+	return func(opt *redisLeaderElectorOptions) {
+		opt.OnElected(handler)
+	}
+}
+
+func WithRedisLeaderElectorOnRevoked(handler LeaderRevokedHandler) RedisLeaderElectorOption {
+	return func(opt *redisLeaderElectorOptions) {
+		opt.OnRevoked(handler)
+	}
+}
+
+func RedisLeaderElector(ctx context.Context, scripter func() redis.Scripter, opts ...RedisLeaderElectorOption) (LeaderElector, error) {
+	builderOpts := RedisLeaderElectorBuilder(ctx, scripter)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
@@ -0,0 +1,89 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisLeaderElector_MiniRedis(t *testing.T) {
+	const addr = "127.0.0.1:6502"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	require.NoError(t, mredis.StartAddr(addr))
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+	scripter := func() redis.Scripter {
+		return rclient
+	}
+
+	electedC := make(chan string, 4)
+	revokedC := make(chan error, 4)
+	e1, err := RedisLeaderElectorBuilder(context.TODO(), scripter).
+		TTL(300 * time.Millisecond).
+		Key("testLeaderKey1").
+		Candidate("node1").
+		OnElected(func() {
+			electedC <- "node1"
+		}).
+		OnRevoked(func(err error) {
+			revokedC <- err
+		}).
+		Build()
+	require.NoError(t, err)
+	e2, err := RedisLeaderElector(context.TODO(), scripter,
+		WithRedisLeaderElectorTTL(300*time.Millisecond),
+		WithRedisLeaderElectorKey("testLeaderKey1"),
+		WithRedisLeaderElectorCandidate("node2"),
+		WithRedisLeaderElectorOnElected(func() {
+			electedC <- "node2"
+		}),
+		WithRedisLeaderElectorOnRevoked(func(err error) {
+			revokedC <- err
+		}),
+	)
+	require.NoError(t, err)
+
+	require.Error(t, e1.Resign(context.TODO()))
+	require.NoError(t, e1.Campaign(context.TODO()))
+	require.True(t, e1.IsLeader())
+	require.Equal(t, "node1", <-electedC)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 200*time.Millisecond)
+	defer cancel()
+	require.True(t, errors.Is(e2.Campaign(ctx), context.DeadlineExceeded))
+	require.False(t, e2.IsLeader())
+
+	// The follower takes over once the leader resigns.
+	campaignC := make(chan error, 1)
+	go func() {
+		campaignC <- e2.Campaign(context.TODO())
+	}()
+	require.NoError(t, e1.Resign(context.TODO()))
+	require.False(t, e1.IsLeader())
+	require.NoError(t, <-revokedC)
+	require.NoError(t, <-campaignC)
+	require.True(t, e2.IsLeader())
+	require.Equal(t, "node2", <-electedC)
+
+	// The leadership is revoked if the lease is lost.
+	mredis.Del("testLeaderKey1")
+	select {
+	case err := <-revokedC:
+		require.True(t, errors.Is(err, ErrDLockLeaseLost))
+	case <-time.After(time.Second):
+		t.Fatal("leadership was not revoked")
+	}
+	require.False(t, e2.IsLeader())
+}
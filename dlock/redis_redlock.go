@@ -0,0 +1,477 @@
+package dlock
+
+// References:
+// https://redis.io/docs/latest/develop/use/patterns/distributed-locks/#the-redlock-algorithm
+// https://github.com/go-redsync/redsync
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/multierr"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+const (
+	defaultRedLockDriftFactor = 0.01
+	// The redis expires keys with 1ms precision.
+	redLockClockPrecision = 2 * time.Millisecond
+	// The node request timeout should be small compared to the TTL,
+	// so the client will not keep waiting for a down node.
+	redLockNodeTimeoutDivisor = 10
+	minRedLockNodeTimeout     = 50 * time.Millisecond
+)
+
+var _ DLocker = (*redisRedLock)(nil)
+
+// redisRedLock acquires the lock on a majority of the independent
+// redis nodes (no replication between them), so a single node failover
+// is not able to hand the same lock to two holders.
+type redisRedLock struct {
+	*redisRedLockOptions
+	watchdog *dlockWatchdog
+	locked   atomic.Bool
+}
+
+// nodeResult is the result of a script running on a single node.
+type nodeResult struct {
+	res any
+	err error
+}
+
+// eval runs the script on all nodes concurrently.
+func (dl *redisRedLock) eval(
+	ctx context.Context,
+	script *redis.Script,
+	keys []string,
+	args ...any,
+) []nodeResult {
+	return dl.evalOn(ctx, nil, script, keys, args...)
+}
+
+// evalOn runs the script on the nodes concurrently, all nodes if the
+// nodes is nil. The results of the skipped nodes are zero.
+func (dl *redisRedLock) evalOn(
+	ctx context.Context,
+	nodes []bool,
+	script *redis.Script,
+	keys []string,
+	args ...any,
+) []nodeResult {
+	results := make([]nodeResult, len(dl.scripterLoaders))
+	wg := sync.WaitGroup{}
+	for i, loader := range dl.scripterLoaders {
+		if nodes != nil && !nodes[i] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, loader func() redis.Scripter) {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, dl.nodeTimeout)
+			defer cancel()
+			res, err := script.Eval(nodeCtx, loader(), keys, args...).Result()
+			if errors.Is(err, redis.Nil) {
+				err = nil
+			}
+			results[i] = nodeResult{res: res, err: err}
+		}(i, loader)
+	}
+	wg.Wait()
+	return results
+}
+
+func (dl *redisRedLock) quorum() int {
+	return len(dl.scripterLoaders)/2 + 1
+}
+
+// tryAcquire acquires the lock on all nodes and checks the quorum and
+// the validity time. The lock acquired on the minority nodes by this
+// attempt will be released if it is failed to acquire the lock, but the
+// lock which has been held (reentrant) is kept.
+// It returns the validity time of the lock and the number of nodes
+// replied (acquired or occupied) as well.
+func (dl *redisRedLock) tryAcquire(ctx context.Context) (uint64, time.Duration, int, error) {
+	startTime := time.Now()
+	held := dl.locked.Load()
+	results := dl.eval(ctx,
+		luaDLockAcquire,
+		append(dl.keys, dl.fenceKey),
+		dl.token, len(dl.token), dl.ttl.Milliseconds(), len(dl.keys),
+	)
+	var (
+		merr     error
+		acquired = make([]bool, len(results))
+		count    int
+		replied  int
+		fence    uint64
+	)
+	for i, r := range results {
+		if r.err != nil {
+			if isRedisDLockOccupied(r.err) {
+				replied++
+			}
+			merr = multierr.Append(merr, r.err)
+			continue
+		}
+		replied++
+		count++
+		acquired[i] = true
+		// Each node maintains its own counter, the max one is
+		// the best effort to provide a monotonic fencing token.
+		if f, ok := r.res.(int64); ok && uint64(f) > fence {
+			fence = uint64(f)
+		}
+	}
+	drift := time.Duration(float64(dl.ttl)*dl.driftFactor) + redLockClockPrecision
+	validity := dl.ttl - time.Since(startTime) - drift
+	if count >= dl.quorum() && validity > 0 {
+		return fence, validity, replied, noErr
+	}
+	if !held {
+		dl.evalOn(ctx, acquired, luaDLockRelease, dl.keys, dl.token)
+	}
+	return 0, 0, replied, multierr.Append(merr, ErrDLockAcquireFailed)
+}
+
+func (dl *redisRedLock) Lock() error {
+	_, err := dl.LockWithFence()
+	return err
+}
+
+func (dl *redisRedLock) LockContext(ctx context.Context) error {
+	_, err := dl.lockWithFence(ctx)
+	return err
+}
+
+func (dl *redisRedLock) LockWithFence() (uint64, error) {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
+	return dl.lockWithFence(ctx)
+}
+
+func (dl *redisRedLock) lockWithFence(ctx context.Context) (uint64, error) {
+	if ctx == nil {
+		ctx = dl.parentCtx
+	}
+	var (
+		fence    uint64
+		validity time.Duration
+	)
+	if err := retryAcquire(ctx, dl.strategy, func(ctx context.Context) (err error) {
+		fence, validity, _, err = dl.tryAcquire(ctx)
+		return err
+	}); err != nil {
+		return 0, infra.WrapErrorStackWithMessage(err, "redis redlock lock failed")
+	}
+	dl.onLocked(validity)
+	return fence, noErr
+}
+
+// TryLock regards the lock as occupied if the quorum nodes replied
+// but the lock is not acquired. Otherwise, the nodes are unavailable.
+func (dl *redisRedLock) TryLock() (bool, error) {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+	defer cancel()
+	_, validity, replied, err := dl.tryAcquire(ctx)
+	if err == nil {
+		dl.onLocked(validity)
+		return true, noErr
+	}
+	if replied >= dl.quorum() {
+		return false, noErr
+	}
+	return false, infra.WrapErrorStackWithMessage(err, "redis redlock quorum nodes unavailable")
+}
+
+// onLocked refreshes the lock context which lives as long as the
+// validity time of the lock.
+func (dl *redisRedLock) onLocked(validity time.Duration) {
+	ctx, cancel := context.WithTimeout(dl.parentCtx, validity)
+	dl.resetCtx(ctx, cancel)
+	dl.locked.Store(true)
+	dl.watchdog.start(dl.parentCtx, dl.watchdogRenewal, nil)
+}
+
+// renewal refreshes the TTL on all nodes. It returns the number
+// of nodes which have been refreshed and the errors.
+func (dl *redisRedLock) renewal(ctx context.Context, newTTL time.Duration) (int, int, error) {
+	results := dl.eval(ctx, luaDLockRenewalTTL, dl.keys, dl.token, newTTL.Milliseconds())
+	var (
+		merr       error
+		renewed    int
+		mismatched int
+	)
+	for _, r := range results {
+		var rerr redis.Error
+		if r.err == nil {
+			renewed++
+		} else if errors.As(r.err, &rerr) {
+			mismatched++
+			merr = multierr.Append(merr, r.err)
+		} else {
+			merr = multierr.Append(merr, r.err)
+		}
+	}
+	return renewed, mismatched, merr
+}
+
+func (dl *redisRedLock) Renewal(newTTL time.Duration) error {
+	if newTTL.Milliseconds() <= 0 {
+		return infra.NewErrorStack("renewal redlock with zero ms TTL")
+	}
+	if !dl.locked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "renewal redlock with no lock")
+	}
+	ctx, cancel := context.WithTimeout(dl.parentCtx, newTTL)
+	if renewed, _, err := dl.renewal(ctx, newTTL); renewed < dl.quorum() {
+		cancel()
+		return infra.WrapErrorStackWithMessage(err, "redlock renewal quorum not reached")
+	}
+	dl.resetCtx(ctx, cancel)
+	return noErr
+}
+
+// watchdogRenewal regards the lease as lost if there are no longer
+// enough nodes hold the token to reach the quorum.
+func (dl *redisRedLock) watchdogRenewal(ctx context.Context) error {
+	renewed, mismatched, err := dl.renewal(ctx, dl.ttl)
+	if renewed >= dl.quorum() {
+		ctx, cancel := context.WithTimeout(dl.parentCtx, dl.ttl)
+		dl.resetCtx(ctx, cancel)
+		return noErr
+	}
+	if len(dl.scripterLoaders)-mismatched < dl.quorum() {
+		return infra.AppendErrorStack(ErrDLockLeaseLost, err)
+	}
+	return infra.WrapErrorStackWithMessage(err, "redlock renewal quorum not reached")
+}
+
+func (dl *redisRedLock) resetCtx(ctx context.Context, cancel context.CancelFunc) {
+	dl.ctx.Store(&ctx)
+	if prev := dl.ctxCancel.Swap(&cancel); prev != nil && *prev != nil {
+		(*prev)()
+	}
+}
+
+// TTL returns the TTL that is guaranteed by the quorum nodes.
+func (dl *redisRedLock) TTL() (time.Duration, error) {
+	if !dl.locked.Load() {
+		return 0, infra.WrapErrorStackWithMessage(ErrDLockNoInit, "fetch redlock ttl failed")
+	}
+	results := dl.eval(*dl.ctx.Load(), luaDLockLoadTTL, dl.keys, dl.token)
+	var (
+		merr error
+		ttls = make([]int64, 0, len(results))
+	)
+	for _, r := range results {
+		if r.err != nil {
+			merr = multierr.Append(merr, r.err)
+			continue
+		}
+		if num, ok := r.res.(int64); ok && num > 0 {
+			ttls = append(ttls, num)
+		}
+	}
+	if len(ttls) < dl.quorum() {
+		return 0, infra.WrapErrorStackWithMessage(merr, "redlock ttl quorum not reached")
+	}
+	// Descending order.
+	slices.SortFunc(ttls, func(i, j int64) int {
+		return cmp.Compare(j, i)
+	})
+	return time.Duration(ttls[dl.quorum()-1]) * time.Millisecond, noErr
+}
+
+// Unlock releases the lock on all nodes, even if the nodes
+// were failed to acquire the lock.
+func (dl *redisRedLock) Unlock() error {
+	return dl.UnlockContext(dl.parentCtx)
+}
+
+func (dl *redisRedLock) UnlockContext(ctx context.Context) error {
+	if !dl.locked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to unlock a no init redlock")
+	}
+	if ctx == nil {
+		ctx = dl.parentCtx
+	}
+	dl.watchdog.stop()
+	results := dl.eval(ctx, luaDLockRelease, dl.keys, dl.token)
+	var (
+		merr     error
+		released int
+	)
+	for _, r := range results {
+		if r.err != nil {
+			merr = multierr.Append(merr, r.err)
+			continue
+		}
+		released++
+	}
+	if cancel := *dl.ctxCancel.Load(); cancel != nil {
+		cancel()
+	}
+	if released < dl.quorum() {
+		return infra.WrapErrorStackWithMessage(merr, "redlock release quorum not reached")
+	}
+	dl.locked.Store(false)
+	return noErr
+}
+
+type redisRedLockOptions struct {
+	parentCtx       context.Context
+	ctx             atomic.Pointer[context.Context]
+	ctxCancel       atomic.Pointer[context.CancelFunc]
+	scripterLoaders []func() redis.Scripter
+	strategy        RetryStrategy
+	keys            []string
+	fenceKey        string
+	onLeaseLost     LeaseLostHandler
+	token           string
+	driftFactor     float64
+	ttl             time.Duration
+	nodeTimeout     time.Duration
+	watchdog        bool
+}
+
+func RedisRedLockBuilder(ctx context.Context, scripters ...func() redis.Scripter) *redisRedLockOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	opts := &redisRedLockOptions{
+		parentCtx:       ctx,
+		scripterLoaders: scripters,
+		driftFactor:     defaultRedLockDriftFactor,
+	}
+	opts.ctx.Store(&ctx)
+	return opts
+}
+
+func (opt *redisRedLockOptions) TTL(ttl time.Duration) *redisRedLockOptions {
+	opt.ttl = ttl
+	return opt
+}
+
+func (opt *redisRedLockOptions) Token(token string) *redisRedLockOptions {
+	opt.token = token + "&" + nano()
+	return opt
+}
+
+func (opt *redisRedLockOptions) Keys(keys ...string) *redisRedLockOptions {
+	opt.keys = make([]string, len(keys))
+	for i, key := range keys {
+		opt.keys[i] = key
+	}
+	return opt
+}
+
+func (opt *redisRedLockOptions) Retry(strategy RetryStrategy) *redisRedLockOptions {
+	opt.strategy = strategy
+	return opt
+}
+
+// DriftFactor sets the clock drift factor of the TTL.
+// The lock validity time is TTL - elapsed - TTL * factor - 2ms.
+func (opt *redisRedLockOptions) DriftFactor(factor float64) *redisRedLockOptions {
+	opt.driftFactor = factor
+	return opt
+}
+
+// Watchdog enables renewing the lock on all nodes with the build TTL
+// automatically until the lock is released or the context is cancelled.
+// The onLost handler will be called if the quorum nodes lost the lock.
+func (opt *redisRedLockOptions) Watchdog(onLost LeaseLostHandler) *redisRedLockOptions {
+	opt.watchdog = true
+	opt.onLeaseLost = onLost
+	return opt
+}
+
+func (opt *redisRedLockOptions) Build() (DLocker, error) {
+	if len(opt.scripterLoaders) <= 0 {
+		return nil, infra.NewErrorStack("redis redlock without scripter loaders")
+	}
+	for _, loader := range opt.scripterLoaders {
+		if loader == nil {
+			return nil, infra.NewErrorStack("redis redlock scripter loader is nil")
+		}
+	}
+	if opt.ttl.Milliseconds() <= 0 {
+		return nil, infra.NewErrorStack("redis redlock with zero ms TTL")
+	}
+	if len(opt.keys) <= 0 {
+		return nil, infra.NewErrorStack("redis redlock with zero keys")
+	}
+	if opt.driftFactor < 0 || opt.driftFactor >= 1 {
+		return nil, infra.NewErrorStack("redis redlock drift factor must be in [0, 1)")
+	}
+	if opt.strategy == nil {
+		opt.strategy = NoRetry()
+	}
+	opt.fenceKey = opt.keys[0] + fenceKeySuffix
+	opt.nodeTimeout = max(opt.ttl/redLockNodeTimeoutDivisor, minRedLockNodeTimeout)
+
+	ctx, cancel := context.WithTimeout(opt.parentCtx, opt.ttl)
+	if ctx == nil || cancel == nil {
+		return nil, infra.NewErrorStack("redis redlock build with nil context or nil context cancel function")
+	}
+	opt.ctx.Store(&ctx)
+	opt.ctxCancel.Store(&cancel)
+	dl := &redisRedLock{redisRedLockOptions: opt}
+	if opt.watchdog {
+		dl.watchdog = newDLockWatchdog(opt.ttl, opt.onLeaseLost)
+	}
+	return dl, nil
+}
+
+type RedisRedLockOption func(opt *redisRedLockOptions)
+
+func WithRedisRedLockTTL(ttl time.Duration) RedisRedLockOption {
+	return func(opt *redisRedLockOptions) {
+		opt.TTL(ttl)
+	}
+}
+
+func WithRedisRedLockKeys(keys ...string) RedisRedLockOption {
+	return func(opt *redisRedLockOptions) {
+		opt.Keys(keys...)
+	}
+}
+
+func WithRedisRedLockToken(token string) RedisRedLockOption {
+	return func(opt *redisRedLockOptions) {
+		opt.Token(token)
+	}
+}
+
+func WithRedisRedLockRetry(strategy RetryStrategy) RedisRedLockOption {
+	return func(opt *redisRedLockOptions) {
+		opt.Retry(strategy)
+	}
+}
+
+func WithRedisRedLockDriftFactor(factor float64) RedisRedLockOption {
+	return func(opt *redisRedLockOptions) {
+		opt.DriftFactor(factor)
+	}
+}
+
+func WithRedisRedLockWatchdog(onLost LeaseLostHandler) RedisRedLockOption {
+	return func(opt *redisRedLockOptions) {
+		opt.Watchdog(onLost)
+	}
+}
+
+func RedisRedLock(ctx context.Context, scripters []func() redis.Scripter, opts ...RedisRedLockOption) (DLocker, error) {
+	builderOpts := RedisRedLockBuilder(ctx, scripters...)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
@@ -0,0 +1,207 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func startMiniRedisNodes(t *testing.T, basePort int, n int) ([]*mredisv2.Miniredis, []func() redis.Scripter) {
+	nodes := make([]*mredisv2.Miniredis, 0, n)
+	loaders := make([]func() redis.Scripter, 0, n)
+	for i := 0; i < n; i++ {
+		mredis := mredisv2.NewMiniRedis()
+		require.NoError(t, mredis.StartAddr(fmt.Sprintf("127.0.0.1:%d", basePort+i)))
+		t.Cleanup(mredis.Close)
+		rclient := redisv9.NewClient(&redisv9.Options{
+			Addr:        mredis.Addr(),
+			DialTimeout: 50 * time.Millisecond,
+			MaxRetries:  -1,
+		})
+		t.Cleanup(func() { _ = rclient.Close() })
+		nodes = append(nodes, mredis)
+		loaders = append(loaders, func() redis.Scripter {
+			return rclient
+		})
+	}
+	return nodes, loaders
+}
+
+func TestRedisRedLock_MiniRedis(t *testing.T) {
+	nodes, loaders := startMiniRedisNodes(t, 6490, 3)
+
+	lock, err := RedisRedLockBuilder(context.TODO(), loaders...).
+		TTL(500*time.Millisecond).
+		Keys("testKey1_8", "testKey2_8").
+		Token("test1").
+		Build()
+	require.NoError(t, err)
+	require.Error(t, lock.Unlock())
+	_, err = lock.TTL()
+	require.Error(t, err)
+
+	fence, err := lock.LockWithFence()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), fence)
+	for _, node := range nodes {
+		require.True(t, node.Exists("testKey1_8"))
+		require.True(t, node.Exists("testKey2_8"))
+	}
+	ttl, err := lock.TTL()
+	require.NoError(t, err)
+	require.LessOrEqual(t, ttl, 500*time.Millisecond)
+	require.NoError(t, lock.Renewal(time.Second))
+	ttl, err = lock.TTL()
+	require.NoError(t, err)
+	require.Greater(t, ttl, 500*time.Millisecond)
+
+	// Mutual exclusion.
+	lock2, err := RedisRedLock(context.TODO(), loaders,
+		WithRedisRedLockTTL(500*time.Millisecond),
+		WithRedisRedLockKeys("testKey1_8"),
+		WithRedisRedLockToken("test2"),
+	)
+	require.NoError(t, err)
+	require.True(t, errors.Is(lock2.Lock(), ErrDLockAcquireFailed))
+
+	require.NoError(t, lock.Unlock())
+	for _, node := range nodes {
+		require.False(t, node.Exists("testKey1_8"))
+		require.False(t, node.Exists("testKey2_8"))
+	}
+
+	_, err = RedisRedLockBuilder(context.TODO()).
+		TTL(500 * time.Millisecond).
+		Keys("testKey1_8").
+		Build()
+	require.Error(t, err)
+	_, err = RedisRedLockBuilder(context.TODO(), loaders...).
+		TTL(500 * time.Millisecond).
+		Keys("testKey1_8").
+		DriftFactor(1.5).
+		Build()
+	require.Error(t, err)
+}
+
+func TestRedisRedLock_MiniRedis_Quorum(t *testing.T) {
+	nodes, loaders := startMiniRedisNodes(t, 6493, 3)
+
+	// The minority node is down.
+	nodes[2].Close()
+	lock, err := RedisRedLock(context.TODO(), loaders,
+		WithRedisRedLockTTL(500*time.Millisecond),
+		WithRedisRedLockKeys("testKey1_9"),
+		WithRedisRedLockToken("test1"),
+	)
+	require.NoError(t, err)
+	require.NoError(t, lock.Lock())
+	_, err = lock.TTL()
+	require.NoError(t, err)
+	require.NoError(t, lock.Unlock())
+	require.NoError(t, nodes[2].Restart())
+
+	// The majority nodes have been occupied by others.
+	require.NoError(t, nodes[1].Set("testKey1_9", "others"))
+	require.NoError(t, nodes[2].Set("testKey1_9", "others"))
+	lock, err = RedisRedLock(context.TODO(), loaders,
+		WithRedisRedLockTTL(500*time.Millisecond),
+		WithRedisRedLockKeys("testKey1_9"),
+		WithRedisRedLockToken("test1"),
+		WithRedisRedLockRetry(LimitedRetry(10*time.Millisecond, 2)),
+	)
+	require.NoError(t, err)
+	require.True(t, errors.Is(lock.Lock(), ErrDLockAcquireFailed))
+	// The minority node lock has been released.
+	require.False(t, nodes[0].Exists("testKey1_9"))
+	ok, err := lock.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The majority nodes are down.
+	nodes[1].Close()
+	nodes[2].Close()
+	ok, err = lock.TryLock()
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+func TestRedisRedLock_MiniRedis_Watchdog(t *testing.T) {
+	nodes, loaders := startMiniRedisNodes(t, 6496, 3)
+
+	lostC := make(chan error, 1)
+	lock, err := RedisRedLock(context.TODO(), loaders,
+		WithRedisRedLockTTL(300*time.Millisecond),
+		WithRedisRedLockKeys("testKey1_10"),
+		WithRedisRedLockToken("test1"),
+		WithRedisRedLockWatchdog(func(err error) {
+			lostC <- err
+		}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, lock.Lock())
+	for _, node := range nodes {
+		node.FastForward(250 * time.Millisecond)
+	}
+	require.Eventually(t, func() bool {
+		return nodes[0].TTL("testKey1_10") > 250*time.Millisecond
+	}, time.Second, 20*time.Millisecond)
+
+	// Only the minority node lost the lock.
+	nodes[0].Del("testKey1_10")
+	time.Sleep(200 * time.Millisecond)
+	require.Empty(t, lostC)
+
+	nodes[1].Del("testKey1_10")
+	select {
+	case err := <-lostC:
+		require.True(t, errors.Is(err, ErrDLockLeaseLost))
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not report lost lease")
+	}
+}
+
+func TestRedisRedLock_MiniRedis_ReentrantQuorumMissed(t *testing.T) {
+	nodes, loaders := startMiniRedisNodes(t, 6511, 3)
+
+	lock, err := RedisRedLock(context.TODO(), loaders,
+		WithRedisRedLockTTL(500*time.Millisecond),
+		WithRedisRedLockKeys("testKey1_11"),
+		WithRedisRedLockToken("test1"),
+	)
+	require.NoError(t, err)
+	require.NoError(t, lock.Lock())
+	ctx := *lock.(*redisRedLock).ctx.Load()
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	// Bounded by the validity time.
+	require.Less(t, time.Until(deadline), 500*time.Millisecond-redLockClockPrecision)
+
+	// The majority nodes lost the lock, the reentrant acquisition
+	// misses the quorum but keeps the hold on the minority node.
+	require.NoError(t, nodes[1].Set("testKey1_11", "others"))
+	require.NoError(t, nodes[2].Set("testKey1_11", "others"))
+	require.True(t, errors.Is(lock.Lock(), ErrDLockAcquireFailed))
+	require.True(t, nodes[0].Exists("testKey1_11"))
+
+	// The minority node acquired by the failed attempt is released.
+	lock2, err := RedisRedLock(context.TODO(), loaders,
+		WithRedisRedLockTTL(500*time.Millisecond),
+		WithRedisRedLockKeys("testKey2_11"),
+		WithRedisRedLockToken("test2"),
+	)
+	require.NoError(t, err)
+	require.NoError(t, nodes[1].Set("testKey2_11", "others"))
+	require.NoError(t, nodes[2].Set("testKey2_11", "others"))
+	require.True(t, errors.Is(lock2.Lock(), ErrDLockAcquireFailed))
+	require.False(t, nodes[0].Exists("testKey2_11"))
+	v, err := nodes[1].Get("testKey2_11")
+	require.NoError(t, err)
+	require.Equal(t, "others", v)
+}
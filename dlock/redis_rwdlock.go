@@ -0,0 +1,196 @@
+package dlock
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+//go:embed rlock.lua
+var luaRWDLockReadAcquireScript string
+
+//go:embed runlock.lua
+var luaRWDLockReadReleaseScript string
+
+//go:embed wlock.lua
+var luaRWDLockWriteAcquireScript string
+
+var (
+	luaRWDLockReadAcquire  = redis.NewScript(luaRWDLockReadAcquireScript)
+	luaRWDLockReadRelease  = redis.NewScript(luaRWDLockReadReleaseScript)
+	luaRWDLockWriteAcquire = redis.NewScript(luaRWDLockWriteAcquireScript)
+)
+
+const (
+	rwDLockWriterKeySuffix  = ":writer"
+	rwDLockReadersKeySuffix = ":readers"
+)
+
+var _ RWDLocker = (*redisRWDLock)(nil)
+
+// redisRWDLock manages the writer by a string key and
+// the readers by a hash key (reader token -> expired at ms).
+type redisRWDLock struct {
+	*redisRWDLockOptions
+	rlocked atomic.Bool
+	wlocked atomic.Bool
+}
+
+func (dl *redisRWDLock) acquire(script *redis.Script) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if _, err := script.Eval(
+			ctx,
+			dl.scripterLoader(),
+			[]string{dl.writerKey, dl.readersKey},
+			dl.token, dl.ttl.Milliseconds(),
+		).Result(); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		return noErr
+	}
+}
+
+func (dl *redisRWDLock) RLock() error {
+	if err := retryAcquire(dl.ctx, dl.strategy, dl.acquire(luaRWDLockReadAcquire)); err != nil {
+		return infra.WrapErrorStackWithMessage(err, "redis rw dlock read lock failed")
+	}
+	dl.rlocked.Store(true)
+	return noErr
+}
+
+func (dl *redisRWDLock) RUnlock() error {
+	if !dl.rlocked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to read unlock a no init rw dlock")
+	}
+	if _, err := luaRWDLockReadRelease.Eval(
+		dl.ctx,
+		dl.scripterLoader(),
+		[]string{dl.readersKey},
+		dl.token,
+	).Result(); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	dl.rlocked.Store(false)
+	return noErr
+}
+
+func (dl *redisRWDLock) Lock() error {
+	if err := retryAcquire(dl.ctx, dl.strategy, dl.acquire(luaRWDLockWriteAcquire)); err != nil {
+		return infra.WrapErrorStackWithMessage(err, "redis rw dlock write lock failed")
+	}
+	dl.wlocked.Store(true)
+	return noErr
+}
+
+func (dl *redisRWDLock) Unlock() error {
+	if !dl.wlocked.Load() {
+		return infra.WrapErrorStackWithMessage(ErrDLockNoInit, "attempt to write unlock a no init rw dlock")
+	}
+	if _, err := luaDLockRelease.Eval(
+		dl.ctx,
+		dl.scripterLoader(),
+		[]string{dl.writerKey},
+		dl.token,
+	).Result(); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	dl.wlocked.Store(false)
+	return noErr
+}
+
+type redisRWDLockOptions struct {
+	ctx            context.Context
+	scripterLoader func() redis.Scripter
+	strategy       RetryStrategy
+	writerKey      string
+	readersKey     string
+	token          string
+	ttl            time.Duration
+}
+
+func RedisRWDLockBuilder(ctx context.Context, scripter func() redis.Scripter) *redisRWDLockOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &redisRWDLockOptions{ctx: ctx, scripterLoader: scripter}
+}
+
+func (opt *redisRWDLockOptions) TTL(ttl time.Duration) *redisRWDLockOptions {
+	opt.ttl = ttl
+	return opt
+}
+
+func (opt *redisRWDLockOptions) Token(token string) *redisRWDLockOptions {
+	opt.token = token + "&" + nano()
+	return opt
+}
+
+func (opt *redisRWDLockOptions) Key(key string) *redisRWDLockOptions {
+	opt.writerKey = key + rwDLockWriterKeySuffix
+	opt.readersKey = key + rwDLockReadersKeySuffix
+	return opt
+}
+
+func (opt *redisRWDLockOptions) Retry(strategy RetryStrategy) *redisRWDLockOptions {
+	opt.strategy = strategy
+	return opt
+}
+
+func (opt *redisRWDLockOptions) Build() (RWDLocker, error) {
+	if opt.scripterLoader == nil {
+		return nil, infra.NewErrorStack("redis rw dlock scripter loader is nil")
+	}
+	if opt.ttl.Milliseconds() <= 0 {
+		return nil, infra.NewErrorStack("redis rw dlock with zero ms TTL")
+	}
+	if len(opt.writerKey) <= 0 {
+		return nil, infra.NewErrorStack("redis rw dlock with empty key")
+	}
+	if len(opt.token) <= 0 {
+		opt.Token("")
+	}
+	if opt.strategy == nil {
+		opt.strategy = NoRetry()
+	}
+	return &redisRWDLock{redisRWDLockOptions: opt}, nil
+}
+
+type RedisRWDLockOption func(opt *redisRWDLockOptions)
+
+func WithRedisRWDLockTTL(ttl time.Duration) RedisRWDLockOption {
+	return func(opt *redisRWDLockOptions) {
+		opt.TTL(ttl)
+	}
+}
+
+func WithRedisRWDLockKey(key string) RedisRWDLockOption {
+	return func(opt *redisRWDLockOptions) {
+		opt.Key(key)
+	}
+}
+
+func WithRedisRWDLockToken(token string) RedisRWDLockOption {
+	return func(opt *redisRWDLockOptions) {
+		opt.Token(token)
+	}
+}
+
+func WithRedisRWDLockRetry(strategy RetryStrategy) RedisRWDLockOption {
+	return func(opt *redisRWDLockOptions) {
+		opt.Retry(strategy)
+	}
+}
+
+func RedisRWDLock(ctx context.Context, scripter func() redis.Scripter, opts ...RedisRWDLockOption) (RWDLocker, error) {
+	builderOpts := RedisRWDLockBuilder(ctx, scripter)
+	for _, o := range opts {
+		o(builderOpts)
+	}
+	return builderOpts.Build()
+}
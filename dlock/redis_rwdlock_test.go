@@ -0,0 +1,82 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisRWDLock_MiniRedis(t *testing.T) {
+	require.NotEmpty(t, luaRWDLockReadAcquireScript)
+	require.NotEmpty(t, luaRWDLockReadReleaseScript)
+	require.NotEmpty(t, luaRWDLockWriteAcquireScript)
+
+	const addr = "127.0.0.1:6499"
+	mredis := mredisv2.NewMiniRedis()
+	defer func() { mredis.Close() }()
+	require.NoError(t, mredis.StartAddr(addr))
+
+	rclient := redisv9.NewClient(&redisv9.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})
+	defer func() { _ = rclient.Close() }()
+	scripter := func() redis.Scripter {
+		return rclient
+	}
+
+	reader1, err := RedisRWDLockBuilder(context.TODO(), scripter).
+		TTL(500 * time.Millisecond).
+		Key("testRWKey1").
+		Token("reader1").
+		Build()
+	require.NoError(t, err)
+	reader2, err := RedisRWDLock(context.TODO(), scripter,
+		WithRedisRWDLockTTL(500*time.Millisecond),
+		WithRedisRWDLockKey("testRWKey1"),
+		WithRedisRWDLockToken("reader2"),
+	)
+	require.NoError(t, err)
+	writer, err := RedisRWDLock(context.TODO(), scripter,
+		WithRedisRWDLockTTL(500*time.Millisecond),
+		WithRedisRWDLockKey("testRWKey1"),
+		WithRedisRWDLockToken("writer"),
+		WithRedisRWDLockRetry(LimitedRetry(10*time.Millisecond, 2)),
+	)
+	require.NoError(t, err)
+
+	require.Error(t, reader1.RUnlock())
+	require.Error(t, writer.Unlock())
+
+	// Shared readers.
+	require.NoError(t, reader1.RLock())
+	require.NoError(t, reader2.RLock())
+	require.True(t, errors.Is(writer.Lock(), ErrDLockAcquireFailed))
+	require.NoError(t, reader1.RUnlock())
+	require.True(t, errors.Is(writer.Lock(), ErrDLockAcquireFailed))
+	require.NoError(t, reader2.RUnlock())
+	require.False(t, mredis.Exists("testRWKey1"+rwDLockReadersKeySuffix))
+
+	// Exclusive writer.
+	require.NoError(t, writer.Lock())
+	require.True(t, errors.Is(reader1.RLock(), ErrDLockAcquireFailed))
+	// The writer is able to downgrade to a reader.
+	require.NoError(t, writer.RLock())
+	require.NoError(t, writer.RUnlock())
+	require.NoError(t, writer.Unlock())
+	require.NoError(t, reader1.RLock())
+	require.NoError(t, reader1.RUnlock())
+
+	// The expired readers will be purged by the writer.
+	require.NoError(t, reader1.RLock())
+	time.Sleep(600 * time.Millisecond)
+	require.NoError(t, writer.Lock())
+	require.NoError(t, writer.Unlock())
+}
@@ -1,9 +1,12 @@
 package dlock
 
 import (
+	"context"
 	randv2 "math/rand/v2"
 	"sync/atomic"
 	"time"
+
+	"go.uber.org/multierr"
 )
 
 type linearBackoff time.Duration
@@ -26,6 +29,13 @@ type limitedRetry struct {
 	maxCount int64
 }
 
+func (retry *limitedRetry) NewAttempt() RetryStrategy {
+	return &limitedRetry{
+		strategy: NewRetryAttempt(retry.strategy),
+		maxCount: retry.maxCount,
+	}
+}
+
 func (retry *limitedRetry) Next() time.Duration {
 	if atomic.LoadInt64(&retry.count) >= retry.maxCount {
 		return 0
@@ -51,11 +61,23 @@ func LimitedRetry(backoff time.Duration, maxCount int64) RetryStrategy {
 }
 
 type exponentialBackoff struct {
-	duration time.Duration
-	factor   float64
-	jitter   float64
-	steps    int64
-	cap      time.Duration
+	duration     time.Duration
+	initDuration time.Duration
+	factor       float64
+	jitter       float64
+	steps        int64
+	maxSteps     int64
+	cap          time.Duration
+}
+
+func (backoff *exponentialBackoff) NewAttempt() RetryStrategy {
+	return ExponentialBackoffRetry(
+		backoff.maxSteps,
+		backoff.initDuration,
+		backoff.cap,
+		backoff.factor,
+		backoff.jitter,
+	)
 }
 
 func (backoff *exponentialBackoff) Next() time.Duration {
@@ -79,11 +101,13 @@ func (backoff *exponentialBackoff) Next() time.Duration {
 
 func ExponentialBackoffRetry(maxSteps int64, initBackoff, maxBackoff time.Duration, backoffFactor, jitter float64) RetryStrategy {
 	return &exponentialBackoff{
-		cap:      maxBackoff,
-		duration: initBackoff,
-		factor:   backoffFactor,
-		jitter:   jitter,
-		steps:    maxSteps,
+		cap:          maxBackoff,
+		duration:     initBackoff,
+		initDuration: initBackoff,
+		factor:       backoffFactor,
+		jitter:       jitter,
+		steps:        maxSteps,
+		maxSteps:     maxSteps,
 	}
 }
 
@@ -96,3 +120,246 @@ func DefaultExponentialBackoffRetry() RetryStrategy {
 		0.1,
 	)
 }
+
+// minBackoff is the min backoff of the jitter strategies, because
+// the backoff less than 1ms means no more retry.
+const minBackoff = time.Millisecond
+
+// NewRetryAttempt returns the strategy with fresh state if it
+// implements the RetryStrategyFactory, otherwise returns itself.
+func NewRetryAttempt(strategy RetryStrategy) RetryStrategy {
+	if factory, ok := strategy.(RetryStrategyFactory); ok {
+		return factory.NewAttempt()
+	}
+	return strategy
+}
+
+// expBackoff returns the min(cap, base * 2^attempt) without overflow.
+func expBackoff(base, cap time.Duration, attempt int64) time.Duration {
+	backoff := base
+	for i := int64(0); i < attempt && backoff < cap; i++ {
+		backoff *= 2
+	}
+	return min(backoff, cap)
+}
+
+// randBackoff returns a random backoff in [low, high].
+func randBackoff(low, high time.Duration) time.Duration {
+	low = max(low, minBackoff)
+	if high <= low {
+		return low
+	}
+	return low + time.Duration(randv2.Int64N(int64(high-low)+1))
+}
+
+type fullJitterBackoff struct {
+	base    time.Duration
+	cap     time.Duration
+	attempt int64
+}
+
+func (backoff *fullJitterBackoff) NewAttempt() RetryStrategy {
+	return FullJitterBackoffRetry(backoff.base, backoff.cap)
+}
+
+func (backoff *fullJitterBackoff) Next() time.Duration {
+	attempt := atomic.AddInt64(&backoff.attempt, 1) - 1
+	return randBackoff(0, expBackoff(backoff.base, backoff.cap, attempt))
+}
+
+// FullJitterBackoffRetry retries endlessly with the backoff in
+// [0, min(cap, base * 2^attempt)].
+// References:
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func FullJitterBackoffRetry(base, cap time.Duration) RetryStrategy {
+	if base.Milliseconds() <= 0 {
+		return NoRetry()
+	}
+	return &fullJitterBackoff{base: base, cap: max(base, cap)}
+}
+
+type decorrelatedJitterBackoff struct {
+	base time.Duration
+	cap  time.Duration
+	prev atomic.Int64
+}
+
+func (backoff *decorrelatedJitterBackoff) NewAttempt() RetryStrategy {
+	return DecorrelatedJitterBackoffRetry(backoff.base, backoff.cap)
+}
+
+func (backoff *decorrelatedJitterBackoff) Next() time.Duration {
+	prev := time.Duration(backoff.prev.Load())
+	if prev <= 0 {
+		prev = backoff.base
+	}
+	next := min(backoff.cap, randBackoff(backoff.base, 3*prev))
+	backoff.prev.Store(int64(next))
+	return next
+}
+
+// DecorrelatedJitterBackoffRetry retries endlessly with the backoff
+// in [base, min(cap, prev backoff * 3)].
+// References:
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func DecorrelatedJitterBackoffRetry(base, cap time.Duration) RetryStrategy {
+	if base.Milliseconds() <= 0 {
+		return NoRetry()
+	}
+	return &decorrelatedJitterBackoff{base: base, cap: max(base, cap)}
+}
+
+type maxRetriesRetry struct {
+	strategy   RetryStrategy
+	retries    atomic.Int64
+	maxRetries int64
+}
+
+func (retry *maxRetriesRetry) NewAttempt() RetryStrategy {
+	return WithMaxRetries(NewRetryAttempt(retry.strategy), retry.maxRetries)
+}
+
+func (retry *maxRetriesRetry) Next() time.Duration {
+	if retry.retries.Add(1) > retry.maxRetries {
+		return 0
+	}
+	return retry.strategy.Next()
+}
+
+// WithMaxRetries stops retrying after max retries, so the acquisition
+// is attempted maxRetries+1 times at most, including the first one.
+func WithMaxRetries(strategy RetryStrategy, maxRetries int64) RetryStrategy {
+	return &maxRetriesRetry{strategy: strategy, maxRetries: maxRetries}
+}
+
+type maxElapsedRetry struct {
+	strategy   RetryStrategy
+	maxElapsed time.Duration
+	startTime  time.Time
+}
+
+func (retry *maxElapsedRetry) NewAttempt() RetryStrategy {
+	return WithMaxElapsed(NewRetryAttempt(retry.strategy), retry.maxElapsed)
+}
+
+func (retry *maxElapsedRetry) Next() time.Duration {
+	rest := retry.maxElapsed - time.Since(retry.startTime)
+	if rest < minBackoff {
+		return 0
+	}
+	return min(retry.strategy.Next(), rest)
+}
+
+// WithMaxElapsed stops retrying after the max elapsed time since the
+// strategy is created, or the acquisition begins by the NewAttempt.
+// The last backoff will be cut to the rest time.
+func WithMaxElapsed(strategy RetryStrategy, maxElapsed time.Duration) RetryStrategy {
+	return &maxElapsedRetry{
+		strategy:   strategy,
+		maxElapsed: maxElapsed,
+		startTime:  time.Now(),
+	}
+}
+
+type deadlineRetry struct {
+	strategy RetryStrategy
+	deadline time.Time
+}
+
+func (retry *deadlineRetry) NewAttempt() RetryStrategy {
+	return WithDeadline(NewRetryAttempt(retry.strategy), retry.deadline)
+}
+
+func (retry *deadlineRetry) Next() time.Duration {
+	rest := time.Until(retry.deadline)
+	if rest < minBackoff {
+		return 0
+	}
+	return min(retry.strategy.Next(), rest)
+}
+
+// WithDeadline stops retrying after the deadline. The last backoff
+// will be cut to the rest time.
+func WithDeadline(strategy RetryStrategy, deadline time.Time) RetryStrategy {
+	return &deadlineRetry{strategy: strategy, deadline: deadline}
+}
+
+type chainRetry struct {
+	strategies []RetryStrategy
+	idx        int
+}
+
+func (retry *chainRetry) NewAttempt() RetryStrategy {
+	strategies := make([]RetryStrategy, 0, len(retry.strategies))
+	for _, strategy := range retry.strategies {
+		strategies = append(strategies, NewRetryAttempt(strategy))
+	}
+	return Chain(strategies...)
+}
+
+func (retry *chainRetry) Next() time.Duration {
+	for ; retry.idx < len(retry.strategies); retry.idx++ {
+		if backoff := retry.strategies[retry.idx].Next(); backoff.Milliseconds() >= 1 {
+			return backoff
+		}
+	}
+	return 0
+}
+
+// Chain retries by the strategies in order, the next strategy
+// takes over once the previous one reaches to max.
+// For example, retries quickly 3 times and then slowly:
+//
+//	Chain(LimitedRetry(10*time.Millisecond, 3), EndlessRetry(time.Second))
+func Chain(strategies ...RetryStrategy) RetryStrategy {
+	return &chainRetry{strategies: strategies}
+}
+
+// retryAcquire runs the acquire function until it succeeds, the retry
+// strategy reaches to max or the context is cancelled.
+func retryAcquire(ctx context.Context, strategy RetryStrategy, acquire func(ctx context.Context) error) error {
+	return retryAcquireOrWake[struct{}](ctx, strategy, nil, acquire)
+}
+
+// retryAcquireOrWake retries immediately if it is woken up by the wakeC
+// before the backoff elapsed. The nil wakeC never wakes up.
+func retryAcquireOrWake[T any](
+	ctx context.Context,
+	strategy RetryStrategy,
+	wakeC <-chan T,
+	acquire func(ctx context.Context) error,
+) error {
+	var (
+		ticker *time.Ticker
+		merr   error
+	)
+	strategy = NewRetryAttempt(strategy)
+	for {
+		err := acquire(ctx)
+		if err == nil {
+			return noErr
+		}
+		merr = multierr.Append(merr, err)
+
+		backoff := strategy.Next()
+		if backoff.Milliseconds() < 1 {
+			return multierr.Append(merr, ErrDLockAcquireFailed)
+		}
+
+		if ticker == nil {
+			ticker = time.NewTicker(backoff)
+			defer ticker.Stop() // Avoid ticker leak.
+		} else {
+			ticker.Reset(backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wakeC:
+			// continue
+		case <-ticker.C:
+			// continue
+		}
+	}
+}
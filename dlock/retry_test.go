@@ -1,8 +1,12 @@
 package dlock
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestBackoffRetry(t *testing.T) {
@@ -29,3 +33,101 @@ func TestBackoffRetry(t *testing.T) {
 		t.Log("limited backoff 2", i, limitedRetry.Next())
 	}
 }
+
+func TestRetryStrategy_NewAttempt(t *testing.T) {
+	limited := LimitedRetry(10*time.Millisecond, 2)
+	for range 2 {
+		attempt := NewRetryAttempt(limited)
+		require.GreaterOrEqual(t, attempt.Next(), 10*time.Millisecond)
+		require.GreaterOrEqual(t, attempt.Next(), 10*time.Millisecond)
+		require.Zero(t, attempt.Next())
+	}
+
+	exBackoff := ExponentialBackoffRetry(1, 10*time.Millisecond, 0, 2.0, 0)
+	require.Equal(t, 10*time.Millisecond, exBackoff.Next())
+	require.Zero(t, exBackoff.Next())
+	require.Equal(t, 10*time.Millisecond, NewRetryAttempt(exBackoff).Next())
+
+	// The stateless strategy is returned as is.
+	endless := EndlessRetry(10 * time.Millisecond)
+	require.Equal(t, endless, NewRetryAttempt(endless))
+}
+
+func TestRetryStrategy_Jitter(t *testing.T) {
+	fullJitter := FullJitterBackoffRetry(10*time.Millisecond, 80*time.Millisecond)
+	for i := range 10 {
+		backoff := fullJitter.Next()
+		require.GreaterOrEqual(t, backoff, time.Millisecond)
+		require.LessOrEqual(t, backoff, min(10*time.Millisecond<<i, 80*time.Millisecond))
+	}
+
+	decorrelated := DecorrelatedJitterBackoffRetry(10*time.Millisecond, 80*time.Millisecond)
+	for range 10 {
+		backoff := decorrelated.Next()
+		require.GreaterOrEqual(t, backoff, 10*time.Millisecond)
+		require.LessOrEqual(t, backoff, 80*time.Millisecond)
+	}
+
+	require.Zero(t, FullJitterBackoffRetry(0, time.Second).Next())
+	require.Zero(t, DecorrelatedJitterBackoffRetry(0, time.Second).Next())
+}
+
+func TestRetryStrategy_Combinators(t *testing.T) {
+	// 2 retries after the first attempt.
+	maxRetries := WithMaxRetries(EndlessRetry(10*time.Millisecond), 2)
+	for range 2 {
+		attempt := NewRetryAttempt(maxRetries)
+		require.Equal(t, 10*time.Millisecond, attempt.Next())
+		require.Equal(t, 10*time.Millisecond, attempt.Next())
+		require.Zero(t, attempt.Next())
+	}
+
+	deadline := WithDeadline(EndlessRetry(time.Second), time.Now().Add(50*time.Millisecond))
+	backoff := deadline.Next()
+	require.Greater(t, backoff, time.Duration(0))
+	require.LessOrEqual(t, backoff, 50*time.Millisecond)
+	require.Zero(t, WithDeadline(EndlessRetry(time.Second), time.Now().Add(-time.Second)).Next())
+
+	maxElapsed := WithMaxElapsed(EndlessRetry(20*time.Millisecond), 30*time.Millisecond)
+	require.Equal(t, 20*time.Millisecond, maxElapsed.Next())
+	time.Sleep(35 * time.Millisecond)
+	require.Zero(t, maxElapsed.Next())
+	// The elapsed time is counted from the new attempt.
+	require.Equal(t, 20*time.Millisecond, NewRetryAttempt(maxElapsed).Next())
+
+	chain := Chain(
+		WithMaxRetries(EndlessRetry(10*time.Millisecond), 2),
+		WithMaxRetries(EndlessRetry(20*time.Millisecond), 1),
+	)
+	for range 2 {
+		attempt := NewRetryAttempt(chain)
+		require.Equal(t, 10*time.Millisecond, attempt.Next())
+		require.Equal(t, 10*time.Millisecond, attempt.Next())
+		require.Equal(t, 20*time.Millisecond, attempt.Next())
+		require.Zero(t, attempt.Next())
+	}
+}
+
+func TestRetryStrategy_ReusedByLocker(t *testing.T) {
+	registry := NewMemDLockRegistry(nil)
+	strategy := LimitedRetry(5*time.Millisecond, 2)
+	holder, err := MemDLock(context.TODO(), registry,
+		WithMemDLockTTL(time.Second),
+		WithMemDLockKeys("testKey_retry"),
+	)
+	require.NoError(t, err)
+	require.NoError(t, holder.Lock())
+
+	lock, err := MemDLock(context.TODO(), registry,
+		WithMemDLockTTL(time.Second),
+		WithMemDLockKeys("testKey_retry"),
+		WithMemDLockRetry(strategy),
+	)
+	require.NoError(t, err)
+	// Each acquisition retries with a fresh attempt of the same strategy.
+	for range 2 {
+		start := time.Now()
+		require.True(t, errors.Is(lock.Lock(), ErrDLockAcquireFailed))
+		require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	}
+}
@@ -0,0 +1,30 @@
+-- Acquire the read (shared) lock.
+-- rlock.lua token ttl
+--
+-- KEYS[1] is the writer key, its value is the writer token.
+-- KEYS[2] is the readers hash key, field is the reader token
+-- and value is the reader expired at milliseconds.
+
+-- GET key
+-- The writer with the same token is able to downgrade to a reader.
+local writer = redis.call("GET", KEYS[1])
+if writer and writer ~= ARGV[1] then
+    return redis.error_reply("dlock write occupied")
+end
+
+-- TIME
+-- 1: unix time in seconds.
+-- 2: microseconds.
+local now = redis.call("TIME")
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+local ttl = tonumber(ARGV[2])
+
+-- HSET key field value
+redis.call("HSET", KEYS[2], ARGV[1], nowMs + ttl)
+
+-- PTTL key
+-- The readers hash lives as long as the latest reader.
+if redis.call("PTTL", KEYS[2]) < ttl then
+    redis.call("PEXPIRE", KEYS[2], ttl)
+end
+return redis.status_reply("OK")
@@ -0,0 +1,17 @@
+-- Release the read (shared) lock.
+-- runlock.lua token
+--
+-- KEYS[1] is the readers hash key.
+
+-- HDEL key field
+-- 1: Removed.
+-- 0: Not exist.
+if redis.call("HDEL", KEYS[1], ARGV[1]) == 0 then
+    return redis.error_reply("dlock token mismatch, unable to unlock")
+end
+
+-- HLEN key
+if redis.call("HLEN", KEYS[1]) == 0 then
+    redis.call("DEL", KEYS[1])
+end
+return redis.status_reply("OK")
@@ -0,0 +1,40 @@
+-- Acquire permits from the counting semaphore.
+-- semacquire.lua token permits limit ttl
+--
+-- KEYS[1] is the holders sorted set key, member is the holder
+-- token with the permit index and score is the expired at milliseconds.
+
+-- TIME
+-- 1: unix time in seconds.
+-- 2: microseconds.
+local now = redis.call("TIME")
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+local permits = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+-- ZREMRANGEBYSCORE key min max
+-- Purge the expired permits.
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", nowMs)
+
+-- ZCARD key
+if redis.call("ZCARD", KEYS[1]) + permits > limit then
+    return redis.error_reply("dsemaphore permits exhausted")
+end
+
+-- ZADD key score member [score member ...]
+-- Redis Lua5.1 only support unpack() function,
+-- so we can't use table.unpack() here.
+local argvSet = {}
+for i = 1, permits do
+    table.insert(argvSet, nowMs + ttl)
+    table.insert(argvSet, ARGV[1] .. "#" .. i)
+end
+redis.call("ZADD", KEYS[1], unpack(argvSet))
+
+-- PTTL key
+-- The holders sorted set lives as long as the latest permit.
+if redis.call("PTTL", KEYS[1]) < ttl then
+    redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return redis.status_reply("OK")
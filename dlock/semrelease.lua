@@ -0,0 +1,17 @@
+-- Release the permits of the holder.
+-- semrelease.lua token permits
+--
+-- KEYS[1] is the holders sorted set key.
+
+local members = {}
+for i = 1, tonumber(ARGV[2]) do
+    table.insert(members, ARGV[1] .. "#" .. i)
+end
+
+-- ZREM key member [member ...]
+-- Redis Lua5.1 only support unpack() function,
+-- so we can't use table.unpack() here.
+if redis.call("ZREM", KEYS[1], unpack(members)) == 0 then
+    return redis.error_reply("dsemaphore token mismatch, unable to release")
+end
+return redis.status_reply("OK")
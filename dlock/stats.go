@@ -0,0 +1,229 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+
+	"github.com/benz9527/xboot/xlog"
+)
+
+const (
+	DLockStatsName = "xboot/dlock"
+)
+
+const (
+	dlockAcquireFailedExhausted = "exhausted"
+	dlockAcquireFailedOccupied  = "occupied"
+	dlockAcquireFailedTimeout   = "timeout"
+	dlockAcquireFailedCancelled = "cancelled"
+	dlockAcquireFailedError     = "error"
+)
+
+// dlockStats records the metrics and logs of the dlock acquisitions,
+// releases and renewals. All methods are nil safe, so the stats is
+// nil if both of the metrics and logger are disabled.
+type dlockStats struct {
+	ctx                  context.Context
+	logger               xlog.XLogger
+	kind                 string
+	keys                 []string
+	token                string
+	attrs                attribute.Set
+	lockedAt             atomic.Int64 // Unix nanoseconds.
+	acquireLatencies     metric.Int64Histogram
+	acquireAttempts      metric.Int64Histogram
+	acquireFailedCounter metric.Int64Counter
+	holdDurations        metric.Int64Histogram
+	renewalCounter       metric.Int64Counter
+	leaseLostCounter     metric.Int64Counter
+}
+
+// acquireFailedReason classifies the acquisition error.
+func acquireFailedReason(err error) string {
+	switch {
+	case err == nil:
+		return dlockAcquireFailedOccupied
+	case errors.Is(err, context.DeadlineExceeded):
+		return dlockAcquireFailedTimeout
+	case errors.Is(err, context.Canceled):
+		return dlockAcquireFailedCancelled
+	case errors.Is(err, ErrDLockAcquireFailed):
+		return dlockAcquireFailedExhausted
+	default:
+		return dlockAcquireFailedError
+	}
+}
+
+func (stats *dlockStats) fields(fields ...zap.Field) []zap.Field {
+	return append([]zap.Field{
+		zap.String("dlock.kind", stats.kind),
+		zap.Strings("dlock.keys", stats.keys),
+		zap.String("dlock.token", stats.token),
+	}, fields...)
+}
+
+func (stats *dlockStats) recordAcquisition(beginTime time.Time, attempts int64) {
+	stats.acquireLatencies.Record(stats.ctx, time.Since(beginTime).Milliseconds(), metric.WithAttributeSet(stats.attrs))
+	stats.acquireAttempts.Record(stats.ctx, attempts, metric.WithAttributeSet(stats.attrs))
+}
+
+func (stats *dlockStats) RecordAcquired(ctx context.Context, beginTime time.Time, attempts int64, fence uint64) {
+	if stats == nil {
+		return
+	}
+	stats.recordAcquisition(beginTime, attempts)
+	// Keeps the first locked time of the reentrant lock.
+	stats.lockedAt.CompareAndSwap(0, time.Now().UnixNano())
+	if stats.logger != nil {
+		stats.logger.InfoContext(ctx, "dlock acquired", stats.fields(
+			zap.Uint64("dlock.fence", fence),
+			zap.Int64("dlock.attempts", attempts),
+			zap.Duration("dlock.latency", time.Since(beginTime)),
+		)...)
+	}
+}
+
+// RecordAcquireFailed records the failed acquisition. The nil error
+// means that the lock is occupied by others without retry.
+func (stats *dlockStats) RecordAcquireFailed(ctx context.Context, beginTime time.Time, attempts int64, err error) {
+	if stats == nil {
+		return
+	}
+	reason := acquireFailedReason(err)
+	stats.recordAcquisition(beginTime, attempts)
+	stats.acquireFailedCounter.Add(stats.ctx, 1, metric.WithAttributes(
+		append(stats.attrs.ToSlice(), attribute.String("dlock.acquire.failed.reason", reason))...,
+	))
+	if stats.logger != nil {
+		stats.logger.WarnContext(ctx, "dlock acquire failed", stats.fields(
+			zap.String("dlock.acquire.failed.reason", reason),
+			zap.Int64("dlock.attempts", attempts),
+			zap.Duration("dlock.latency", time.Since(beginTime)),
+			zap.Error(err),
+		)...)
+	}
+}
+
+func (stats *dlockStats) RecordReleased(ctx context.Context) {
+	if stats == nil {
+		return
+	}
+	var holdDuration time.Duration
+	if lockedAt := stats.lockedAt.Swap(0); lockedAt > 0 {
+		holdDuration = time.Since(time.Unix(0, lockedAt))
+		stats.holdDurations.Record(stats.ctx, holdDuration.Milliseconds(), metric.WithAttributeSet(stats.attrs))
+	}
+	if stats.logger != nil {
+		stats.logger.InfoContext(ctx, "dlock released", stats.fields(
+			zap.Duration("dlock.hold.duration", holdDuration),
+		)...)
+	}
+}
+
+func (stats *dlockStats) RecordRenewal(err error) {
+	if stats == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	stats.renewalCounter.Add(stats.ctx, 1, metric.WithAttributes(
+		append(stats.attrs.ToSlice(), attribute.String("dlock.renewal.result", result))...,
+	))
+	if err != nil && stats.logger != nil {
+		stats.logger.WarnContext(stats.ctx, "dlock renewal failed", stats.fields(zap.Error(err))...)
+	}
+}
+
+// leaseLostHandler wraps the handler to record the lost lease.
+func (stats *dlockStats) leaseLostHandler(onLost LeaseLostHandler) LeaseLostHandler {
+	if stats == nil {
+		return onLost
+	}
+	return func(err error) {
+		stats.lockedAt.Store(0)
+		stats.leaseLostCounter.Add(stats.ctx, 1, metric.WithAttributeSet(stats.attrs))
+		if stats.logger != nil {
+			stats.logger.ErrorContext(stats.ctx, err, "dlock lease lost", stats.fields()...)
+		}
+		if onLost != nil {
+			onLost(err)
+		}
+	}
+}
+
+// newDLockStats returns nil if both of the metrics and logger are disabled.
+// The raw keys are only logged, they are not the metric attributes, which
+// are unbounded with the dynamic keys. The name is the low cardinality
+// attribute of the metrics set by the user, it is omitted if empty.
+func newDLockStats(kind, name string, keys []string, token string, enableMetrics bool, logger xlog.XLogger) *dlockStats {
+	if !enableMetrics && logger == nil {
+		return nil
+	}
+	meterName := fmt.Sprintf("%s/%s", DLockStatsName, kind)
+	var meter metric.Meter = noop.Meter{}
+	if enableMetrics {
+		meter = otel.Meter(meterName)
+	}
+	attrs := []attribute.KeyValue{attribute.String("dlock.kind", kind)}
+	if len(name) > 0 {
+		attrs = append(attrs, attribute.String("dlock.name", name))
+	}
+	return &dlockStats{
+		ctx:    context.Background(),
+		logger: logger,
+		kind:   kind,
+		keys:   keys,
+		token:  token,
+		attrs:  attribute.NewSet(attrs...),
+		acquireLatencies: lo.Must[metric.Int64Histogram](meter.
+			Int64Histogram(
+				"dlock.acquire.latency",
+				metric.WithDescription("The latency of the dlock acquisition. In milliseconds."),
+				metric.WithUnit("ms"),
+			),
+		),
+		acquireAttempts: lo.Must[metric.Int64Histogram](meter.
+			Int64Histogram(
+				"dlock.acquire.attempts",
+				metric.WithDescription("The number of attempts per dlock acquisition."),
+			),
+		),
+		acquireFailedCounter: lo.Must[metric.Int64Counter](meter.
+			Int64Counter(
+				"dlock.acquire.failed.count",
+				metric.WithDescription("The number of failed dlock acquisitions by reason."),
+			),
+		),
+		holdDurations: lo.Must[metric.Int64Histogram](meter.
+			Int64Histogram(
+				"dlock.hold.duration",
+				metric.WithDescription("The duration of the dlock held. In milliseconds."),
+				metric.WithUnit("ms"),
+			),
+		),
+		renewalCounter: lo.Must[metric.Int64Counter](meter.
+			Int64Counter(
+				"dlock.renewal.count",
+				metric.WithDescription("The number of dlock renewals by result."),
+			),
+		),
+		leaseLostCounter: lo.Must[metric.Int64Counter](meter.
+			Int64Counter(
+				"dlock.lease.lost.count",
+				metric.WithDescription("The number of dlock lost leases detected by the watchdog."),
+			),
+		),
+	}
+}
@@ -15,4 +15,19 @@ end
 -- Redis Lua5.1 only support unpack() function,
 -- so we can't use table.unpack() here.
 redis.call("DEL", unpack(KEYS))
+-- The acquired time metadata keys are the lock keys with the suffix (optional).
+if ARGV[3] then
+    for _, k in ipairs(KEYS) do
+        redis.call("DEL", k .. ARGV[3])
+    end
+end
+
+-- PUBLISH channel message
+-- Notify the waiters that the keys have been released (optional).
+-- The channel is the key with the suffix.
+if ARGV[2] then
+    for _, k in ipairs(KEYS) do
+        redis.call("PUBLISH", k .. ARGV[2], ARGV[1])
+    end
+end
 return redis.status_reply("OK")
@@ -0,0 +1,137 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+// The watchdog renews the dlock's lease at 1/3 TTL interval, so
+// there are two more chances to renew the lease before it expires.
+const watchdogRenewalDivisor = 3
+
+// dlockWatchdog renews the dlock's lease in background until
+// the dlock is released, the context is cancelled or the lease
+// is lost.
+type dlockWatchdog struct {
+	ttl      time.Duration
+	interval time.Duration
+	onLost   LeaseLostHandler
+	lock     sync.Mutex
+	stopC    chan struct{}
+}
+
+func newDLockWatchdog(ttl time.Duration, onLost LeaseLostHandler) *dlockWatchdog {
+	interval := ttl / watchdogRenewalDivisor
+	if interval <= 0 {
+		interval = ttl
+	}
+	return &dlockWatchdog{
+		ttl:      ttl,
+		interval: interval,
+		onLost:   onLost,
+	}
+}
+
+// start runs the renewal loop. The renew function returns an error
+// wraps the ErrDLockLeaseLost if the lease has gone definitely, and
+// other errors are regarded as transient errors until the TTL elapsed
+// since the last successful renewal.
+// The leaseDoneC is optional, it will be closed by the backend if the
+// lease is lost.
+func (wd *dlockWatchdog) start(
+	ctx context.Context,
+	renew func(ctx context.Context) error,
+	leaseDoneC <-chan struct{},
+) {
+	if wd == nil || ctx == nil || renew == nil {
+		return
+	}
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+	if wd.stopC != nil {
+		// Reentrant lock, the watchdog is running.
+		return
+	}
+	stopC := make(chan struct{})
+	wd.stopC = stopC
+	go wd.run(ctx, renew, leaseDoneC, stopC)
+}
+
+func (wd *dlockWatchdog) run(
+	ctx context.Context,
+	renew func(ctx context.Context) error,
+	leaseDoneC <-chan struct{},
+	stopC chan struct{},
+) {
+	ticker := time.NewTicker(wd.interval)
+	defer ticker.Stop()
+
+	lastRenewal := time.Now()
+	for {
+		select {
+		case <-stopC:
+			return
+		case <-ctx.Done():
+			wd.exit(stopC)
+			return
+		case <-leaseDoneC:
+			wd.lost(stopC, infra.WrapErrorStackWithMessage(ErrDLockLeaseLost, "dlock lease done by backend"))
+			return
+		case <-ticker.C:
+		}
+
+		renewCtx, cancel := context.WithTimeout(ctx, wd.interval)
+		err := renew(renewCtx)
+		cancel()
+		if err == nil {
+			lastRenewal = time.Now()
+			continue
+		}
+		select {
+		case <-stopC:
+			// Released during renewal, the error makes no sense.
+			return
+		default:
+		}
+		if errors.Is(err, ErrDLockLeaseLost) || time.Since(lastRenewal) >= wd.ttl {
+			wd.lost(stopC, infra.AppendErrorStack(ErrDLockLeaseLost, err))
+			return
+		}
+	}
+}
+
+// exit resets the watchdog if it is still the current round.
+func (wd *dlockWatchdog) exit(stopC chan struct{}) bool {
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+	if wd.stopC != stopC {
+		return false
+	}
+	wd.stopC = nil
+	return true
+}
+
+func (wd *dlockWatchdog) lost(stopC chan struct{}, err error) {
+	// The handler is called outside the lock, so it is
+	// safe to unlock the dlock in the handler.
+	if wd.exit(stopC) && wd.onLost != nil {
+		wd.onLost(err)
+	}
+}
+
+func (wd *dlockWatchdog) stop() {
+	if wd == nil {
+		return
+	}
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+	if wd.stopC == nil {
+		return
+	}
+	close(wd.stopC)
+	wd.stopC = nil
+}
@@ -0,0 +1,34 @@
+-- Acquire the write (exclusive) lock.
+-- wlock.lua token ttl
+--
+-- KEYS[1] is the writer key, its value is the writer token.
+-- KEYS[2] is the readers hash key, field is the reader token
+-- and value is the reader expired at milliseconds.
+
+-- GET key
+local writer = redis.call("GET", KEYS[1])
+if writer and writer ~= ARGV[1] then
+    return redis.error_reply("dlock write occupied")
+end
+
+-- TIME
+-- 1: unix time in seconds.
+-- 2: microseconds.
+local now = redis.call("TIME")
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+-- HGETALL key
+-- Purge the expired readers. The reader with the same
+-- token is able to upgrade to the writer.
+local readers = redis.call("HGETALL", KEYS[2])
+for i = 1, #readers, 2 do
+    if tonumber(readers[i + 1]) <= nowMs then
+        redis.call("HDEL", KEYS[2], readers[i])
+    elseif readers[i] ~= ARGV[1] then
+        return redis.error_reply("dlock read occupied")
+    end
+end
+
+-- SET key value PX milliseconds
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return redis.status_reply("OK")
@@ -0,0 +1,152 @@
+package hrtime
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	_ TimerClock = (*FakeClock)(nil)
+	_ HoldClock  = (*FakeClock)(nil)
+)
+
+// FakeClock is the TimerClock driven by the Advance manually, so the
+// time based components are able to be tested without sleeping.
+type FakeClock struct {
+	lock      sync.Mutex
+	idleCond  *sync.Cond // Signaled once all the holds are released
+	holds     int64
+	startTime time.Time
+	now       time.Time
+	timers    []*fakeTimer
+}
+
+// NewFakeClock creates the FakeClock starting at the now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{
+		startTime: now,
+		now:       now,
+	}
+	c.idleCond = sync.NewCond(&c.lock)
+	return c
+}
+
+func (c *FakeClock) NowIn(offset TimeZoneOffset) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now.In(loadTZLocation(offset))
+}
+
+func (c *FakeClock) NowInDefaultTZ() time.Time {
+	return c.NowIn(TimeZoneOffset(DefaultTimezoneOffset()))
+}
+
+func (c *FakeClock) NowInUTC() time.Time {
+	return c.NowIn(TzUtc0Offset)
+}
+
+func (c *FakeClock) MonotonicElapsed() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now.Sub(c.startTime)
+}
+
+func (c *FakeClock) Since(beginTime time.Time) time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now.Sub(beginTime)
+}
+
+// AfterFunc schedules the fn fired by the Advance. The fn is called in
+// a new goroutine if the duration is not positive, the same as the
+// time.AfterFunc.
+func (c *FakeClock) AfterFunc(d time.Duration, fn func()) Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	timer := &fakeTimer{
+		clock:  c,
+		fireAt: c.now.Add(d),
+		fn:     fn,
+	}
+	if d <= 0 {
+		go fn()
+		return timer
+	}
+	c.timers = append(c.timers, timer)
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].fireAt.Before(c.timers[j].fireAt)
+	})
+	return timer
+}
+
+// Advance moves the clock forward by the duration, and fires the due
+// timers synchronously one by one in the order of their fire time.
+// The clock has been at the target time while they are firing, so the
+// timers scheduled by them are fired only if they are due as well.
+// Each timer is fired after the holds are released, and the Advance
+// returns once the work held by the last timer is done.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	for {
+		for c.holds > 0 {
+			c.idleCond.Wait()
+		}
+		if len(c.timers) <= 0 || c.timers[0].fireAt.After(c.now) {
+			return
+		}
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		c.lock.Unlock()
+		timer.fn()
+		c.lock.Lock()
+	}
+}
+
+// Hold marks the work triggered by the timer in flight, the Advance
+// waits until it is released.
+func (c *FakeClock) Hold() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.holds++
+}
+
+func (c *FakeClock) Release() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.holds--; c.holds <= 0 {
+		c.holds = 0
+		c.idleCond.Broadcast()
+	}
+}
+
+// PendingTimers returns the number of the timers waiting to be fired.
+func (c *FakeClock) PendingTimers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+func (c *FakeClock) stop(timer *fakeTimer) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, t := range c.timers {
+		if t == timer {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	fireAt time.Time
+	fn     func()
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.clock.stop(t)
+}
@@ -0,0 +1,70 @@
+package hrtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	begin := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(begin)
+	require.Equal(t, begin.UnixMilli(), clock.NowInUTC().UnixMilli())
+	require.Equal(t, time.Duration(0), clock.MonotonicElapsed())
+
+	var fired []int
+	AfterFunc(clock, 3*time.Second, func() { fired = append(fired, 3) })
+	AfterFunc(clock, time.Second, func() {
+		fired = append(fired, 1)
+		// Scheduled from the target time of the advance.
+		AfterFunc(clock, time.Second, func() { fired = append(fired, 10) })
+		AfterFunc(clock, 2*time.Second, func() { fired = append(fired, 20) })
+	})
+	stopped := AfterFunc(clock, 2*time.Second, func() { fired = append(fired, 2) })
+	require.Equal(t, 3, clock.PendingTimers())
+	require.True(t, stopped.Stop())
+	require.False(t, stopped.Stop())
+
+	clock.Advance(999 * time.Millisecond)
+	require.Empty(t, fired)
+	clock.Advance(time.Millisecond)
+	require.Equal(t, []int{1}, fired)
+	require.Equal(t, 3, clock.PendingTimers())
+	clock.Advance(2 * time.Second)
+	require.Equal(t, []int{1, 10, 3, 20}, fired)
+	require.Equal(t, 0, clock.PendingTimers())
+
+	require.Equal(t, 3*time.Second, clock.MonotonicElapsed())
+	require.Equal(t, 3*time.Second, clock.Since(begin))
+	require.Equal(t, int(TzUtc8Offset), func() int {
+		_, offset := clock.NowIn(TzUtc8Offset).Zone()
+		return offset
+	}())
+}
+
+func TestFakeClock_Hold(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	var fired []int
+	workC := make(chan int)
+	go func() {
+		for i := range workC {
+			fired = append(fired, i)
+			Release(clock)
+		}
+	}()
+	// The work of the timers is done asynchronously.
+	AfterFunc(clock, time.Second, func() {
+		Hold(clock)
+		workC <- 1
+	})
+	AfterFunc(clock, 2*time.Second, func() {
+		Hold(clock)
+		workC <- 2
+	})
+	clock.Advance(time.Second)
+	require.Equal(t, []int{1}, fired)
+	clock.Advance(time.Second)
+	require.Equal(t, []int{1, 2}, fired)
+	close(workC)
+}
@@ -9,3 +9,54 @@ type Clock interface {
 	MonotonicElapsed() time.Duration
 	Since(time.Time) time.Duration
 }
+
+// Timer is the timer scheduled by the TimerClock.
+// The *time.Timer is a Timer.
+type Timer interface {
+	// Stop prevents the timer from firing, returns false if the timer
+	// has been fired or stopped.
+	Stop() bool
+}
+
+// TimerClock is the Clock scheduling the timers by its own time
+// instead of the wall clock.
+type TimerClock interface {
+	Clock
+	// AfterFunc calls the fn after the duration elapsed on the clock.
+	AfterFunc(d time.Duration, fn func()) Timer
+}
+
+// HoldClock is the TimerClock waiting for the asynchronous work
+// triggered by its timers, e.g. the FakeClock.Advance returns once the
+// expired items have been dispatched by the components.
+type HoldClock interface {
+	TimerClock
+	// Hold marks the work in flight.
+	Hold()
+	// Release marks the held work done.
+	Release()
+}
+
+// Hold marks the work in flight if the clock is a HoldClock, it has to
+// be released by the Release once the work is done.
+func Hold(clock Clock) {
+	if hc, ok := clock.(HoldClock); ok {
+		hc.Hold()
+	}
+}
+
+// Release marks the work held by the Hold done.
+func Release(clock Clock) {
+	if hc, ok := clock.(HoldClock); ok {
+		hc.Release()
+	}
+}
+
+// AfterFunc schedules the fn by the clock if it is a TimerClock,
+// otherwise by the wall clock.
+func AfterFunc(clock Clock, d time.Duration, fn func()) Timer {
+	if tc, ok := clock.(TimerClock); ok {
+		return tc.AfterFunc(d, fn)
+	}
+	return time.AfterFunc(d, fn)
+}
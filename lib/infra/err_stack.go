@@ -315,12 +315,12 @@ type errorStack struct {
 // For errors.Is(err, target error) and errors.As(err error, target any).
 func (es *errorStack) Unwrap() []error {
 	_errors := make([]error, 0, 8)
-	for err := es.err; es != nil; es = es.upper {
-		switch x := err.(type) {
+	for ; es != nil; es = es.upper {
+		switch x := es.err.(type) {
 		case interface{ Unwrap() []error }:
 			_errors = append(_errors, x.Unwrap()...)
 		default:
-			_errors = append(_errors, multierr.Errors(err)...)
+			_errors = append(_errors, multierr.Errors(es.err)...)
 		}
 	}
 	return _errors
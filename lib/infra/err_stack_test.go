@@ -463,4 +463,9 @@ func TestErrorStack_StrError(t *testing.T) {
 
 	merr := AppendErrorStack(es, stErr2, stErr3)
 	require.True(t, errors.Is(merr, stErr2))
+	require.True(t, errors.Is(merr, stErr1))
+
+	// The upper errors are unwrapped as well.
+	es = WrapErrorStackWithMessage(WrapErrorStackWithMessage(stErr1, "wrap1"), "wrap2")
+	require.True(t, errors.Is(es, stErr1))
 }
@@ -7,6 +7,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/benz9527/xboot/lib/hrtime"
 	"github.com/benz9527/xboot/lib/infra"
 )
 
@@ -40,6 +41,36 @@ type ArrayDelayQueue[E comparable] struct {
 	wakeUpC             chan struct{}
 	waitForNextExpItemC chan struct{}
 	sleeping            int32
+	clock               hrtime.Clock // Schedules the timer to wait for the next expired item
+	holds               atomic.Int64 // The clock's holds released once the poll falls asleep
+}
+
+// hold makes the clock wait until the expired items have been sent,
+// once the poll is woken up.
+func (dq *ArrayDelayQueue[E]) hold() {
+	dq.holds.Add(1)
+	hrtime.Hold(dq.clock)
+}
+
+// trySleep releases the holds and falls asleep, it returns false if
+// the item offered meanwhile has been expired.
+func (dq *ArrayDelayQueue[E]) trySleep(now int64) (deltaMs int64, ok bool) {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	if dq.pq.Len() > 0 {
+		if deltaMs = dq.pq.Peek().Priority() - now; deltaMs <= 0 {
+			return 0, false
+		}
+	}
+	dq.releaseHolds()
+	atomic.StoreInt32(&dq.sleeping, fallAsleep)
+	return deltaMs, true
+}
+
+func (dq *ArrayDelayQueue[E]) releaseHolds() {
+	for n := dq.holds.Swap(0); n > 0; n-- {
+		hrtime.Release(dq.clock)
+	}
 }
 
 func (dq *ArrayDelayQueue[E]) popIfExpired(expiredBoundary int64) (item ReadOnlyPQItem[E], deltaMs int64) {
@@ -64,18 +95,22 @@ func (dq *ArrayDelayQueue[E]) Offer(item E, expiration int64) {
 	e := NewDelayQueueItem[E](item, expiration)
 	dq.lock.Lock()
 	dq.pq.Push(e)
-	dq.lock.Unlock()
+	wakeUp := false
 	if e.Index() == 0 {
-		// Highest priority item, wake up the consumer
-		if atomic.CompareAndSwapInt32(&dq.sleeping, fallAsleep, wokeUp) {
-			dq.wakeUpC <- struct{}{}
-		}
+		// Highest priority item, wake up the consumer.
+		// The awake consumer releases the hold once it falls asleep.
+		dq.hold()
+		wakeUp = atomic.CompareAndSwapInt32(&dq.sleeping, fallAsleep, wokeUp)
+	}
+	dq.lock.Unlock()
+	if wakeUp {
+		dq.wakeUpC <- struct{}{}
 	}
 	dq.itemCounter.Add(1)
 }
 
 func (dq *ArrayDelayQueue[E]) poll(nowFn func() int64, sender infra.SendOnlyChannel[E]) {
-	var timer *time.Timer
+	var timer hrtime.Timer
 	defer func() {
 		// FIXME recover defer execution order
 		if err := recover(); err != nil {
@@ -83,6 +118,7 @@ func (dq *ArrayDelayQueue[E]) poll(nowFn func() int64, sender infra.SendOnlyChan
 		}
 		// before exit
 		atomic.StoreInt32(&dq.sleeping, wokeUp)
+		dq.releaseHolds()
 
 		if timer != nil {
 			timer.Stop()
@@ -96,7 +132,10 @@ func (dq *ArrayDelayQueue[E]) poll(nowFn func() int64, sender infra.SendOnlyChan
 			// No expired item in the queue
 			// 1. without any item in the queue
 			// 2. all items in the queue are not expired
-			atomic.StoreInt32(&dq.sleeping, fallAsleep)
+			ok := false
+			if deltaMs, ok = dq.trySleep(now); !ok {
+				continue
+			}
 
 			if deltaMs == 0 {
 				// Queue is empty, waiting for new item
@@ -114,8 +153,9 @@ func (dq *ArrayDelayQueue[E]) poll(nowFn func() int64, sender infra.SendOnlyChan
 				// Avoid to use time.After(), it will create a new timer every time
 				// what's worse, the underlay timer will not be GC.
 				// Asynchronous timer.
-				timer = time.AfterFunc(time.Duration(deltaMs)*time.Millisecond, func() {
+				timer = hrtime.AfterFunc(dq.clock, time.Duration(deltaMs)*time.Millisecond, func() {
 					if atomic.SwapInt32(&dq.sleeping, wokeUp) == fallAsleep {
+						dq.hold()
 						dq.waitForNextExpItemC <- struct{}{}
 					}
 				})
@@ -168,7 +208,19 @@ func (dq *ArrayDelayQueue[E]) Len() int64 {
 	return dq.itemCounter.Load()
 }
 
-func NewArrayDelayQueue[E comparable](ctx context.Context, capacity int) DelayQueue[E] {
+type ArrayDelayQueueOption[E comparable] func(*ArrayDelayQueue[E])
+
+// WithArrayDelayQueueClock waits for the next expired item by the
+// clock's timer if it is a hrtime.TimerClock, so the poll is driven
+// by the clock instead of the wall clock. The nowFn of the poll should
+// be based on the same clock.
+func WithArrayDelayQueueClock[E comparable](clock hrtime.Clock) ArrayDelayQueueOption[E] {
+	return func(dq *ArrayDelayQueue[E]) {
+		dq.clock = clock
+	}
+}
+
+func NewArrayDelayQueue[E comparable](ctx context.Context, capacity int, opts ...ArrayDelayQueueOption[E]) DelayQueue[E] {
 	dq := &ArrayDelayQueue[E]{
 		pq: NewArrayPriorityQueue[E](
 			WithArrayPriorityQueueEnableThreadSafe[E](),
@@ -180,5 +232,10 @@ func NewArrayDelayQueue[E comparable](ctx context.Context, capacity int) DelayQu
 		wakeUpC:             make(chan struct{}),
 		waitForNextExpItemC: make(chan struct{}),
 	}
+	for _, o := range opts {
+		if o != nil {
+			o(dq)
+		}
+	}
 	return dq
 }
@@ -7,6 +7,7 @@ import (
 	"unsafe"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/benz9527/xboot/lib/hrtime"
 	"github.com/benz9527/xboot/lib/infra"
@@ -164,3 +165,48 @@ func BenchmarkDelayQueue_PollToChan_zone(b *testing.B) {
 		}
 	}
 }
+
+func TestArrayDelayQueue_PollToChan_FakeClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	clock := hrtime.NewFakeClock(time.UnixMilli(1000))
+	dq := NewArrayDelayQueue[*employee](ctx, 32, WithArrayDelayQueueClock[*employee](clock))
+	// The Advance returns once the expired items have been sent.
+	receiver := infra.NewSafeClosableChannel[*employee](8)
+	defer func() {
+		_ = receiver.Close()
+	}()
+	go dq.PollToChan(
+		func() int64 {
+			return clock.NowInUTC().UnixMilli()
+		},
+		receiver,
+	)
+	dq.Offer(&employee{name: "p0"}, 1100)
+	dq.Offer(&employee{name: "p1"}, 1300)
+	dq.Offer(&employee{name: "p2"}, 1200)
+	// Waiting for the next expired item by the clock.
+	require.Eventually(t, func() bool {
+		return clock.PendingTimers() == 1
+	}, time.Second, time.Millisecond)
+
+	itemC := receiver.Wait()
+	receive := func() string {
+		select {
+		case item := <-itemC:
+			return item.name
+		default:
+		}
+		return ""
+	}
+	clock.Advance(99 * time.Millisecond)
+	require.Empty(t, receive())
+	clock.Advance(time.Millisecond)
+	require.Equal(t, "p0", receive())
+	require.Empty(t, receive())
+	clock.Advance(200 * time.Millisecond)
+	require.Equal(t, "p2", receive())
+	require.Equal(t, "p1", receive())
+	require.Equal(t, int64(0), dq.Len())
+}
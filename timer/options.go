@@ -24,6 +24,11 @@ const (
 	defaultMinSlotIncrementSize        = 10
 	defaultMinIntervalMilliseconds     = 20 // lt 20ms will overflow
 	defaultMinTickAccuracyMilliseconds = 1
+	defaultHiResTick                   = 100 * time.Microsecond
+	defaultMinHiResTick                = time.Microsecond
+	defaultHiResSlotSize               = 64
+	defaultHiResLevels                 = 5
+	defaultTaskStoreFlushInterval      = time.Second
 )
 
 type xTimingWheelsOption struct {
@@ -37,6 +42,17 @@ type xTimingWheelsOption struct {
 	workPoolSize   int
 	isValueChecked *atomic.Bool
 	enableStats    bool
+	taskStore      TaskStore
+	recoveryPolicy TaskRecoveryPolicy
+	flushInterval  time.Duration
+	jobs           map[string]registeredJob
+	dlockBuilder   DLockerBuilder
+	dlockAlignment time.Duration
+	jobTimeout     time.Duration
+	jobRetry       RetryStrategyConstructor
+	onJobResult    OnJobResult
+	hiResTick      time.Duration
+	hiResSlotSizes []int64
 }
 
 func (opt *xTimingWheelsOption) getBasicTickMilliseconds() int64 {
@@ -114,6 +130,60 @@ func (opt *xTimingWheelsOption) getStats() *xTimingWheelsStats {
 	return opt.stats
 }
 
+func (opt *xTimingWheelsOption) getTaskStore() TaskStore {
+	return opt.taskStore
+}
+
+func (opt *xTimingWheelsOption) getRecoveryPolicy() TaskRecoveryPolicy {
+	return opt.recoveryPolicy
+}
+
+func (opt *xTimingWheelsOption) getTaskStoreFlushInterval() time.Duration {
+	if opt.flushInterval <= 0 {
+		return defaultTaskStoreFlushInterval
+	}
+	return opt.flushInterval
+}
+
+func (opt *xTimingWheelsOption) getJobs() map[string]registeredJob {
+	if opt.jobs == nil {
+		return map[string]registeredJob{}
+	}
+	return opt.jobs
+}
+
+func (opt *xTimingWheelsOption) getJobDLockOption() jobDLockOption {
+	return newJobDLockOption(opt.dlockBuilder, opt.dlockAlignment, opt.onJobResult)
+}
+
+func (opt *xTimingWheelsOption) getJobExecOption() jobExecOption {
+	return jobExecOption{
+		clock:    opt.getClock(),
+		timeout:  opt.jobTimeout,
+		timedOut: newJobTimedOutAttempts(opt.getWorkerPoolSize()),
+		retry:    opt.jobRetry,
+		onResult: opt.onJobResult,
+	}
+}
+
+func (opt *xTimingWheelsOption) getHiResTick() time.Duration {
+	if opt.hiResTick < defaultMinHiResTick {
+		return defaultHiResTick
+	}
+	return opt.hiResTick
+}
+
+func (opt *xTimingWheelsOption) getHiResSlotSizes() []int64 {
+	if len(opt.hiResSlotSizes) <= 0 {
+		sizes := make([]int64, defaultHiResLevels)
+		for i := range sizes {
+			sizes[i] = defaultHiResSlotSize
+		}
+		return sizes
+	}
+	return opt.hiResSlotSizes
+}
+
 func (opt *xTimingWheelsOption) defaultDelayQueueCapacity() int {
 	return 128
 }
@@ -209,6 +279,160 @@ func WithTimingWheelsEventBufferSize(size int) TimingWheelsOption {
 	}
 }
 
+// WithTimingWheelsClock replaces the time source of the timing wheels.
+// The hrtime.TimerClock (e.g. the hrtime.FakeClock) drives the timing
+// wheels by its own time, the due tasks are fired once it advanced.
+// The hrtime.FakeClock.Advance returns once the due tasks have been
+// dispatched to the worker pool by the v2 and hi-res timing wheels.
+func WithTimingWheelsClock(clock hrtime.Clock) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if clock == nil {
+			panic("timing-wheels' clock must not be nil")
+		}
+		opt.clock = clock
+	}
+}
+
+// WithTimingWheelsTaskStore persists the named tasks into the store
+// and recovers them on the timing wheels created. The policy decides
+// how to recover the tasks expired while the timing wheels were down.
+func WithTimingWheelsTaskStore(store TaskStore, policy TaskRecoveryPolicy) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if store == nil {
+			panic("timing-wheels' task store must not be nil")
+		}
+		opt.taskStore = store
+		opt.recoveryPolicy = policy
+	}
+}
+
+// WithTimingWheelsTaskStoreFlushInterval is the interval to save the
+// progress of the repeat tasks into the task store in a batch.
+// The fires after the last saved progress are recovered by the policy.
+func WithTimingWheelsTaskStoreFlushInterval(interval time.Duration) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if interval <= 0 {
+			panic("timing-wheels' task store flush interval must be greater than 0")
+		}
+		opt.flushInterval = interval
+	}
+}
+
+// WithTimingWheelsJob registers the job and its task options by name,
+// the named tasks are recovered from the task store with the registered
+// jobs and options.
+// The task options are not persisted, so the options passed to the
+// NewNamedOnceTask and NewNamedRepeatTask are replaced by the registered
+// ones after restart.
+func WithTimingWheelsJob(name string, job Job, opts ...TaskOption) TimingWheelsOption {
+	return WithTimingWheelsErrJob(name, errJobAdapter(job), opts...)
+}
+
+// WithTimingWheelsErrJob is the same as the WithTimingWheelsJob, but the
+// job returns the error. The recovered tasks are created with it, so it
+// is registered for the NewNamedOnceErrTask and NewNamedRepeatErrTask.
+func WithTimingWheelsErrJob(name string, job ErrJob, opts ...TaskOption) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if len(strings.TrimSpace(name)) <= 0 {
+			panic("timing-wheels' job name must not be empty or blank")
+		}
+		if job == nil {
+			panic("timing-wheels' job must not be nil")
+		}
+		if opt.jobs == nil {
+			opt.jobs = make(map[string]registeredJob)
+		}
+		opt.jobs[name] = registeredJob{job: job, opts: opts}
+	}
+}
+
+// WithTimingWheelsDLock guards each fire of the jobs with the dlock
+// keyed by the job ID and the fire ms truncated to the alignment, so
+// the replicas running the same schedule fire the job exactly once,
+// the others skip it.
+// The job ID must be the same among the replicas, e.g. the task is
+// created with a stable job ID instead of the AfterFunc and ScheduleFunc.
+// The alignment should be the interval of the jobs, so the replicas
+// with skewed clocks or different begin ms contend for the same fire.
+// The fires of a job within the same alignment are fired once.
+// The fire is skipped if the dlock failed, and the error wraps the
+// ErrTimingWheelJobDLockFailed is reported to the OnJobResult hook.
+func WithTimingWheelsDLock(builder DLockerBuilder, alignment time.Duration) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if builder == nil {
+			panic("timing-wheels' dlock builder must not be nil")
+		}
+		if alignment.Milliseconds() <= 0 {
+			panic("timing-wheels' dlock alignment must be greater than or equals to 1ms")
+		}
+		opt.dlockBuilder = builder
+		opt.dlockAlignment = alignment
+	}
+}
+
+// WithTimingWheelsJobTimeout is the default timeout of each job's
+// attempt, it is overridden by the WithTaskJobTimeout.
+// The job must return once its context is done, see WithTaskJobTimeout.
+func WithTimingWheelsJobTimeout(timeout time.Duration) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if timeout <= 0 {
+			panic("timing-wheels' job timeout must be greater than 0")
+		}
+		opt.jobTimeout = timeout
+	}
+}
+
+// WithTimingWheelsJobRetry is the default retry strategy of the failed
+// jobs, it is overridden by the WithTaskJobRetry.
+func WithTimingWheelsJobRetry(newStrategy RetryStrategyConstructor) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if newStrategy == nil {
+			panic("timing-wheels' job retry strategy must not be nil")
+		}
+		opt.jobRetry = newStrategy
+	}
+}
+
+// WithTimingWheelsOnJobResult registers the hook receiving the result
+// of each job's fire. The failed jobs are logged without the hook.
+func WithTimingWheelsOnJobResult(hook OnJobResult) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if hook == nil {
+			panic("timing-wheels' job result hook must not be nil")
+		}
+		opt.onJobResult = hook
+	}
+}
+
+// WithTimingWheelsHiResTick is the tick (precision) of the hi-res
+// timing wheels, it is ignored by the ms timing wheels.
+func WithTimingWheelsHiResTick(tick time.Duration) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if tick < defaultMinHiResTick {
+			panic(fmt.Sprintf("timing-wheels' hi-res tick must be greater than or equals to %s", defaultMinHiResTick))
+		}
+		opt.hiResTick = tick
+	}
+}
+
+// WithTimingWheelsHiResLevels is the slot size of each level of the hi-res
+// timing wheels, from the lowest level. The tick of a level is the interval
+// of its lower level. The tasks out of the top level's interval are moved
+// down level by level once the top level's last slot expired.
+func WithTimingWheelsHiResLevels(slotSizes ...int64) TimingWheelsOption {
+	return func(opt *xTimingWheelsOption) {
+		if len(slotSizes) <= 0 {
+			panic("timing-wheels' hi-res levels must not be empty")
+		}
+		for _, size := range slotSizes {
+			if size < 2 {
+				panic("timing-wheels' hi-res slot size must be greater than or equals to 2")
+			}
+		}
+		opt.hiResSlotSizes = slotSizes
+	}
+}
+
 func withTimingWheelsDebugStatsInit(interval int64) TimingWheelsOption {
 	return func(opt *xTimingWheelsOption) {
 		_, debugLogDisabled := os.LookupEnv("DISABLE_TEST_DEBUG_LOG")
@@ -7,17 +7,17 @@ import (
 	"context"
 )
 
-func jobStatsWrapper(stats *xTimingWheelsStats, invoke Job) Job {
+func jobStatsWrapper(stats *xTimingWheelsStats, invoke ErrJob) ErrJob {
 	if stats == nil {
 		return invoke
 	}
-	return func(ctx context.Context, metadata JobMetadata) {
+	return func(ctx context.Context, metadata JobMetadata) error {
 		var beginTime = stats.clock.NowInDefaultTZ()
 		defer func() {
 			stats.IncreaseJobExecutedCount()
 			stats.RecordJobExecuteDuration(stats.clock.Since(beginTime).Milliseconds())
 		}()
 		stats.RecordJobLatency(beginTime.UnixMilli() - metadata.GetExpiredMs())
-		invoke(ctx, metadata)
+		return invoke(ctx, metadata)
 	}
 }
@@ -27,6 +27,13 @@ const (
 	ErrTimingWheelTaskTooShortExpiration    = twError("[timing-wheels] task expiration is too short")
 	ErrTimingWheelUnknownScheduler          = twError("[timing-wheels] unknown schedule")
 	ErrTimingWheelTaskCancelled             = twError("[timing-wheels] task cancelled")
+	ErrTimingWheelInvalidCronSpec           = twError("[timing-wheels] invalid cron spec")
+	ErrTimingWheelTaskUnpersistable         = twError("[timing-wheels] task unable to be persisted")
+	ErrTimingWheelInvalidReschedule         = twError("[timing-wheels] invalid reschedule")
+	ErrTimingWheelJobPanic                  = twError("[timing-wheels] job panic")
+	ErrTimingWheelJobDLockFailed            = twError("[timing-wheels] job dlock failed")
+	ErrTimingWheelJobTimeoutOverflow        = twError("[timing-wheels] too many timed out jobs still running")
+	ErrTimingWheelHiResScheduler            = twError("[timing-wheels] hi-res scheduler in ms timing wheels")
 )
 
 type TimingWheelCommonMetadata interface {
@@ -56,6 +63,35 @@ type TimingWheels interface {
 	AddTask(task Task) error
 	// CancelTask cancels a task by jobID.
 	CancelTask(jobID JobID) error
+	// AddTasks adds the tasks to the timing wheels in a batch, none of
+	// them is added if any task is invalid.
+	AddTasks(tasks []Task) error
+	// CancelTasks cancels the tasks by jobIDs in a batch, the not found
+	// jobIDs are ignored.
+	CancelTasks(jobIDs []JobID) error
+	// CancelTasksByPrefix cancels the tasks whose jobID has the prefix in
+	// a batch, returns the number of the matched tasks.
+	CancelTasksByPrefix(prefix string) (int, error)
+	// PauseTask removes the task from the timing wheels but keeps it,
+	// so it is able to be resumed.
+	PauseTask(jobID JobID) error
+	// ResumeTask adds the paused task back to the timing wheels.
+	// The task will be fired immediately if it has been expired.
+	ResumeTask(jobID JobID) error
+	// RescheduleTask moves the task to be fired at the expiredMs.
+	// The scheduler replaces the repeat task's scheduler if it is not nil,
+	// and the task will be fired at the next ms of the scheduler from now
+	// if the expiredMs is not positive.
+	// The rest loop count of the repeat task is preserved if the scheduler
+	// is nil.
+	RescheduleTask(jobID JobID, expiredMs int64, scheduler Scheduler) error
+	// GetTask returns the info of the task by jobID.
+	GetTask(jobID JobID) (TaskInfo, error)
+	// ListTasks returns the tasks accepted by the filter (all if the
+	// filter is nil), ordered by the next fire ms.
+	ListTasks(filter TaskFilter) []TaskInfo
+	// Snapshot returns the per-level slot occupancy and next fire ms.
+	Snapshot() TimingWheelsSnapshot
 	// Shutdown stops the timing wheels
 	Shutdown()
 	// AfterFunc schedules a function to run after the duration delayMs.
@@ -64,6 +100,28 @@ type TimingWheels interface {
 	ScheduleFunc(schedFn func() Scheduler, fn Job) (Task, error)
 }
 
+// HiResTimingWheels is the TimingWheels in ns precision. The methods of
+// the TimingWheels take the expiration in ms, the Ns variants take it in
+// ns (unix nano).
+type HiResTimingWheels interface {
+	TimingWheels
+	// AddTaskNs adds a task to be fired at the expiredNs, the task's
+	// expiredMs is replaced by the truncated expiredNs.
+	AddTaskNs(task Task, expiredNs int64) error
+	// AddTasksNs is the same as the AddTasks, but each task is fired at
+	// its ExpiredNs.
+	AddTasksNs(tasks []HiResTask) error
+	// RescheduleTaskNs is the same as the RescheduleTask, but the task is
+	// moved to be fired at the expiredNs.
+	RescheduleTaskNs(jobID JobID, expiredNs int64, scheduler Scheduler) error
+}
+
+// HiResTask is the task with the expiration in ns.
+type HiResTask struct {
+	Task
+	ExpiredNs int64
+}
+
 // JobID is the unique identifier of a job
 type JobID string
 
@@ -101,6 +159,9 @@ type JobMetadata interface {
 	GetRestLoopCount() int64
 	// GetJobType returns the job type.
 	GetJobType() JobType
+	// GetJobName returns the registered name of the job.
+	// It is empty if the job is anonymous and unable to be persisted.
+	GetJobName() string
 }
 
 // Task is the interface that wraps the Job
@@ -109,6 +170,8 @@ type Task interface {
 	GetJobMetadata() JobMetadata
 	// GetJob returns the job function.
 	GetJob() Job
+	// getErrJob returns the job function returning the error.
+	getErrJob() ErrJob
 	// GetSlot returns the slot of the job.
 	GetSlot() TimingWheelSlot
 	// setSlot sets the slot of the job, it is a private method.
@@ -119,6 +182,21 @@ type Task interface {
 	setSlotMetadata(slotMetadata TimingWheelSlotMetadata)
 	Cancel() bool
 	Cancelled() bool
+	// Paused returns true if the task is paused.
+	Paused() bool
+	// pause, park and resume transit the pause state of the task.
+	pause(removed bool)
+	park() bool
+	resume() (parked bool)
+	// reschedule updates the expiration and scheduler of the task.
+	reschedule(expiredMs int64, scheduler Scheduler, nowMs int64) error
+	// getJobExecOption returns the job execution options of the task.
+	getJobExecOption() jobExecOption
+	// getJobOverlapGuard returns the guard of the task's running fire.
+	getJobOverlapGuard() *jobOverlapGuard
+	// misfire returns false if the fire at nowMs should be dropped
+	// by the misfire policy.
+	misfire(nowMs int64) (fire bool)
 }
 
 // ScheduledTask is the interface that wraps the repeat Job
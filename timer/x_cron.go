@@ -0,0 +1,404 @@
+package timer
+
+// References:
+// https://en.wikipedia.org/wiki/Cron
+// https://www.quartz-scheduler.org/documentation/quartz-2.3.0/tutorials/crontrigger.html
+// https://github.com/robfig/cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+// The next time is searched in 5 years at most, e.g. "0 0 30 2 *"
+// will never be matched.
+const cronMaxSearchYears = 5
+
+type cronBounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSecondBounds = cronBounds{min: 0, max: 59}
+	cronMinuteBounds = cronBounds{min: 0, max: 59}
+	cronHourBounds   = cronBounds{min: 0, max: 23}
+	cronDomBounds    = cronBounds{min: 1, max: 31}
+	cronMonthBounds  = cronBounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// The 7 is Sunday as well.
+	cronDowBounds = cronBounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
+// cronDomSpecial is the special day of month.
+//  1. L-n: the nth day before the last day of month (lastOffset).
+//  2. nW: the nearest weekday to the nth day of month (weekday).
+//  3. LW: the last weekday of month.
+type cronDomSpecial struct {
+	lastOffset  int
+	weekday     int
+	lastWeekday bool
+}
+
+// cronDowSpecial is the special day of week.
+//  1. nL: the last n day of week of month.
+//  2. n#k: the kth n day of week of month.
+type cronDowSpecial struct {
+	dow  int
+	last bool
+	nth  int
+}
+
+type cronScheduler struct {
+	second, minute, hour, dom, month, dow uint64
+	domSpecials                           []cronDomSpecial
+	dowSpecials                           []cronDowSpecial
+	// The day of month or week is "*" or "?".
+	domStar, dowStar bool
+	loc              *time.Location
+	// The original spec and offset are kept for the persistence.
+	spec     string
+	tzOffset hrtime.TimeZoneOffset
+}
+
+var (
+	_ Scheduler = (*cronScheduler)(nil)
+)
+
+// NewCronScheduler parses the standard 5 fields (minute, hour, day of
+// month, month, day of week) or 6 fields (with second first) cron spec,
+// and the predefined descriptors (@yearly, @monthly, @weekly, @daily
+// and @hourly).
+// The fields support the "*", "?", lists "a,b", ranges "a-b", steps
+// "*/n" and "a-b/n", the month and day of week names (JAN, SUN), and
+// the special days "L", "L-n", "nW", "LW" for the day of month and
+// "nL", "n#k" for the day of week.
+// The time is matched in the fixed timezone of the offset.
+func NewCronScheduler(spec string, tzOffset hrtime.TimeZoneOffset) (Scheduler, error) {
+	spec = strings.TrimSpace(spec)
+	if descriptor, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, infra.WrapErrorStackWithMessage(ErrTimingWheelInvalidCronSpec,
+			fmt.Sprintf("expected 5 or 6 fields, found %d in %q", len(fields), spec))
+	}
+	sched := &cronScheduler{
+		loc:      time.FixedZone("", int(tzOffset)),
+		spec:     spec,
+		tzOffset: tzOffset,
+	}
+	var err error
+	if sched.second, _, err = parseCronField(fields[0], cronSecondBounds); err != nil {
+		return nil, err
+	}
+	if sched.minute, _, err = parseCronField(fields[1], cronMinuteBounds); err != nil {
+		return nil, err
+	}
+	if sched.hour, _, err = parseCronField(fields[2], cronHourBounds); err != nil {
+		return nil, err
+	}
+	if err = sched.parseDom(fields[3]); err != nil {
+		return nil, err
+	}
+	if sched.month, _, err = parseCronField(fields[4], cronMonthBounds); err != nil {
+		return nil, err
+	}
+	if err = sched.parseDow(fields[5]); err != nil {
+		return nil, err
+	}
+	return sched, nil
+}
+
+func cronSpecErr(field, reason string) error {
+	return infra.WrapErrorStackWithMessage(ErrTimingWheelInvalidCronSpec, fmt.Sprintf("%q %s", field, reason))
+}
+
+// parseCronField parses the field into the bits and returns whether
+// it is "*" or "?".
+func parseCronField(field string, bounds cronBounds) (uint64, bool, error) {
+	var bits uint64
+	for _, expr := range strings.Split(field, ",") {
+		b, err := parseCronExpr(expr, bounds)
+		if err != nil {
+			return 0, false, err
+		}
+		bits |= b
+	}
+	return bits, field == "*" || field == "?", nil
+}
+
+// parseCronExpr parses the "*", "?", "a", "a-b", "*/n", "a/n" and "a-b/n".
+func parseCronExpr(expr string, bounds cronBounds) (uint64, error) {
+	rangeAndStep := strings.Split(expr, "/")
+	if len(rangeAndStep) > 2 {
+		return 0, cronSpecErr(expr, "too many slashes")
+	}
+	var (
+		low, high = bounds.min, bounds.max
+		step      = 1
+		err       error
+	)
+	if rangeExpr := rangeAndStep[0]; rangeExpr != "*" && rangeExpr != "?" {
+		lowAndHigh := strings.Split(rangeExpr, "-")
+		if len(lowAndHigh) > 2 {
+			return 0, cronSpecErr(expr, "too many hyphens")
+		}
+		if low, err = parseCronValue(lowAndHigh[0], bounds); err != nil {
+			return 0, err
+		}
+		high = low
+		if len(lowAndHigh) == 2 {
+			if high, err = parseCronValue(lowAndHigh[1], bounds); err != nil {
+				return 0, err
+			}
+		} else if len(rangeAndStep) == 2 {
+			// "a/n" means "a-max/n".
+			high = bounds.max
+		}
+	}
+	if len(rangeAndStep) == 2 {
+		if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step <= 0 {
+			return 0, cronSpecErr(expr, "invalid step")
+		}
+	}
+	if low > high {
+		return 0, cronSpecErr(expr, "beginning of range after end of range")
+	}
+	var bits uint64
+	for i := low; i <= high; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, bounds cronBounds) (int, error) {
+	if i, ok := bounds.names[strings.ToLower(value)]; ok {
+		return i, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, cronSpecErr(value, "invalid value")
+	}
+	if i < bounds.min || i > bounds.max {
+		return 0, cronSpecErr(value, fmt.Sprintf("out of range [%d, %d]", bounds.min, bounds.max))
+	}
+	return i, nil
+}
+
+func (sched *cronScheduler) parseDom(field string) error {
+	var exprs []string
+	for _, expr := range strings.Split(field, ",") {
+		upper := strings.ToUpper(expr)
+		switch {
+		case upper == "L":
+			sched.domSpecials = append(sched.domSpecials, cronDomSpecial{lastOffset: 0, weekday: -1})
+		case upper == "LW":
+			sched.domSpecials = append(sched.domSpecials, cronDomSpecial{lastWeekday: true, weekday: -1})
+		case strings.HasPrefix(upper, "L-"):
+			offset, err := strconv.Atoi(upper[2:])
+			if err != nil || offset < 0 || offset >= cronDomBounds.max {
+				return cronSpecErr(expr, "invalid last day offset")
+			}
+			sched.domSpecials = append(sched.domSpecials, cronDomSpecial{lastOffset: offset, weekday: -1})
+		case strings.HasSuffix(upper, "W"):
+			day, err := parseCronValue(upper[:len(upper)-1], cronDomBounds)
+			if err != nil {
+				return err
+			}
+			sched.domSpecials = append(sched.domSpecials, cronDomSpecial{lastOffset: -1, weekday: day})
+		default:
+			exprs = append(exprs, expr)
+		}
+	}
+	if len(exprs) <= 0 {
+		return nil
+	}
+	var err error
+	sched.dom, sched.domStar, err = parseCronField(strings.Join(exprs, ","), cronDomBounds)
+	return err
+}
+
+func (sched *cronScheduler) parseDow(field string) error {
+	var exprs []string
+	for _, expr := range strings.Split(field, ",") {
+		upper := strings.ToUpper(expr)
+		switch {
+		case len(upper) > 1 && strings.HasSuffix(upper, "L"):
+			dow, err := parseCronValue(upper[:len(upper)-1], cronDowBounds)
+			if err != nil {
+				return err
+			}
+			sched.dowSpecials = append(sched.dowSpecials, cronDowSpecial{dow: dow % 7, last: true})
+		case strings.Contains(upper, "#"):
+			dowAndNth := strings.Split(upper, "#")
+			if len(dowAndNth) != 2 {
+				return cronSpecErr(expr, "invalid nth day of week")
+			}
+			dow, err := parseCronValue(dowAndNth[0], cronDowBounds)
+			if err != nil {
+				return err
+			}
+			nth, err := strconv.Atoi(dowAndNth[1])
+			if err != nil || nth < 1 || nth > 5 {
+				return cronSpecErr(expr, "invalid nth day of week")
+			}
+			sched.dowSpecials = append(sched.dowSpecials, cronDowSpecial{dow: dow % 7, nth: nth})
+		default:
+			exprs = append(exprs, expr)
+		}
+	}
+	if len(exprs) <= 0 {
+		return nil
+	}
+	bits, star, err := parseCronField(strings.Join(exprs, ","), cronDowBounds)
+	if err != nil {
+		return err
+	}
+	// Sunday is 0 or 7.
+	if bits&(1<<7) != 0 {
+		bits = bits&^(1<<7) | 1
+	}
+	sched.dow, sched.dowStar = bits, star
+	return nil
+}
+
+func daysInMonth(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+// nearestWeekday returns the nearest weekday to the day in the same month.
+func nearestWeekday(t time.Time, day int) int {
+	lastDay := daysInMonth(t)
+	if day > lastDay {
+		return -1
+	}
+	switch time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, t.Location()).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == lastDay {
+			return day - 2
+		}
+		return day + 1
+	default:
+	}
+	return day
+}
+
+func (sched *cronScheduler) domMatches(t time.Time) bool {
+	if sched.dom&(1<<uint(t.Day())) != 0 {
+		return true
+	}
+	for _, special := range sched.domSpecials {
+		lastDay := daysInMonth(t)
+		switch {
+		case special.lastWeekday:
+			if t.Day() == nearestWeekday(t, lastDay) {
+				return true
+			}
+		case special.weekday > 0:
+			if t.Day() == nearestWeekday(t, special.weekday) {
+				return true
+			}
+		case special.lastOffset >= 0:
+			if t.Day() == lastDay-special.lastOffset {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (sched *cronScheduler) dowMatches(t time.Time) bool {
+	if sched.dow&(1<<uint(t.Weekday())) != 0 {
+		return true
+	}
+	for _, special := range sched.dowSpecials {
+		if int(t.Weekday()) != special.dow {
+			continue
+		}
+		if special.last && t.Day()+7 > daysInMonth(t) {
+			return true
+		}
+		if special.nth > 0 && (t.Day()-1)/7+1 == special.nth {
+			return true
+		}
+	}
+	return false
+}
+
+// dayMatches follows the Vixie cron, the day is matched if either
+// the day of month or the day of week is matched, unless one of them
+// is "*" or "?".
+func (sched *cronScheduler) dayMatches(t time.Time) bool {
+	if sched.domStar || sched.dowStar {
+		return sched.domMatches(t) && sched.dowMatches(t)
+	}
+	return sched.domMatches(t) || sched.dowMatches(t)
+}
+
+// next returns the first matched time after the beginMs in seconds.
+func (sched *cronScheduler) next(beginMs int64) (nextExpiredMs int64) {
+	if beginMs < 0 {
+		return -1
+	}
+	t := time.UnixMilli(beginMs).In(sched.loc).Truncate(time.Second).Add(time.Second)
+	yearLimit := t.Year() + cronMaxSearchYears
+	for t.Year() <= yearLimit {
+		if sched.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, sched.loc)
+			continue
+		}
+		if !sched.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, sched.loc)
+			continue
+		}
+		if sched.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, sched.loc)
+			continue
+		}
+		if sched.minute&(1<<uint(t.Minute())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, sched.loc)
+			continue
+		}
+		if sched.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t.UnixMilli()
+	}
+	return -1
+}
+
+// GetRestLoopCount returns -1, the cron scheduler runs forever unless
+// the task is cancelled or there is no matched time.
+func (sched *cronScheduler) GetRestLoopCount() int64 {
+	return -1
+}
@@ -0,0 +1,183 @@
+package timer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+)
+
+func TestCronScheduler_Next(t *testing.T) {
+	utc8 := time.FixedZone("", int(hrtime.TzUtc8Offset))
+	testcases := []struct {
+		name     string
+		spec     string
+		tzOffset hrtime.TimeZoneOffset
+		begin    time.Time
+		expected []time.Time
+	}{
+		{
+			name:  "every 15 seconds",
+			spec:  "*/15 * * * * *",
+			begin: time.Date(2024, 5, 1, 10, 0, 7, 500, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 5, 1, 10, 0, 15, 0, time.UTC),
+				time.Date(2024, 5, 1, 10, 0, 30, 0, time.UTC),
+				time.Date(2024, 5, 1, 10, 0, 45, 0, time.UTC),
+				time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "5 fields on the hour in working hours",
+			spec:  "0 9-17/4 * * mon-fri",
+			begin: time.Date(2024, 5, 3, 16, 0, 0, 0, time.UTC), // Friday.
+			expected: []time.Time{
+				time.Date(2024, 5, 3, 17, 0, 0, 0, time.UTC),
+				time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC),
+				time.Date(2024, 5, 6, 13, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "exactly at the matched time",
+			spec:  "30 * * * *",
+			begin: time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 5, 1, 11, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "last day of month",
+			spec:  "0 0 L * *",
+			begin: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "2 days before last day of month",
+			spec:  "0 0 L-2 * ?",
+			begin: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2023, 2, 26, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 3, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "nearest weekday",
+			spec:  "0 0 1W,15W * ?",
+			begin: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), // Saturday.
+			expected: []time.Time{
+				time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),  // Monday, not in the previous month.
+				time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC), // 15th is Saturday.
+				time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 7, 15, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "last weekday of month",
+			spec:  "0 0 LW * ?",
+			begin: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC), // 31st is Sunday.
+				time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "last friday and second monday of month",
+			spec:  "0 0 ? * 5L,MON#2",
+			begin: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 6, 28, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "day of month or day of week",
+			spec:  "0 0 13 * 5",
+			begin: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 9, 6, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 9, 13, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 9, 20, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "weekly descriptor",
+			spec:  "@weekly",
+			begin: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "sunday as 7 in UTC+8",
+			spec:     "0 2 * * 7",
+			tzOffset: hrtime.TzUtc8Offset,
+			begin:    time.Date(2024, 5, 4, 19, 0, 0, 0, time.UTC), // Sunday 03:00 UTC+8.
+			expected: []time.Time{
+				time.Date(2024, 5, 12, 2, 0, 0, 0, utc8),
+				time.Date(2024, 5, 19, 2, 0, 0, 0, utc8),
+			},
+		},
+		{
+			name:  "leap day",
+			spec:  "@yearly",
+			begin: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			sched, err := NewCronScheduler(tc.spec, tc.tzOffset)
+			require.NoError(t, err)
+			require.Equal(t, int64(-1), sched.GetRestLoopCount())
+			beginMs := tc.begin.UnixMilli()
+			for _, expected := range tc.expected {
+				nextMs := sched.next(beginMs)
+				require.Equal(t, expected.UnixMilli(), nextMs, "expected %v, actual %v",
+					expected, time.UnixMilli(nextMs).In(expected.Location()))
+				beginMs = nextMs
+			}
+		})
+	}
+
+	sched, err := NewCronScheduler("0 0 30 2 *", hrtime.TzUtc0Offset)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), sched.next(time.Now().UnixMilli()))
+}
+
+func TestCronScheduler_InvalidSpec(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"1/2/3 * * * *",
+		"1-2-3 * * * *",
+		"* * 32W * *",
+		"* * L-31 * *",
+		"* * * * 1#6",
+		"* * * * 8L",
+		"* * * foo *",
+	}
+	for _, spec := range specs {
+		_, err := NewCronScheduler(spec, hrtime.TzUtc0Offset)
+		require.True(t, errors.Is(err, ErrTimingWheelInvalidCronSpec), spec)
+	}
+}
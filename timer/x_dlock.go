@@ -0,0 +1,92 @@
+package timer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// DLocker is the dlock guarding a job's fire. The dlock.DLocker is
+// able to be used as is, without the dlock imported by the timing wheels.
+type DLocker interface {
+	TryLock() (bool, error)
+}
+
+// DLockerBuilder builds the dlock by the key of a job's fire.
+// The same key is built by all replicas for the same fire.
+// The dlock should not be built with the watchdog, because it will
+// never be unlocked and has to be expired by the TTL, so the other
+// replicas with a little late clock are not able to fire it again.
+// The TTL should be longer than the clock skew among the replicas,
+// and shorter than the interval of the job.
+type DLockerBuilder func(ctx context.Context, key string) (DLocker, error)
+
+// jobDLockOption guards the fires of the jobs among the replicas.
+type jobDLockOption struct {
+	builder DLockerBuilder
+	alignMs int64
+	// onResult reports the fire unable to be guarded.
+	onResult OnJobResult
+}
+
+// dlockJobKey is "{jobID}:{aligned fire ms}". The fire ms is truncated
+// to the alignment, so the replicas with skewed clocks or different
+// begin ms build the same key for the same fire.
+func dlockJobKey(md JobMetadata, alignMs int64) string {
+	firedMs := md.GetExpiredMs()
+	if alignMs > 1 {
+		firedMs -= firedMs % alignMs
+	}
+	return string(md.GetJobID()) + ":" + strconv.FormatInt(firedMs, 10)
+}
+
+// dlockJobWrapper runs the job only if the dlock of the fire is acquired,
+// the others skip the fire. The fire is skipped too if the dlock failed
+// to be built or acquired, and the error is reported to the hook.
+func dlockJobWrapper(opt jobDLockOption, invoke Job) Job {
+	if opt.builder == nil {
+		return invoke
+	}
+	return func(ctx context.Context, metadata JobMetadata) {
+		key := dlockJobKey(metadata, opt.alignMs)
+		locker, err := opt.builder(ctx, key)
+		if err != nil {
+			opt.reportFailed(metadata, key, err)
+			return
+		}
+		ok, err := locker.TryLock()
+		if err != nil {
+			opt.reportFailed(metadata, key, err)
+			return
+		}
+		if !ok {
+			// Fired by the other replica.
+			return
+		}
+		invoke(ctx, metadata)
+	}
+}
+
+func (opt jobDLockOption) reportFailed(metadata JobMetadata, key string, err error) {
+	err = fmt.Errorf("%w: key %s: %w", ErrTimingWheelJobDLockFailed, key, err)
+	if opt.onResult == nil {
+		slog.Warn("[x-timing-wheels] acquire job dlock failed", "job", metadata.GetJobID(), "error", err)
+		return
+	}
+	opt.onResult(JobResult{
+		JobID:     metadata.GetJobID(),
+		JobName:   metadata.GetJobName(),
+		ExpiredMs: metadata.GetExpiredMs(),
+		Err:       err,
+	})
+}
+
+func newJobDLockOption(builder DLockerBuilder, alignment time.Duration, onResult OnJobResult) jobDLockOption {
+	return jobDLockOption{
+		builder:  builder,
+		alignMs:  alignment.Milliseconds(),
+		onResult: onResult,
+	}
+}
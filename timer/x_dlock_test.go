@@ -0,0 +1,134 @@
+package timer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/benz9527/xboot/dlock"
+)
+
+func TestXTimingWheelsV2_DLock_SingleFire(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Simulates the replicas by the timing wheels with the shared registry.
+	registry := dlock.NewMemDLockRegistry(nil)
+	builder := func(ctx context.Context, key string) (DLocker, error) {
+		return dlock.MemDLock(ctx, registry,
+			dlock.WithMemDLockKeys(key),
+			dlock.WithMemDLockTTL(time.Second),
+		)
+	}
+	var onceCount, repeatCount atomic.Int64
+	replicas := make([]TimingWheels, 3)
+	for i := range replicas {
+		replicas[i] = NewXTimingWheelsV2(ctx, WithTimingWheelsDLock(builder, 100*time.Millisecond))
+	}
+	defer func() {
+		for _, tw := range replicas {
+			tw.Shutdown()
+		}
+	}()
+
+	nowMs := time.Now().UnixMilli()
+	for _, tw := range replicas {
+		require.NoError(t, tw.AddTask(NewOnceTask(ctx, "once", nowMs+200, func(ctx context.Context, md JobMetadata) {
+			onceCount.Add(1)
+		})))
+		require.NoError(t, tw.AddTask(NewRepeatTask(ctx, "repeat", nowMs, NewFiniteScheduler(
+			100*time.Millisecond,
+			100*time.Millisecond,
+			100*time.Millisecond,
+		), func(ctx context.Context, md JobMetadata) {
+			repeatCount.Add(1)
+		})))
+	}
+
+	require.Eventually(t, func() bool {
+		return onceCount.Load() == 1 && repeatCount.Load() == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, int64(1), onceCount.Load())
+	require.Equal(t, int64(3), repeatCount.Load())
+}
+
+func TestXTimingWheelsV2_DLock_SkewedClocks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	registry := dlock.NewMemDLockRegistry(nil)
+	builder := func(ctx context.Context, key string) (DLocker, error) {
+		return dlock.MemDLock(ctx, registry,
+			dlock.WithMemDLockKeys(key),
+			dlock.WithMemDLockTTL(time.Second),
+		)
+	}
+	var repeatCount atomic.Int64
+	replicas := make([]TimingWheels, 2)
+	for i := range replicas {
+		replicas[i] = NewXTimingWheelsV2(ctx, WithTimingWheelsDLock(builder, 100*time.Millisecond))
+	}
+	defer func() {
+		for _, tw := range replicas {
+			tw.Shutdown()
+		}
+	}()
+
+	// The replicas begin the same schedule with the skewed ms, and
+	// their fires are within the same alignment.
+	beginMs := time.Now().UnixMilli()
+	beginMs = beginMs - beginMs%100 + 100
+	for i, tw := range replicas {
+		require.NoError(t, tw.AddTask(NewRepeatTask(ctx, "repeat", beginMs+int64(i)*30, NewFiniteScheduler(
+			100*time.Millisecond,
+			100*time.Millisecond,
+			100*time.Millisecond,
+		), func(ctx context.Context, md JobMetadata) {
+			repeatCount.Add(1)
+		})))
+	}
+
+	require.Eventually(t, func() bool {
+		return repeatCount.Load() == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, int64(3), repeatCount.Load())
+}
+
+func TestXTimingWheelsV2_DLock_ReportFailed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	errBuild := errors.New("dlock unavailable")
+	builder := func(ctx context.Context, key string) (DLocker, error) {
+		return nil, errBuild
+	}
+	var fired atomic.Bool
+	results := make(chan JobResult, 1)
+	tw := NewXTimingWheelsV2(ctx,
+		WithTimingWheelsDLock(builder, 100*time.Millisecond),
+		WithTimingWheelsOnJobResult(func(result JobResult) {
+			results <- result
+		}),
+	)
+	defer tw.Shutdown()
+
+	require.NoError(t, tw.AddTask(NewOnceTask(ctx, "once", time.Now().UnixMilli()+100, func(ctx context.Context, md JobMetadata) {
+		fired.Store(true)
+	})))
+	select {
+	case result := <-results:
+		require.Equal(t, JobID("once"), result.JobID)
+		require.Equal(t, 0, result.Attempts)
+		require.ErrorIs(t, result.Err, ErrTimingWheelJobDLockFailed)
+		require.ErrorIs(t, result.Err, errBuild)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the dlock error is not reported")
+	}
+	require.False(t, fired.Load())
+}
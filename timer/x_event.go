@@ -12,6 +12,14 @@ const (
 	addTask
 	reAddTask
 	cancelTask
+	pauseTask
+	resumeTask
+	rescheduleTask
+	snapshotTasks
+	addTasks
+	cancelTasks
+	// doneTask removes the task whose last fire has been submitted.
+	doneTask
 )
 
 func (op timingWheelOperation) String() string {
@@ -22,6 +30,20 @@ func (op timingWheelOperation) String() string {
 		return "re-add"
 	case cancelTask:
 		return "cancel"
+	case pauseTask:
+		return "pause"
+	case resumeTask:
+		return "resume"
+	case rescheduleTask:
+		return "reschedule"
+	case snapshotTasks:
+		return "snapshot"
+	case addTasks:
+		return "batch-add"
+	case cancelTasks:
+		return "batch-cancel"
+	case doneTask:
+		return "done"
 	default:
 		return "unknown"
 	}
@@ -29,10 +51,16 @@ func (op timingWheelOperation) String() string {
 
 type timingWheelEvent struct {
 	operation timingWheelOperation
-	obj       *atomic.Value // Task or JobID
+	obj       *atomic.Value // Task, JobID, []Task, []JobID, *rescheduleTaskArgs or chan TimingWheelsSnapshot
 	hasSetup  bool
 }
 
+type rescheduleTaskArgs struct {
+	jobID     JobID
+	expiredMs int64
+	scheduler Scheduler
+}
+
 func newTimingWheelEvent(operation timingWheelOperation) *timingWheelEvent {
 	event := &timingWheelEvent{
 		operation: operation,
@@ -57,8 +85,33 @@ func (e *timingWheelEvent) GetTask() (Task, bool) {
 	return nil, false
 }
 
+func (e *timingWheelEvent) GetTasks() ([]Task, bool) {
+	if e.operation != addTasks {
+		return nil, false
+	}
+
+	obj := e.obj.Load()
+	if tasks, ok := obj.([]Task); ok {
+		return tasks, true
+	}
+	return nil, false
+}
+
+func (e *timingWheelEvent) GetCancelTasksJobIDs() ([]JobID, bool) {
+	if e.operation != cancelTasks {
+		return nil, false
+	}
+
+	obj := e.obj.Load()
+	if jobIDs, ok := obj.([]JobID); ok {
+		return jobIDs, true
+	}
+	return nil, false
+}
+
+// GetCancelTaskJobID returns the job ID of the cancel or done operation.
 func (e *timingWheelEvent) GetCancelTaskJobID() (JobID, bool) {
-	if e.operation != cancelTask {
+	if e.operation != cancelTask && e.operation != doneTask {
 		return "", false
 	}
 
@@ -69,6 +122,85 @@ func (e *timingWheelEvent) GetCancelTaskJobID() (JobID, bool) {
 	return "", false
 }
 
+// GetTaskJobID returns the job ID of the pause or resume operation.
+func (e *timingWheelEvent) GetTaskJobID() (JobID, bool) {
+	if e.operation != pauseTask && e.operation != resumeTask {
+		return "", false
+	}
+
+	obj := e.obj.Load()
+	if jobID, ok := obj.(JobID); ok {
+		return jobID, true
+	}
+	return "", false
+}
+
+func (e *timingWheelEvent) GetRescheduleTaskArgs() (*rescheduleTaskArgs, bool) {
+	if e.operation != rescheduleTask {
+		return nil, false
+	}
+
+	obj := e.obj.Load()
+	if args, ok := obj.(*rescheduleTaskArgs); ok {
+		return args, true
+	}
+	return nil, false
+}
+
+func (e *timingWheelEvent) GetSnapshotC() (chan TimingWheelsSnapshot, bool) {
+	if e.operation != snapshotTasks {
+		return nil, false
+	}
+
+	obj := e.obj.Load()
+	if snapshotC, ok := obj.(chan TimingWheelsSnapshot); ok {
+		return snapshotC, true
+	}
+	return nil, false
+}
+
+// SnapshotC requests the snapshot taken in the event loop.
+// The snapshotC should be buffered.
+func (e *timingWheelEvent) SnapshotC(snapshotC chan TimingWheelsSnapshot) {
+	if e.hasSetup {
+		return
+	}
+	e.operation = snapshotTasks
+	e.obj.Store(snapshotC)
+	e.hasSetup = true
+}
+
+func (e *timingWheelEvent) PauseTaskJobID(jobID JobID) {
+	if e.hasSetup {
+		return
+	}
+	e.operation = pauseTask
+	e.obj.Store(jobID)
+	e.hasSetup = true
+}
+
+func (e *timingWheelEvent) ResumeTaskJobID(jobID JobID) {
+	if e.hasSetup {
+		return
+	}
+	e.operation = resumeTask
+	e.obj.Store(jobID)
+	e.hasSetup = true
+}
+
+func (e *timingWheelEvent) RescheduleTask(jobID JobID, expiredMs int64, scheduler Scheduler) {
+	if e.hasSetup {
+		return
+	}
+	e.operation = rescheduleTask
+	e.obj.Store(&rescheduleTaskArgs{
+		jobID:     jobID,
+		expiredMs: expiredMs,
+		scheduler: scheduler,
+	})
+	e.hasSetup = true
+}
+
 func (e *timingWheelEvent) CancelTaskJobID(jobID JobID) {
 	if e.hasSetup {
 		return
@@ -78,6 +210,17 @@ func (e *timingWheelEvent) CancelTaskJobID(jobID JobID) {
 	e.hasSetup = true
 }
 
+// DoneTaskJobID removes the task as the cancel operation, but the task
+// record is left to be deleted by its last fire.
+func (e *timingWheelEvent) DoneTaskJobID(jobID JobID) {
+	if e.hasSetup {
+		return
+	}
+	e.operation = doneTask
+	e.obj.Store(jobID)
+	e.hasSetup = true
+}
+
 func (e *timingWheelEvent) AddTask(task Task) {
 	if e.hasSetup {
 		return
@@ -87,6 +230,26 @@ func (e *timingWheelEvent) AddTask(task Task) {
 	e.hasSetup = true
 }
 
+// AddTasks adds the tasks in a single event.
+func (e *timingWheelEvent) AddTasks(tasks []Task) {
+	if e.hasSetup {
+		return
+	}
+	e.operation = addTasks
+	e.obj.Store(tasks)
+	e.hasSetup = true
+}
+
+// CancelTasksJobIDs cancels the tasks in a single event.
+func (e *timingWheelEvent) CancelTasksJobIDs(jobIDs []JobID) {
+	if e.hasSetup {
+		return
+	}
+	e.operation = cancelTasks
+	e.obj.Store(jobIDs)
+	e.hasSetup = true
+}
+
 func (e *timingWheelEvent) ReAddTask(task Task) {
 	if e.hasSetup {
 		return
@@ -1,6 +1,7 @@
 package timer
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,4 +30,29 @@ func TestNewTimingWheelEventsPool(t *testing.T) {
 	jobID, ok = event.GetCancelTaskJobID()
 	assert.True(t, ok)
 	assert.Equal(t, JobID("2"), jobID)
+	pool.Put(event)
+
+	event = pool.Get()
+	event.DoneTaskJobID("2")
+	jobID, ok = event.GetCancelTaskJobID()
+	assert.True(t, ok)
+	assert.Equal(t, JobID("2"), jobID)
+	assert.Equal(t, doneTask, event.GetOperation())
+	pool.Put(event)
+
+	event = pool.Get()
+	event.CancelTasksJobIDs([]JobID{"3", "4"})
+	_, ok = event.GetCancelTaskJobID()
+	assert.False(t, ok)
+	jobIDs, ok := event.GetCancelTasksJobIDs()
+	assert.True(t, ok)
+	assert.Equal(t, []JobID{"3", "4"}, jobIDs)
+	pool.Put(event)
+
+	event = pool.Get()
+	event.AddTasks([]Task{NewOnceTask(context.Background(), "5", 0, func(ctx context.Context, md JobMetadata) {})})
+	tasks, ok := event.GetTasks()
+	assert.True(t, ok)
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, JobID("5"), tasks[0].GetJobID())
 }
@@ -0,0 +1,451 @@
+package timer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+// ErrJob is the job returning the execution error, so the error is
+// able to be retried by the retry policy and reported to the OnJobResult
+// hook. The task of the ErrJob is created by the NewOnceErrTask and
+// NewRepeatErrTask.
+type ErrJob func(ctx context.Context, metadata JobMetadata) error
+
+// errJobAdapter adapts the Job into the ErrJob without any error.
+func errJobAdapter(job Job) ErrJob {
+	if job == nil {
+		return nil
+	}
+	return func(ctx context.Context, metadata JobMetadata) error {
+		job(ctx, metadata)
+		return nil
+	}
+}
+
+// jobAdapter adapts the ErrJob into the Job, the error is logged.
+func jobAdapter(job ErrJob) Job {
+	if job == nil {
+		return nil
+	}
+	return func(ctx context.Context, metadata JobMetadata) {
+		if err := job(ctx, metadata); err != nil {
+			slog.Warn("[x-timing-wheels] job failed", "job", metadata.GetJobID(), "error", err)
+		}
+	}
+}
+
+// RetryStrategy returns the backoff of the next retry. The backoff
+// less than 1ms means no more retry.
+// The dlock.RetryStrategy is able to be used as is, without the dlock
+// imported by the timing wheels.
+type RetryStrategy interface {
+	Next() time.Duration
+}
+
+// RetryStrategyConstructor creates the strategy with fresh state for each
+// fire, so the stateful strategy is reusable across fires. The
+// dlock.RetryStrategyFactory is wrapped by a closure calling its
+// NewAttempt. For example:
+//
+//	func() RetryStrategy { return dlock.LimitedRetry(10*time.Millisecond, 3) }
+type RetryStrategyConstructor func() RetryStrategy
+
+// JobResult is the result of a job's fire, including all the retries.
+type JobResult struct {
+	JobID     JobID
+	JobName   string
+	ExpiredMs int64
+	// Attempts is the number of the executions. It is 0 if the fire is
+	// skipped by the failed dlock.
+	Attempts int
+	// Duration is the total duration of all the attempts and backoffs.
+	Duration time.Duration
+	// Err is the error of the last attempt, it is the context error
+	// if the attempt timed out, and wraps the ErrTimingWheelJobPanic
+	// if the attempt panicked. It is the ErrTimingWheelJobTimeoutOverflow
+	// if the attempt is not run, because of too many timed out attempts
+	// still running.
+	Err error
+	// Panic is the recovered value of the last attempt.
+	Panic any
+	// PanicStack is the stack trace where the last attempt panicked.
+	PanicStack []byte
+}
+
+// OnJobResult is the hook receiving the result of each job's fire.
+// It is called in the worker pool and should not block.
+type OnJobResult func(result JobResult)
+
+// OverlapPolicy decides how to fire the task while its previous fire
+// is still running.
+type OverlapPolicy uint8
+
+const (
+	// OverlapAllowConcurrent fires the task regardless of the previous
+	// fire, so the long jobs may pile up in the worker pool.
+	OverlapAllowConcurrent OverlapPolicy = iota
+	// OverlapSkipIfRunning drops the fire if the previous one is
+	// still running.
+	OverlapSkipIfRunning
+	// OverlapQueueOne queues the fire until the previous one is done.
+	// At most one fire is queued, the latest one replaces the queued.
+	OverlapQueueOne
+)
+
+func (p OverlapPolicy) String() string {
+	switch p {
+	case OverlapAllowConcurrent:
+		return "allow-concurrent"
+	case OverlapSkipIfRunning:
+		return "skip-if-running"
+	case OverlapQueueOne:
+		return "queue-one"
+	default:
+	}
+	return "unknown"
+}
+
+// MisfirePolicy decides how to fire the repeat task whose fire is
+// later than the misfire threshold, e.g. the process stalled.
+type MisfirePolicy uint8
+
+const (
+	// MisfireFireAllMissed fires all the missed expirations one by one.
+	MisfireFireAllMissed MisfirePolicy = iota
+	// MisfireFireOnceNow fires the misfired expiration once, the rest
+	// missed expirations are coalesced into it. The task continues
+	// from now.
+	MisfireFireOnceNow
+	// MisfireSkipToNext drops the misfired expiration, and the task
+	// continues from now.
+	MisfireSkipToNext
+)
+
+func (p MisfirePolicy) String() string {
+	switch p {
+	case MisfireFireAllMissed:
+		return "fire-all-missed"
+	case MisfireFireOnceNow:
+		return "fire-once-now"
+	case MisfireSkipToNext:
+		return "skip-to-next"
+	default:
+	}
+	return "unknown"
+}
+
+// TaskOption overrides the job execution options of the timing wheels
+// for the task.
+type TaskOption func(opt *jobExecOption)
+
+// WithTaskJobTimeout cancels the context of each attempt after the
+// timeout, and the attempt fails with the context error.
+// The job must return once its context is done. The timed out job
+// ignoring the context keeps running without the worker, and the new
+// attempts fail with the ErrTimingWheelJobTimeoutOverflow once the timed
+// out jobs still running reach the worker pool size.
+func WithTaskJobTimeout(timeout time.Duration) TaskOption {
+	return func(opt *jobExecOption) {
+		if timeout <= 0 {
+			panic("task's job timeout must be greater than 0")
+		}
+		opt.timeout = timeout
+	}
+}
+
+// WithTaskJobRetry retries the failed (error, panic or timeout)
+// attempts by the strategy created for each fire, until the backoff
+// is less than 1ms.
+func WithTaskJobRetry(newStrategy RetryStrategyConstructor) TaskOption {
+	return func(opt *jobExecOption) {
+		if newStrategy == nil {
+			panic("task's job retry strategy must not be nil")
+		}
+		opt.retry = newStrategy
+	}
+}
+
+// WithTaskOverlapPolicy decides how to fire the task while its
+// previous fire is still running.
+func WithTaskOverlapPolicy(policy OverlapPolicy) TaskOption {
+	return func(opt *jobExecOption) {
+		opt.overlap = policy
+	}
+}
+
+// WithTaskMisfirePolicy decides how to fire the repeat task whose fire
+// is later than the threshold. It is ignored by the once task.
+func WithTaskMisfirePolicy(policy MisfirePolicy, threshold time.Duration) TaskOption {
+	return func(opt *jobExecOption) {
+		if threshold.Milliseconds() <= 0 {
+			panic("task's misfire threshold must be greater than or equals to 1ms")
+		}
+		opt.misfire = policy
+		opt.misfireThresholdMs = threshold.Milliseconds()
+	}
+}
+
+type jobExecOption struct {
+	clock              hrtime.Clock // Drives the timeout, backoff and duration
+	timeout            time.Duration
+	timedOut           *jobTimedOutAttempts
+	retry              RetryStrategyConstructor
+	onResult           OnJobResult
+	overlap            OverlapPolicy
+	misfire            MisfirePolicy
+	misfireThresholdMs int64
+}
+
+// override returns the options overridden by the task's options.
+func (opt jobExecOption) override(taskOpt jobExecOption) jobExecOption {
+	if taskOpt.timeout > 0 {
+		opt.timeout = taskOpt.timeout
+	}
+	if taskOpt.retry != nil {
+		opt.retry = taskOpt.retry
+	}
+	return opt
+}
+
+func (opt jobExecOption) getClock() hrtime.Clock {
+	if opt.clock == nil {
+		return hrtime.SdkClock
+	}
+	return opt.clock
+}
+
+func newJobExecOption(opts ...TaskOption) jobExecOption {
+	opt := jobExecOption{}
+	for _, o := range opts {
+		if o != nil {
+			o(&opt)
+		}
+	}
+	return opt
+}
+
+// jobOverlapGuard tracks the running fire of the task.
+type jobOverlapGuard struct {
+	lock    sync.Mutex
+	running bool
+	queued  JobMetadata
+}
+
+// acquire returns true if the fire is able to run now, otherwise the
+// fire is dropped or queued by the policy.
+func (g *jobOverlapGuard) acquire(policy OverlapPolicy, metadata JobMetadata) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.running {
+		g.running = true
+		return true
+	}
+	if policy == OverlapQueueOne {
+		g.queued = metadata
+	}
+	return false
+}
+
+// release returns the queued fire, which has to be run by the caller.
+func (g *jobOverlapGuard) release() JobMetadata {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	metadata := g.queued
+	g.queued = nil
+	g.running = metadata != nil
+	return metadata
+}
+
+func (g *jobOverlapGuard) reset() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.queued = nil
+	g.running = false
+}
+
+// submitJob submits the fire of the task into the pool by the task's
+// overlap policy. The dropped and queued fires do not occupy the worker.
+// It returns false if the fire is dropped or failed to be submitted.
+func submitJob(pool *ants.Pool, ctx context.Context, t Task, invoke Job) bool {
+	metadata := t.GetJobMetadata()
+	policy := t.getJobExecOption().overlap
+	if policy == OverlapAllowConcurrent {
+		return pool.Submit(func() {
+			invoke(ctx, metadata)
+		}) == nil
+	}
+	guard := t.getJobOverlapGuard()
+	if !guard.acquire(policy, metadata) {
+		return policy == OverlapQueueOne
+	}
+	if err := pool.Submit(func() {
+		for md := metadata; md != nil; md = guard.release() {
+			invoke(ctx, md)
+		}
+	}); err != nil {
+		guard.reset()
+		return false
+	}
+	return true
+}
+
+// jobTimedOutAttempts counts the timed out attempts still running, they
+// are left behind by the workers until the jobs return.
+type jobTimedOutAttempts struct {
+	running atomic.Int64
+	limit   int64
+}
+
+func newJobTimedOutAttempts(limit int) *jobTimedOutAttempts {
+	return &jobTimedOutAttempts{limit: int64(limit)}
+}
+
+const (
+	attemptRunning int32 = iota
+	attemptDone
+	attemptTimedOut
+)
+
+type jobAttemptResult struct {
+	err   error
+	panic any
+	stack []byte
+}
+
+// jobAttempt runs the job once and recovers the panic.
+// The attempt is not run if the timed out attempts still running reach
+// the limit, so the jobs ignoring the context are not piled up.
+func jobAttempt(ctx context.Context, opt jobExecOption, metadata JobMetadata, invoke ErrJob) jobAttemptResult {
+	timeout, timedOut := opt.timeout, opt.timedOut
+	if timeout > 0 && timedOut != nil && timedOut.running.Load() >= timedOut.limit {
+		return jobAttemptResult{err: infra.WrapErrorStack(ErrTimingWheelJobTimeoutOverflow)}
+	}
+	cancel := func() {}
+	if timeout > 0 {
+		ctx, cancel = withClockTimeout(ctx, opt.getClock(), timeout)
+	}
+	defer cancel()
+	var state atomic.Int32
+	resC := make(chan jobAttemptResult, 1)
+	run := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				resC <- jobAttemptResult{
+					err:   fmt.Errorf("%w: %v", ErrTimingWheelJobPanic, r),
+					panic: r,
+					stack: debug.Stack(),
+				}
+			}
+			if !state.CompareAndSwap(attemptRunning, attemptDone) && timedOut != nil {
+				timedOut.running.Add(-1)
+			}
+		}()
+		err := invoke(ctx, metadata)
+		resC <- jobAttemptResult{err: err}
+	}
+	if timeout <= 0 {
+		run()
+		return <-resC
+	}
+	// The timed out job is left behind and counted until it returns,
+	// the worker is released.
+	go run()
+	select {
+	case res := <-resC:
+		return res
+	case <-ctx.Done():
+		if timedOut != nil {
+			timedOut.running.Add(1)
+			if !state.CompareAndSwap(attemptRunning, attemptTimedOut) {
+				timedOut.running.Add(-1)
+			}
+		}
+		return jobAttemptResult{err: context.Cause(ctx)}
+	}
+}
+
+// withClockTimeout is the context.WithTimeout by the clock's timer, so
+// the hrtime.TimerClock (e.g. the hrtime.FakeClock) times out the
+// attempts by its own time. The context.Cause of the timed out context
+// is the context.DeadlineExceeded.
+func withClockTimeout(ctx context.Context, clock hrtime.Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(hrtime.TimerClock); !ok {
+		return context.WithTimeout(ctx, timeout)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := hrtime.AfterFunc(clock, timeout, func() {
+		cancel(context.DeadlineExceeded)
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// jobExecWrapper runs the job with the timeout and retry, and reports
+// the result to the hook.
+func jobExecWrapper(opt jobExecOption, invoke ErrJob) Job {
+	if opt.timeout <= 0 && opt.retry == nil && opt.onResult == nil {
+		return jobAdapter(invoke)
+	}
+	return func(ctx context.Context, metadata JobMetadata) {
+		var (
+			clock     = opt.getClock()
+			beginTime = clock.NowInDefaultTZ()
+			retry     RetryStrategy
+			res       jobAttemptResult
+			attempts  int
+		)
+		if opt.retry != nil {
+			retry = opt.retry()
+		}
+	loop:
+		for {
+			attempts++
+			if res = jobAttempt(ctx, opt, metadata, invoke); res.err == nil || retry == nil {
+				break
+			}
+			backoff := retry.Next()
+			if backoff < time.Millisecond {
+				break
+			}
+			backoffC := make(chan struct{})
+			timer := hrtime.AfterFunc(clock, backoff, func() {
+				close(backoffC)
+			})
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				break loop
+			case <-backoffC:
+			}
+		}
+		result := JobResult{
+			JobID:      metadata.GetJobID(),
+			JobName:    metadata.GetJobName(),
+			ExpiredMs:  metadata.GetExpiredMs(),
+			Attempts:   attempts,
+			Duration:   clock.Since(beginTime),
+			Err:        res.err,
+			Panic:      res.panic,
+			PanicStack: res.stack,
+		}
+		if opt.onResult != nil {
+			opt.onResult(result)
+			return
+		}
+		if result.Err != nil {
+			slog.Warn("[x-timing-wheels] job failed", "job", result.JobID, "attempts", result.Attempts, "error", result.Err)
+		}
+	}
+}
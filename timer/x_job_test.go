@@ -0,0 +1,348 @@
+package timer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benz9527/xboot/dlock"
+	"github.com/benz9527/xboot/lib/hrtime"
+)
+
+func TestJobExecWrapper(t *testing.T) {
+	md := &jobMetadata{jobID: "1", jobName: "job", expirationMs: 1000}
+	errJob := errors.New("job failed")
+	testcases := []struct {
+		name     string
+		opt      jobExecOption
+		job      func(attempt int64) ErrJob
+		attempts int
+		check    func(t *testing.T, result JobResult)
+	}{
+		{
+			name: "success",
+			job: func(int64) ErrJob {
+				return func(ctx context.Context, md JobMetadata) error { return nil }
+			},
+			attempts: 1,
+			check: func(t *testing.T, result JobResult) {
+				require.NoError(t, result.Err)
+				require.Nil(t, result.Panic)
+			},
+		},
+		{
+			name: "retry until success",
+			opt:  jobExecOption{retry: func() RetryStrategy { return dlock.LimitedRetry(time.Millisecond, 5) }},
+			job: func(attempt int64) ErrJob {
+				return func(ctx context.Context, md JobMetadata) error {
+					if attempt < 3 {
+						return errJob
+					}
+					return nil
+				}
+			},
+			attempts: 3,
+			check: func(t *testing.T, result JobResult) {
+				require.NoError(t, result.Err)
+			},
+		},
+		{
+			name: "retry exhausted",
+			opt:  jobExecOption{retry: func() RetryStrategy { return dlock.LimitedRetry(time.Millisecond, 2) }},
+			job: func(int64) ErrJob {
+				return func(ctx context.Context, md JobMetadata) error { return errJob }
+			},
+			attempts: 3,
+			check: func(t *testing.T, result JobResult) {
+				require.ErrorIs(t, result.Err, errJob)
+			},
+		},
+		{
+			name: "panic",
+			job: func(int64) ErrJob {
+				return func(ctx context.Context, md JobMetadata) error { panic("oops") }
+			},
+			attempts: 1,
+			check: func(t *testing.T, result JobResult) {
+				require.ErrorIs(t, result.Err, ErrTimingWheelJobPanic)
+				require.Equal(t, "oops", result.Panic)
+				require.NotEmpty(t, result.PanicStack)
+			},
+		},
+		{
+			name: "hung job timed out",
+			opt:  jobExecOption{timeout: 20 * time.Millisecond},
+			job: func(int64) ErrJob {
+				return func(ctx context.Context, md JobMetadata) error {
+					time.Sleep(time.Second)
+					return nil
+				}
+			},
+			attempts: 1,
+			check: func(t *testing.T, result JobResult) {
+				require.ErrorIs(t, result.Err, context.DeadlineExceeded)
+				require.Less(t, result.Duration, 500*time.Millisecond)
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				attempt atomic.Int64
+				results []JobResult
+			)
+			opt := tc.opt
+			opt.onResult = func(result JobResult) {
+				results = append(results, result)
+			}
+			jobExecWrapper(opt, func(ctx context.Context, md JobMetadata) error {
+				return tc.job(attempt.Add(1))(ctx, md)
+			})(context.Background(), md)
+			require.Len(t, results, 1)
+			require.Equal(t, JobID("1"), results[0].JobID)
+			require.Equal(t, "job", results[0].JobName)
+			require.Equal(t, int64(1000), results[0].ExpiredMs)
+			require.Equal(t, tc.attempts, results[0].Attempts)
+			tc.check(t, results[0])
+		})
+	}
+}
+
+func TestJobAttempt_TimedOutLimit(t *testing.T) {
+	md := &jobMetadata{jobID: "1", jobName: "job", expirationMs: 1000}
+	timedOut := newJobTimedOutAttempts(2)
+	var invoked atomic.Int64
+	releaseC := make(chan struct{})
+	// The job ignores the context.
+	hung := func(ctx context.Context, md JobMetadata) error {
+		invoked.Add(1)
+		<-releaseC
+		return nil
+	}
+	opt := jobExecOption{timeout: 10 * time.Millisecond, timedOut: timedOut}
+	for i := 0; i < 2; i++ {
+		res := jobAttempt(context.Background(), opt, md, hung)
+		require.ErrorIs(t, res.err, context.DeadlineExceeded)
+	}
+	require.Equal(t, int64(2), timedOut.running.Load())
+
+	res := jobAttempt(context.Background(), opt, md, hung)
+	require.ErrorIs(t, res.err, ErrTimingWheelJobTimeoutOverflow)
+	require.Equal(t, int64(2), invoked.Load())
+
+	close(releaseC)
+	require.Eventually(t, func() bool {
+		return timedOut.running.Load() == 0
+	}, time.Second, time.Millisecond)
+	res = jobAttempt(context.Background(), opt, md, hung)
+	require.NoError(t, res.err)
+	require.Equal(t, int64(3), invoked.Load())
+}
+
+type fixedRetry struct {
+	backoff time.Duration
+	rest    int
+}
+
+func (r *fixedRetry) Next() time.Duration {
+	if r.rest <= 0 {
+		return 0
+	}
+	r.rest--
+	return r.backoff
+}
+
+func TestJobExecWrapper_FakeClock(t *testing.T) {
+	md := &jobMetadata{jobID: "1", jobName: "job", expirationMs: 1000}
+	clock := hrtime.NewFakeClock(time.Now())
+	resultC := make(chan JobResult, 1)
+	opt := jobExecOption{
+		clock:   clock,
+		timeout: 50 * time.Millisecond,
+		retry: func() RetryStrategy {
+			return &fixedRetry{backoff: 100 * time.Millisecond, rest: 1}
+		},
+		onResult: func(result JobResult) {
+			resultC <- result
+		},
+	}
+	var attempts atomic.Int64
+	go jobExecWrapper(opt, func(ctx context.Context, md JobMetadata) error {
+		attempts.Add(1)
+		<-ctx.Done()
+		return ctx.Err()
+	})(context.Background(), md)
+
+	// The timeout, the backoff and the timeout of the retry are all
+	// waiting for the clock.
+	for i, d := range []time.Duration{50, 100, 50} {
+		require.Eventually(t, func() bool {
+			return clock.PendingTimers() == 1 && attempts.Load() == int64(i/2+1)
+		}, time.Second, time.Millisecond)
+		clock.Advance(d * time.Millisecond)
+	}
+	result := <-resultC
+	require.Equal(t, 2, result.Attempts)
+	require.ErrorIs(t, result.Err, context.DeadlineExceeded)
+	require.Equal(t, 200*time.Millisecond, result.Duration)
+}
+
+func TestXTimingWheelsV2_OnJobResult(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	resultC := make(chan JobResult, 4)
+	tw := NewXTimingWheelsV2(ctx,
+		WithTimingWheelsJobTimeout(50*time.Millisecond),
+		WithTimingWheelsOnJobResult(func(result JobResult) {
+			resultC <- result
+		}),
+	)
+	defer tw.Shutdown()
+
+	nowMs := time.Now().UnixMilli()
+	require.NoError(t, tw.AddTask(NewOnceTask(ctx, "hung", nowMs+100, func(ctx context.Context, md JobMetadata) {
+		<-ctx.Done()
+	})))
+	var attempts atomic.Int64
+	require.NoError(t, tw.AddTask(NewOnceErrTask(ctx, "retry", nowMs+100, func(ctx context.Context, md JobMetadata) error {
+		if attempts.Add(1) < 2 {
+			return errors.New("job failed")
+		}
+		return nil
+	}, WithTaskJobRetry(func() RetryStrategy {
+		return dlock.LimitedRetry(10*time.Millisecond, 3)
+	}))))
+
+	results := make(map[JobID]JobResult, 2)
+	for len(results) < 2 {
+		select {
+		case result := <-resultC:
+			results[result.JobID] = result
+		case <-ctx.Done():
+			t.Fatal("job results are not received")
+		}
+	}
+	require.ErrorIs(t, results["hung"].Err, context.DeadlineExceeded)
+	require.Equal(t, 1, results["hung"].Attempts)
+	require.NoError(t, results["retry"].Err)
+	require.Equal(t, 2, results["retry"].Attempts)
+}
+
+func TestSubmitJob_OverlapPolicy(t *testing.T) {
+	pool, err := ants.NewPool(defaultMinWorkerPoolSize)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	ctx := context.Background()
+	testcases := []struct {
+		policy   OverlapPolicy
+		expected []int64
+	}{
+		{policy: OverlapAllowConcurrent, expected: []int64{1, 2, 3}},
+		{policy: OverlapSkipIfRunning, expected: []int64{1}},
+		{policy: OverlapQueueOne, expected: []int64{1, 3}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			var (
+				lock  sync.Mutex
+				fired []int64
+				doneC = make(chan struct{})
+			)
+			task := NewOnceTask(ctx, "1", 0, func(ctx context.Context, md JobMetadata) {}, WithTaskOverlapPolicy(tc.policy))
+			invoke := func(ctx context.Context, md JobMetadata) {
+				if md.GetExpiredMs() == 1 {
+					<-doneC
+				}
+				lock.Lock()
+				fired = append(fired, md.GetExpiredMs())
+				lock.Unlock()
+			}
+			for i := int64(1); i <= 3; i++ {
+				atomic.StoreInt64(&task.(*xTask).expirationMs, i)
+				submitJob(pool, ctx, task, invoke)
+				if i == 1 {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			close(doneC)
+			require.Eventually(t, func() bool {
+				lock.Lock()
+				defer lock.Unlock()
+				return len(fired) == len(tc.expected)
+			}, time.Second, 5*time.Millisecond)
+			time.Sleep(20 * time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			require.ElementsMatch(t, tc.expected, fired)
+			guard := task.getJobOverlapGuard()
+			guard.lock.Lock()
+			defer guard.lock.Unlock()
+			require.False(t, guard.running)
+		})
+	}
+}
+
+func TestXScheduledTask_Misfire(t *testing.T) {
+	ctx := context.Background()
+	job := func(ctx context.Context, md JobMetadata) {}
+	nowMs := time.Now().UnixMilli()
+	testcases := []struct {
+		policy     MisfirePolicy
+		fire       bool
+		expectedMs int64
+	}{
+		{policy: MisfireFireAllMissed, fire: true, expectedMs: nowMs - 900},
+		{policy: MisfireFireOnceNow, fire: true, expectedMs: nowMs + 100},
+		{policy: MisfireSkipToNext, fire: false, expectedMs: nowMs + 100},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			task := NewRepeatTask(ctx, "1", nowMs-1100, NewInfiniteScheduler(100*time.Millisecond), job,
+				WithTaskMisfirePolicy(tc.policy, 500*time.Millisecond),
+			)
+			require.Equal(t, nowMs-1000, task.GetExpiredMs())
+			require.Equal(t, tc.fire, task.misfire(nowMs))
+			task.UpdateNextScheduledMs()
+			require.Equal(t, tc.expectedMs, task.GetExpiredMs())
+
+			// Not misfired within the threshold.
+			require.True(t, task.misfire(task.GetExpiredMs()+500))
+		})
+	}
+	require.True(t, NewOnceTask(ctx, "2", nowMs-1000, job).misfire(nowMs))
+}
+
+func TestXTimingWheelsV2_OverlapSkipIfRunning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx)
+	defer tw.Shutdown()
+
+	var running, maxRunning, fired atomic.Int64
+	require.NoError(t, tw.AddTask(NewRepeatTask(ctx, "long", time.Now().UnixMilli(), NewInfiniteScheduler(50*time.Millisecond),
+		func(ctx context.Context, md JobMetadata) {
+			fired.Add(1)
+			n := running.Add(1)
+			for prev := maxRunning.Load(); n > prev && !maxRunning.CompareAndSwap(prev, n); {
+				prev = maxRunning.Load()
+			}
+			time.Sleep(180 * time.Millisecond)
+			running.Add(-1)
+		},
+		WithTaskOverlapPolicy(OverlapSkipIfRunning),
+	)))
+	time.Sleep(time.Second)
+	require.NoError(t, tw.CancelTask("long"))
+	require.Equal(t, int64(1), maxRunning.Load())
+	require.LessOrEqual(t, fired.Load(), int64(6))
+	require.GreaterOrEqual(t, fired.Load(), int64(3))
+}
@@ -1,6 +1,7 @@
 package timer
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/benz9527/xboot/lib/hrtime"
@@ -47,6 +48,58 @@ func NewInfiniteScheduler(intervals ...time.Duration) Scheduler {
 	}
 }
 
+// NewHiResFiniteScheduler is the same as the NewFiniteScheduler, but
+// the intervals are able to be less than 1ms. The sub-ms intervals are
+// rejected by the ms timing wheels, they are for the hi-res timing wheels.
+func NewHiResFiniteScheduler(intervals ...time.Duration) Scheduler {
+	if len(intervals) == 0 {
+		return nil
+	}
+	for _, interval := range intervals {
+		if interval.Microseconds() <= 0 {
+			return nil
+		}
+	}
+	return &xScheduler{
+		isFinite:     true,
+		intervals:    intervals,
+		currentIndex: 0,
+	}
+}
+
+// NewHiResInfiniteScheduler is the same as the NewInfiniteScheduler,
+// but the intervals are able to be less than 1ms.
+func NewHiResInfiniteScheduler(intervals ...time.Duration) Scheduler {
+	if len(intervals) == 0 {
+		return nil
+	}
+	for _, interval := range intervals {
+		if interval.Microseconds() <= 0 {
+			return nil
+		}
+	}
+	return &xScheduler{
+		intervals:    intervals,
+		currentIndex: 0,
+	}
+}
+
+// nextInterval returns false if there is no more interval.
+func (x *xScheduler) nextInterval() (time.Duration, bool) {
+	if len(x.intervals) == 0 {
+		return 0, false
+	}
+	if x.currentIndex >= len(x.intervals) {
+		if x.isFinite {
+			return 0, false
+		}
+		x.currentIndex = 0
+	}
+	interval := x.intervals[x.currentIndex]
+	x.currentIndex++
+	return interval, true
+}
+
 func (x *xScheduler) next(beginMs int64) (nextExpiredMs int64) {
 	beginTime := hrtime.MillisToDefaultTzTime(beginMs)
 	if beginTime.IsZero() || len(x.intervals) == 0 {
@@ -67,6 +120,38 @@ func (x *xScheduler) next(beginMs int64) (nextExpiredMs int64) {
 	return next.UnixMilli()
 }
 
+// schedulerNextNs returns the next expiration in ns, the intervals of
+// the xScheduler are not truncated to ms.
+func schedulerNextNs(sched Scheduler, beginNs int64) int64 {
+	if x, ok := sched.(*xScheduler); ok {
+		interval, ok := x.nextInterval()
+		if !ok || interval <= 0 {
+			return -1
+		}
+		return beginNs + interval.Nanoseconds()
+	}
+	nextMs := sched.next(beginNs / int64(time.Millisecond))
+	if nextMs < 0 {
+		return -1
+	}
+	return nextMs * int64(time.Millisecond)
+}
+
+// checkMsScheduler returns the error if the scheduler has the sub-ms
+// intervals, which end the task silently in the ms timing wheels.
+func checkMsScheduler(jobID JobID, sched Scheduler) error {
+	x, ok := sched.(*xScheduler)
+	if !ok {
+		return nil
+	}
+	for _, interval := range x.intervals {
+		if interval.Milliseconds() <= 0 {
+			return fmt.Errorf("%w: job %s interval %s", ErrTimingWheelHiResScheduler, jobID, interval)
+		}
+	}
+	return nil
+}
+
 func (x *xScheduler) GetRestLoopCount() int64 {
 	if x.isFinite {
 		return int64(len(x.intervals) - x.currentIndex)
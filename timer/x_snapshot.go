@@ -0,0 +1,161 @@
+package timer
+
+import (
+	"sort"
+
+	"github.com/benz9527/xboot/lib/list"
+)
+
+// TaskInfo is the read-only view of a task.
+type TaskInfo struct {
+	JobID   JobID
+	JobName string
+	JobType JobType
+	// ExpiredMs is the next fire ms of the task.
+	ExpiredMs     int64
+	RestLoopCount int64
+	Paused        bool
+	// SlotLevel and SlotID are -1 if the task is not in any slot,
+	// e.g. paused, firing or expired immediately.
+	SlotLevel int64
+	SlotID    int64
+}
+
+// TaskFilter returns true if the task should be listed.
+type TaskFilter func(info TaskInfo) bool
+
+// TimingWheelSlotSnapshot is the occupancy of a slot.
+type TimingWheelSlotSnapshot struct {
+	SlotID       int64
+	ExpirationMs int64
+	TaskCount    int64
+	// NextFireMs is the earliest fire ms of the tasks in the slot.
+	NextFireMs int64
+}
+
+// TimingWheelLevelSnapshot is the occupancy of a level (the overflow
+// wheel is the next level). Only the occupied slots are listed.
+type TimingWheelLevelSnapshot struct {
+	Level         int64
+	TickMs        int64
+	Interval      int64
+	CurrentTimeMs int64
+	SlotSize      int64
+	TaskCount     int64
+	Slots         []TimingWheelSlotSnapshot
+}
+
+// TimingWheelsSnapshot is the point-in-time view of the timing wheels.
+type TimingWheelsSnapshot struct {
+	Name      string
+	StartMs   int64
+	TaskCount int
+	// NextFireMs is the earliest fire ms of the tasks in the slots,
+	// -1 if there is no task in the slots.
+	NextFireMs int64
+	Levels     []TimingWheelLevelSnapshot
+}
+
+func newTaskInfo(task Task) TaskInfo {
+	info := TaskInfo{
+		JobID:         task.GetJobID(),
+		JobName:       task.GetJobName(),
+		JobType:       task.GetJobType(),
+		ExpiredMs:     task.GetExpiredMs(),
+		RestLoopCount: task.GetRestLoopCount(),
+		Paused:        task.Paused(),
+		SlotLevel:     -1,
+		SlotID:        -1,
+	}
+	if slot := task.GetSlot(); slot != nil && slot != immediateExpiredSlot {
+		info.SlotLevel = slot.GetLevel()
+		info.SlotID = slot.GetSlotID()
+	}
+	return info
+}
+
+// listTaskInfos returns the filtered tasks ordered by the next fire ms.
+func listTaskInfos(tasks []Task, filter TaskFilter) []TaskInfo {
+	infos := make([]TaskInfo, 0, len(tasks))
+	for _, task := range tasks {
+		info := newTaskInfo(task)
+		if filter == nil || filter(info) {
+			infos = append(infos, info)
+		}
+	}
+	return sortTaskInfos(infos)
+}
+
+// sortTaskInfos orders the tasks by the next fire ms, then the job ID.
+func sortTaskInfos(infos []TaskInfo) []TaskInfo {
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].ExpiredMs == infos[j].ExpiredMs {
+			return infos[i].JobID < infos[j].JobID
+		}
+		return infos[i].ExpiredMs < infos[j].ExpiredMs
+	})
+	return infos
+}
+
+// snapshot returns the occupancy of the slot.
+func (slot *xSlot) snapshot() TimingWheelSlotSnapshot {
+	snapshot := TimingWheelSlotSnapshot{
+		SlotID:       slot.GetSlotID(),
+		ExpirationMs: slot.GetExpirationMs(),
+		TaskCount:    slot.tasks.Len(),
+		NextFireMs:   -1,
+	}
+	_ = slot.tasks.Foreach(func(idx int64, e *list.NodeElement[Task]) error {
+		if expiredMs := e.Value.GetExpiredMs(); snapshot.NextFireMs < 0 || expiredMs < snapshot.NextFireMs {
+			snapshot.NextFireMs = expiredMs
+		}
+		return nil
+	})
+	return snapshot
+}
+
+// snapshot returns the occupancy of the wheel and its overflow wheels.
+func (tw *timingWheel) snapshot() []TimingWheelLevelSnapshot {
+	levels := make([]TimingWheelLevelSnapshot, 0, 4)
+	for level, wheel := int64(0), TimingWheel(tw); wheel != nil; level++ {
+		w := wheel.(*timingWheel)
+		levelSnapshot := TimingWheelLevelSnapshot{
+			Level:         level,
+			TickMs:        w.GetTickMs(),
+			Interval:      w.GetInterval(),
+			CurrentTimeMs: w.GetCurrentTimeMs(),
+			SlotSize:      w.GetSlotSize(),
+			Slots:         make([]TimingWheelSlotSnapshot, 0, 8),
+		}
+		for _, slot := range w.slots {
+			xslot, ok := slot.(*xSlot)
+			if !ok || xslot.tasks.Len() <= 0 {
+				continue
+			}
+			slotSnapshot := xslot.snapshot()
+			levelSnapshot.TaskCount += slotSnapshot.TaskCount
+			levelSnapshot.Slots = append(levelSnapshot.Slots, slotSnapshot)
+		}
+		levels = append(levels, levelSnapshot)
+		wheel = w.getOverflowTimingWheel()
+	}
+	return levels
+}
+
+func newTimingWheelsSnapshot(name string, tw TimingWheel, taskCount int) TimingWheelsSnapshot {
+	snapshot := TimingWheelsSnapshot{
+		Name:       name,
+		StartMs:    tw.GetStartMs(),
+		TaskCount:  taskCount,
+		NextFireMs: -1,
+		Levels:     tw.(*timingWheel).snapshot(),
+	}
+	for _, level := range snapshot.Levels {
+		for _, slot := range level.Slots {
+			if snapshot.NextFireMs < 0 || (slot.NextFireMs >= 0 && slot.NextFireMs < snapshot.NextFireMs) {
+				snapshot.NextFireMs = slot.NextFireMs
+			}
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,297 @@
+package timer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+	"github.com/benz9527/xboot/lib/infra"
+)
+
+// TaskRecoveryPolicy decides how to recover the persisted task whose
+// expiration passed while the timing wheels were down.
+type TaskRecoveryPolicy uint8
+
+const (
+	// TaskRecoveryFireImmediately fires the once task immediately and
+	// fires the repeat task for all missed expirations one by one.
+	TaskRecoveryFireImmediately TaskRecoveryPolicy = iota
+	// TaskRecoverySkip drops the once task and the missed expirations
+	// of the repeat task. The repeat task continues from now.
+	TaskRecoverySkip
+	// TaskRecoveryCoalesce fires the task immediately once, all the
+	// missed expirations of the repeat task are coalesced into it.
+	// The repeat task continues from now.
+	TaskRecoveryCoalesce
+)
+
+func (p TaskRecoveryPolicy) String() string {
+	switch p {
+	case TaskRecoveryFireImmediately:
+		return "fire-immediately"
+	case TaskRecoverySkip:
+		return "skip"
+	case TaskRecoveryCoalesce:
+		return "coalesce"
+	default:
+	}
+	return "unknown"
+}
+
+// TaskSchedulerRecord is the serializable state of the scheduler.
+// Either the intervals or the cron spec is present.
+type TaskSchedulerRecord struct {
+	Intervals []time.Duration       `json:"intervals,omitempty"`
+	Index     int                   `json:"index,omitempty"`
+	Finite    bool                  `json:"finite,omitempty"`
+	CronSpec  string                `json:"cronSpec,omitempty"`
+	TzOffset  hrtime.TimeZoneOffset `json:"tzOffset,omitempty"`
+}
+
+// TaskRecord is the serializable task. The job itself is not able
+// to be serialized, so it is looked up by the registered job name.
+// Only the expiration, the scheduler state and the paused state survive
+// the restart. The task options (timeout, retry, overlap and misfire)
+// are not persisted, they are looked up with the job.
+type TaskRecord struct {
+	JobID     JobID                `json:"jobID"`
+	JobName   string               `json:"jobName"`
+	JobType   JobType              `json:"jobType"`
+	ExpiredMs int64                `json:"expiredMs"`
+	Scheduler *TaskSchedulerRecord `json:"scheduler,omitempty"`
+	Paused    bool                 `json:"paused,omitempty"`
+}
+
+// TaskStore persists the tasks of the timing wheels, so the pending
+// tasks are able to be recovered after restart.
+// The task is saved on added and rescheduled, and deleted on cancelled
+// or once the job of its last fire is done. The last fire is fired
+// again after recovered if the timing wheels were down before the job
+// was done (at least once).
+// The progress of the repeat task is saved in batches, instead of
+// each fire. The fires after the last saved progress are recovered by
+// the policy, but the fire running while its progress is saved is not.
+type TaskStore interface {
+	// Save adds or replaces the records by the job IDs in a single
+	// transaction.
+	Save(records ...TaskRecord) error
+	// Delete deletes the records by the job IDs in a single transaction.
+	// No error if not found.
+	Delete(jobIDs ...JobID) error
+	// LoadAll loads all the records.
+	LoadAll() ([]TaskRecord, error)
+	// Close closes the store.
+	Close() error
+}
+
+// persistentScheduler is the scheduler with serializable state.
+type persistentScheduler interface {
+	Scheduler
+	record() *TaskSchedulerRecord
+}
+
+var (
+	_ persistentScheduler = (*xScheduler)(nil)
+	_ persistentScheduler = (*cronScheduler)(nil)
+)
+
+func (x *xScheduler) record() *TaskSchedulerRecord {
+	return &TaskSchedulerRecord{
+		Intervals: x.intervals,
+		Index:     x.currentIndex,
+		Finite:    x.isFinite,
+	}
+}
+
+func (sched *cronScheduler) record() *TaskSchedulerRecord {
+	return &TaskSchedulerRecord{
+		CronSpec: sched.spec,
+		TzOffset: sched.tzOffset,
+	}
+}
+
+// newScheduler restores the scheduler from the record.
+func (r *TaskSchedulerRecord) newScheduler() (Scheduler, error) {
+	if len(r.CronSpec) > 0 {
+		return NewCronScheduler(r.CronSpec, r.TzOffset)
+	}
+	var sched Scheduler
+	if r.Finite {
+		sched = NewFiniteScheduler(r.Intervals...)
+	} else {
+		sched = NewInfiniteScheduler(r.Intervals...)
+	}
+	if sched == nil {
+		return nil, infra.WrapErrorStack(ErrTimingWheelUnknownScheduler)
+	}
+	sched.(*xScheduler).currentIndex = r.Index
+	return sched, nil
+}
+
+// newTaskRecord returns false if the task is anonymous or its
+// scheduler is unable to be persisted.
+func newTaskRecord(task Task) (TaskRecord, bool) {
+	record := TaskRecord{
+		JobID:     task.GetJobID(),
+		JobName:   task.GetJobName(),
+		JobType:   task.GetJobType(),
+		ExpiredMs: task.GetExpiredMs(),
+		Paused:    task.Paused(),
+	}
+	if len(record.JobName) <= 0 {
+		return record, false
+	}
+	if record.JobType == RepeatedJob {
+		sTask, ok := task.(*xScheduledTask)
+		if !ok {
+			return record, false
+		}
+		sched, ok := sTask.schedulerRecord()
+		if !ok {
+			return record, false
+		}
+		record.Scheduler = sched
+	}
+	return record, true
+}
+
+// registeredJob is the job registered by name with its task options.
+type registeredJob struct {
+	job  ErrJob
+	opts []TaskOption
+}
+
+// recoverTask restores the task from the record by the policy, the
+// task is created with the registered job and options.
+// It returns nil if the task is skipped.
+// The paused task is restored as is, regardless of the policy.
+func recoverTask(ctx context.Context, record TaskRecord, registered registeredJob, nowMs int64, policy TaskRecoveryPolicy) (Task, error) {
+	isExpired := record.ExpiredMs <= nowMs && !record.Paused
+	switch record.JobType {
+	case OnceJob:
+		if isExpired && policy == TaskRecoverySkip {
+			return nil, nil
+		}
+		t := newXTask(ctx, record.JobID, record.JobName, record.ExpiredMs, registered.job, registered.opts...)
+		if record.Paused {
+			t.pause(true)
+		}
+		return t, nil
+	case RepeatedJob:
+		if record.Scheduler == nil {
+			return nil, infra.WrapErrorStack(ErrTimingWheelUnknownScheduler)
+		}
+		sched, err := record.Scheduler.newScheduler()
+		if err != nil {
+			return nil, err
+		}
+		t := newXScheduledTask(ctx, record.JobID, record.JobName, record.ExpiredMs, sched, registered.job, registered.opts...)
+		t.expirationMs = record.ExpiredMs
+		if record.Paused {
+			t.pause(true)
+		}
+		if !isExpired || policy == TaskRecoveryFireImmediately {
+			return t, nil
+		}
+		// The next expiration is calculated from now.
+		t.beginMs = nowMs
+		if policy == TaskRecoverySkip {
+			t.UpdateNextScheduledMs()
+			if t.GetExpiredMs() < 0 {
+				return nil, nil
+			}
+		}
+		return t, nil
+	default:
+	}
+	return nil, infra.NewErrorStack(fmt.Sprintf("unknown job type %d", record.JobType))
+}
+
+var boltTaskStoreBucket = []byte("x-timing-wheels-tasks")
+
+var _ TaskStore = (*boltTaskStore)(nil)
+
+// boltTaskStore is the local TaskStore on the bbolt file.
+// The records are stored in JSON and keyed by the job ID.
+type boltTaskStore struct {
+	db *bbolt.DB
+}
+
+// NewBoltTaskStore opens (or creates) the bbolt file as the TaskStore.
+// The file is locked exclusively until the store is closed.
+func NewBoltTaskStore(path string) (TaskStore, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, infra.WrapErrorStackWithMessage(err, "open bolt task store failed")
+	}
+	if err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltTaskStoreBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, infra.WrapErrorStackWithMessage(err, "create bolt task store bucket failed")
+	}
+	return &boltTaskStore{db: db}, nil
+}
+
+func (s *boltTaskStore) Save(records ...TaskRecord) error {
+	if len(records) <= 0 {
+		return nil
+	}
+	data := make([][]byte, len(records))
+	for i := range records {
+		var err error
+		if data[i], err = json.Marshal(records[i]); err != nil {
+			return infra.WrapErrorStack(err)
+		}
+	}
+	return infra.WrapErrorStack(s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltTaskStoreBucket)
+		for i := range records {
+			if err := bucket.Put([]byte(records[i].JobID), data[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func (s *boltTaskStore) Delete(jobIDs ...JobID) error {
+	if len(jobIDs) <= 0 {
+		return nil
+	}
+	return infra.WrapErrorStack(s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltTaskStoreBucket)
+		for _, jobID := range jobIDs {
+			if err := bucket.Delete([]byte(jobID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func (s *boltTaskStore) LoadAll() ([]TaskRecord, error) {
+	records := make([]TaskRecord, 0, 16)
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltTaskStoreBucket).ForEach(func(k, v []byte) error {
+			var record TaskRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("job %s: %w", k, err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	}); err != nil {
+		return nil, infra.WrapErrorStackWithMessage(err, "load bolt task store failed")
+	}
+	return records, nil
+}
+
+func (s *boltTaskStore) Close() error {
+	return infra.WrapErrorStack(s.db.Close())
+}
@@ -0,0 +1,369 @@
+package timer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+)
+
+func TestBoltTaskStore(t *testing.T) {
+	store, err := NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	sched, err := NewCronScheduler("*/5 * * * * *", hrtime.TzUtc8Offset)
+	require.NoError(t, err)
+	records := []TaskRecord{
+		{JobID: "1", JobName: "once", JobType: OnceJob, ExpiredMs: 1000},
+		{JobID: "2", JobName: "repeat", JobType: RepeatedJob, ExpiredMs: 2000, Scheduler: sched.(*cronScheduler).record()},
+		{JobID: "3", JobName: "repeat", JobType: RepeatedJob, ExpiredMs: 3000, Scheduler: &TaskSchedulerRecord{
+			Intervals: []time.Duration{time.Second, 2 * time.Second},
+			Index:     1,
+			Finite:    true,
+		}},
+	}
+	for _, record := range records {
+		require.NoError(t, store.Save(record))
+	}
+	loaded, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Equal(t, records, loaded)
+
+	require.NoError(t, store.Delete("2"))
+	require.NoError(t, store.Delete("not-found"))
+	loaded, err = store.LoadAll()
+	require.NoError(t, err)
+	require.Equal(t, []TaskRecord{records[0], records[2]}, loaded)
+
+	require.NoError(t, store.Delete("1", "3"))
+	loaded, err = store.LoadAll()
+	require.NoError(t, err)
+	require.Empty(t, loaded)
+}
+
+func TestRecoverTask_Policy(t *testing.T) {
+	ctx := context.Background()
+	registered := registeredJob{
+		job:  func(ctx context.Context, md JobMetadata) error { return nil },
+		opts: []TaskOption{WithTaskOverlapPolicy(OverlapSkipIfRunning)},
+	}
+	nowMs := time.Now().UnixMilli()
+	onceRecord := TaskRecord{JobID: "1", JobName: "once", JobType: OnceJob, ExpiredMs: nowMs - 1000}
+	repeatRecord := TaskRecord{JobID: "2", JobName: "repeat", JobType: RepeatedJob, ExpiredMs: nowMs - 1000, Scheduler: &TaskSchedulerRecord{
+		Intervals: []time.Duration{300 * time.Millisecond},
+	}}
+
+	task, err := recoverTask(ctx, onceRecord, registered, nowMs, TaskRecoveryFireImmediately)
+	require.NoError(t, err)
+	require.Equal(t, onceRecord.ExpiredMs, task.GetExpiredMs())
+	require.Equal(t, "once", task.GetJobName())
+	// Created with the registered options.
+	require.Equal(t, OverlapSkipIfRunning, task.getJobExecOption().overlap)
+	task, err = recoverTask(ctx, onceRecord, registered, nowMs, TaskRecoveryCoalesce)
+	require.NoError(t, err)
+	require.Equal(t, onceRecord.ExpiredMs, task.GetExpiredMs())
+	task, err = recoverTask(ctx, onceRecord, registered, nowMs, TaskRecoverySkip)
+	require.NoError(t, err)
+	require.Nil(t, task)
+
+	// All missed expirations will be caught up.
+	task, err = recoverTask(ctx, repeatRecord, registered, nowMs, TaskRecoveryFireImmediately)
+	require.NoError(t, err)
+	require.Equal(t, repeatRecord.ExpiredMs, task.GetExpiredMs())
+	require.Equal(t, "repeat", task.GetJobName())
+	require.Equal(t, OverlapSkipIfRunning, task.getJobExecOption().overlap)
+	task.(ScheduledTask).UpdateNextScheduledMs()
+	require.Equal(t, repeatRecord.ExpiredMs+300, task.GetExpiredMs())
+	// Fires once and continues from now.
+	task, err = recoverTask(ctx, repeatRecord, registered, nowMs, TaskRecoveryCoalesce)
+	require.NoError(t, err)
+	require.Equal(t, repeatRecord.ExpiredMs, task.GetExpiredMs())
+	task.(ScheduledTask).UpdateNextScheduledMs()
+	require.Equal(t, nowMs+300, task.GetExpiredMs())
+	task, err = recoverTask(ctx, repeatRecord, registered, nowMs, TaskRecoverySkip)
+	require.NoError(t, err)
+	require.Equal(t, nowMs+300, task.GetExpiredMs())
+
+	// Not expired.
+	repeatRecord.ExpiredMs = nowMs + 1000
+	task, err = recoverTask(ctx, repeatRecord, registered, nowMs, TaskRecoverySkip)
+	require.NoError(t, err)
+	require.Equal(t, repeatRecord.ExpiredMs, task.GetExpiredMs())
+}
+
+func TestXTimingWheelsV2_TaskStore_Recovery(t *testing.T) {
+	store, err := NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	var onceCount, repeatCount atomic.Int64
+	opts := []TimingWheelsOption{
+		WithTimingWheelsTaskStore(store, TaskRecoveryFireImmediately),
+		WithTimingWheelsJob("once", func(ctx context.Context, md JobMetadata) {
+			onceCount.Add(1)
+		}),
+		WithTimingWheelsJob("repeat", func(ctx context.Context, md JobMetadata) {
+			repeatCount.Add(1)
+		}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx, opts...)
+
+	nowMs := time.Now().UnixMilli()
+	require.NoError(t, tw.AddTask(NewNamedOnceTask(ctx, "once-1", "once", nowMs+500, func(ctx context.Context, md JobMetadata) {
+		onceCount.Add(1)
+	})))
+	require.NoError(t, tw.AddTask(NewNamedOnceTask(ctx, "once-2", "once", nowMs+500, func(ctx context.Context, md JobMetadata) {
+		onceCount.Add(1)
+	})))
+	require.NoError(t, tw.AddTask(NewNamedRepeatTask(ctx, "repeat-1", "repeat", nowMs, NewFiniteScheduler(
+		400*time.Millisecond,
+		100*time.Millisecond,
+		100*time.Millisecond,
+	), func(ctx context.Context, md JobMetadata) {
+		repeatCount.Add(1)
+	})))
+	err = tw.AddTask(NewNamedOnceTask(ctx, "once-3", "unregistered", nowMs+500, func(ctx context.Context, md JobMetadata) {}))
+	require.True(t, errors.Is(err, ErrTimingWheelTaskUnpersistable))
+	// The anonymous task is not persisted.
+	_, err = tw.AfterFunc(500*time.Millisecond, func(ctx context.Context, md JobMetadata) {})
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, tw.CancelTask("once-2"))
+	time.Sleep(50 * time.Millisecond)
+	tw.Shutdown()
+
+	records, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, JobID("once-1"), records[0].JobID)
+	require.Equal(t, JobID("repeat-1"), records[1].JobID)
+	require.Equal(t, 1, records[1].Scheduler.Index)
+	require.Equal(t, int64(0), onceCount.Load())
+	require.Equal(t, int64(0), repeatCount.Load())
+
+	// Recovered and all expirations are fired.
+	tw = NewXTimingWheelsV2(ctx, opts...)
+	defer tw.Shutdown()
+	require.Eventually(t, func() bool {
+		return onceCount.Load() == 1 && repeatCount.Load() == 3
+	}, 2*time.Second, 10*time.Millisecond, "once %d, repeat %d", onceCount.Load(), repeatCount.Load())
+	require.Eventually(t, func() bool {
+		records, err = store.LoadAll()
+		return err == nil && len(records) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestXTimingWheelsV2_TaskStore_AtLeastOnce(t *testing.T) {
+	store, err := NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	var (
+		startedC = make(chan struct{}, 1)
+		releaseC = make(chan struct{})
+		count    atomic.Int64
+	)
+	job := func(ctx context.Context, md JobMetadata) {
+		count.Add(1)
+		startedC <- struct{}{}
+		<-releaseC
+	}
+	opts := []TimingWheelsOption{
+		WithTimingWheelsTaskStore(store, TaskRecoveryFireImmediately),
+		WithTimingWheelsJob("once", job),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx, opts...)
+	require.NoError(t, tw.AddTask(NewNamedOnceTask(ctx, "once-1", "once", time.Now().UnixMilli()+100, job)))
+
+	// The record is kept until the job is done.
+	<-startedC
+	time.Sleep(50 * time.Millisecond)
+	records, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	// Down while the job is running.
+	tw.Shutdown()
+
+	tw = NewXTimingWheelsV2(ctx, opts...)
+	defer tw.Shutdown()
+	<-startedC
+	require.Equal(t, int64(2), count.Load())
+	close(releaseC)
+	require.Eventually(t, func() bool {
+		records, err = store.LoadAll()
+		return err == nil && len(records) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// countingTaskStore counts the transactions of saving.
+type countingTaskStore struct {
+	TaskStore
+	saves atomic.Int64
+}
+
+func (s *countingTaskStore) Save(records ...TaskRecord) error {
+	s.saves.Add(1)
+	return s.TaskStore.Save(records...)
+}
+
+func TestXTimingWheelsV2_TaskStore_FlushProgress(t *testing.T) {
+	boltStore, err := NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	require.NoError(t, err)
+	store := &countingTaskStore{TaskStore: boltStore}
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	var (
+		count     atomic.Int64
+		lastMs    atomic.Int64
+		countFire = func(ctx context.Context, md JobMetadata) {
+			count.Add(1)
+			lastMs.Store(md.GetExpiredMs())
+		}
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx,
+		WithTimingWheelsTaskStore(store, TaskRecoveryFireImmediately),
+		WithTimingWheelsTaskStoreFlushInterval(100*time.Millisecond),
+		WithTimingWheelsJob("repeat", countFire),
+	)
+	require.NoError(t, tw.AddTask(NewNamedRepeatTask(ctx, "repeat-1", "repeat", time.Now().UnixMilli(),
+		NewInfiniteScheduler(10*time.Millisecond), countFire,
+	)))
+	require.Eventually(t, func() bool {
+		return count.Load() >= 30
+	}, 2*time.Second, 10*time.Millisecond)
+	tw.Shutdown()
+
+	// Saved on added and flushed in batches, instead of each fire.
+	require.Less(t, store.saves.Load(), count.Load()/3)
+	// The latest progress is flushed on shutdown.
+	records, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Greater(t, records[0].ExpiredMs, lastMs.Load())
+}
+
+func TestXTimingWheelsV2_TaskStore_AddTaskRollback(t *testing.T) {
+	store, err := NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	job := func(ctx context.Context, md JobMetadata) {}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx,
+		WithTimingWheelsTaskStore(store, TaskRecoveryFireImmediately),
+		WithTimingWheelsJob("once", job),
+	)
+	defer tw.Shutdown()
+
+	expiredMs := time.Now().UnixMilli() + 60_000
+	require.NoError(t, tw.AddTask(NewNamedOnceTask(ctx, "once-2", "once", expiredMs, job)))
+	require.Eventually(t, func() bool {
+		_, err := tw.GetTask("once-2")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Unable to publish the event.
+	require.NoError(t, tw.(*xTimingWheelsV2).twEventDisruptor.Stop())
+	require.Error(t, tw.AddTask(NewNamedOnceTask(ctx, "once-1", "once", expiredMs, job)))
+	// The record of the live task is restored.
+	require.Error(t, tw.AddTask(NewNamedOnceTask(ctx, "once-2", "once", expiredMs+60_000, job)))
+	records, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, JobID("once-2"), records[0].JobID)
+	require.Equal(t, expiredMs, records[0].ExpiredMs)
+}
+
+// failingTaskStore aborts the transaction of saving with the failed job.
+type failingTaskStore struct {
+	TaskStore
+	failJobID JobID
+	saves     atomic.Int64
+}
+
+func (s *failingTaskStore) Save(records ...TaskRecord) error {
+	s.saves.Add(1)
+	for _, record := range records {
+		if record.JobID == s.failJobID {
+			return errors.New("store unavailable")
+		}
+	}
+	return s.TaskStore.Save(records...)
+}
+
+func TestXTimingWheelsV2_TaskStore_AddTasksRollback(t *testing.T) {
+	boltStore, err := NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	require.NoError(t, err)
+	store := &failingTaskStore{TaskStore: boltStore, failJobID: "once-3"}
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	job := func(ctx context.Context, md JobMetadata) {}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx,
+		WithTimingWheelsTaskStore(store, TaskRecoveryFireImmediately),
+		WithTimingWheelsJob("once", job),
+	)
+	defer tw.Shutdown()
+	newTasks := func(ids ...JobID) []Task {
+		tasks := make([]Task, 0, len(ids))
+		for _, id := range ids {
+			tasks = append(tasks, NewNamedOnceTask(ctx, id, "once", time.Now().UnixMilli()+60_000, job))
+		}
+		return tasks
+	}
+
+	// None of the records is saved if the store fails.
+	require.Error(t, tw.AddTasks(newTasks("once-1", "once-2", "once-3")))
+	require.Equal(t, int64(1), store.saves.Load())
+	records, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Empty(t, records)
+	_, err = tw.GetTask("once-1")
+	require.ErrorIs(t, err, ErrTimingWheelTaskNotFound)
+
+	require.NoError(t, tw.AddTasks(newTasks("once-1", "once-2")))
+	require.Equal(t, int64(2), store.saves.Load())
+	require.Eventually(t, func() bool {
+		_, err1 := tw.GetTask("once-1")
+		_, err2 := tw.GetTask("once-2")
+		return err1 == nil && err2 == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// The new records are deleted if unable to publish the event, but
+	// the records of the live tasks are kept.
+	require.NoError(t, tw.(*xTimingWheelsV2).twEventDisruptor.Stop())
+	require.Error(t, tw.AddTasks(newTasks("once-4", "once-1")))
+	records, err = store.LoadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, JobID("once-1"), records[0].JobID)
+	require.Equal(t, JobID("once-2"), records[1].JobID)
+}
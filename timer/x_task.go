@@ -2,9 +2,12 @@ package timer
 
 import (
 	"context"
+	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
+	"github.com/benz9527/xboot/lib/infra"
 	"github.com/benz9527/xboot/lib/list"
 )
 
@@ -14,6 +17,7 @@ type elementTasker interface {
 
 type jobMetadata struct {
 	jobID        JobID
+	jobName      string
 	job          Job
 	expirationMs int64
 	loopCount    int64
@@ -36,13 +40,30 @@ func (m *jobMetadata) GetJobType() JobType {
 	return m.jobType
 }
 
+func (m *jobMetadata) GetJobName() string {
+	return m.jobName
+}
+
+const (
+	taskRunning int32 = iota
+	// The task is requested to be paused while it is not in any slot
+	// (e.g. firing), and it will be parked once it comes back.
+	taskPausing
+	// The task is parked out of the timing wheels.
+	taskPaused
+)
+
 type task struct {
 	*jobMetadata
 	slotMetadata TimingWheelSlotMetadata
 	slot         unsafe.Pointer // TimingWheelSlot
 	// Doubly pointer reference, it is easy for us to access the element in the list.
 	elementRef unsafe.Pointer // list.NodeElement[Task]
+	errJob     ErrJob
 	cancelled  *atomic.Bool
+	pauseState atomic.Int32
+	execOpt    jobExecOption
+	overlap    jobOverlapGuard
 }
 
 var (
@@ -63,6 +84,7 @@ func (t *task) GetJobID() JobID {
 func (t *task) GetJobMetadata() JobMetadata {
 	md := &jobMetadata{
 		jobID:        t.jobID,
+		jobName:      t.jobName,
 		job:          t.job,
 		expirationMs: t.expirationMs,
 		loopCount:    t.loopCount,
@@ -79,6 +101,10 @@ func (t *task) GetJob() Job {
 	return t.job
 }
 
+func (t *task) getErrJob() ErrJob {
+	return t.errJob
+}
+
 func (t *task) Cancelled() bool {
 	return t.cancelled.Load()
 }
@@ -115,6 +141,61 @@ func (t *task) GetJobType() JobType {
 	return t.jobType
 }
 
+func (t *task) Paused() bool {
+	return t.pauseState.Load() != taskRunning
+}
+
+// pause parks the task if it has been removed from the slot,
+// otherwise the task will be parked once it comes back.
+func (t *task) pause(removed bool) {
+	if removed {
+		t.pauseState.Store(taskPaused)
+		return
+	}
+	t.pauseState.CompareAndSwap(taskRunning, taskPausing)
+}
+
+// park returns true if the task is paused, and the pausing task
+// is parked.
+func (t *task) park() bool {
+	if t.pauseState.CompareAndSwap(taskPausing, taskPaused) {
+		return true
+	}
+	return t.pauseState.Load() == taskPaused
+}
+
+// resume returns true if the task has been parked, and it has to be
+// added back to the timing wheels.
+func (t *task) resume() (parked bool) {
+	if t.pauseState.CompareAndSwap(taskPausing, taskRunning) {
+		return false
+	}
+	return t.pauseState.CompareAndSwap(taskPaused, taskRunning)
+}
+
+// reschedule updates the expiration of the once task.
+func (t *task) reschedule(expiredMs int64, scheduler Scheduler, nowMs int64) error {
+	if scheduler != nil || expiredMs <= 0 {
+		return infra.WrapErrorStackWithMessage(ErrTimingWheelInvalidReschedule,
+			"the once task must be rescheduled with the expiration only")
+	}
+	atomic.StoreInt64(&t.expirationMs, expiredMs)
+	return nil
+}
+
+func (t *task) getJobExecOption() jobExecOption {
+	return t.execOpt
+}
+
+func (t *task) getJobOverlapGuard() *jobOverlapGuard {
+	return &t.overlap
+}
+
+// misfire always fires the once task.
+func (t *task) misfire(nowMs int64) bool {
+	return true
+}
+
 func (t *task) Cancel() bool {
 	if stopped := t.cancelled.Swap(true); stopped {
 		// Previous value is true, it means that the task has been cancelled.
@@ -147,12 +228,21 @@ func (t *xTask) Cancel() bool {
 
 type xScheduledTask struct {
 	*xTask
-	beginMs   int64
+	beginMs int64
+	// The scheduler is stateful and able to be replaced by the reschedule,
+	// it is guarded by the schedLock.
+	schedLock sync.Mutex
 	scheduler Scheduler
 }
 
 func (t *xScheduledTask) UpdateNextScheduledMs() {
-	expiredMs := t.scheduler.next(t.beginMs)
+	t.schedLock.Lock()
+	defer t.schedLock.Unlock()
+	t.updateNextScheduledMs()
+}
+
+func (t *xScheduledTask) updateNextScheduledMs() {
+	expiredMs := t.scheduler.next(atomic.LoadInt64(&t.beginMs))
 	atomic.StoreInt64(&t.expirationMs, expiredMs)
 	if expiredMs == -1 {
 		return
@@ -160,7 +250,95 @@ func (t *xScheduledTask) UpdateNextScheduledMs() {
 	atomic.SwapInt64(&t.beginMs, expiredMs)
 }
 
+// nextScheduledNs returns the next expiration in ns from the baseNs.
+func (t *xScheduledTask) nextScheduledNs(baseNs int64) int64 {
+	t.schedLock.Lock()
+	defer t.schedLock.Unlock()
+	return schedulerNextNs(t.scheduler, baseNs)
+}
+
+// schedulerRecord returns false if the scheduler is unable to be persisted.
+func (t *xScheduledTask) schedulerRecord() (*TaskSchedulerRecord, bool) {
+	t.schedLock.Lock()
+	defer t.schedLock.Unlock()
+	sched, ok := t.scheduler.(persistentScheduler)
+	if !ok {
+		return nil, false
+	}
+	return sched.record(), true
+}
+
+// reschedule replaces the scheduler if it is not nil, and the task will
+// be fired at the expiredMs or the next ms of the scheduler from now.
+// The rest loop count is preserved if the scheduler is not replaced.
+func (t *xScheduledTask) reschedule(expiredMs int64, scheduler Scheduler, nowMs int64) error {
+	if scheduler == nil && expiredMs <= 0 {
+		return infra.WrapErrorStackWithMessage(ErrTimingWheelInvalidReschedule,
+			"the repeat task must be rescheduled with the expiration or the scheduler")
+	}
+	t.schedLock.Lock()
+	defer t.schedLock.Unlock()
+	if scheduler != nil {
+		t.scheduler = scheduler
+	}
+	if expiredMs > 0 {
+		atomic.StoreInt64(&t.expirationMs, expiredMs)
+		atomic.StoreInt64(&t.beginMs, expiredMs)
+		return nil
+	}
+	atomic.StoreInt64(&t.beginMs, nowMs)
+	t.updateNextScheduledMs()
+	if t.GetExpiredMs() < 0 {
+		return infra.WrapErrorStackWithMessage(ErrTimingWheelInvalidReschedule,
+			"the scheduler without the next expiration")
+	}
+	return nil
+}
+
+// rescheduleNs replaces the scheduler, and returns the next expiration in
+// ns from the nowNs, the sub-ms intervals are not truncated.
+func (t *xScheduledTask) rescheduleNs(scheduler Scheduler, nowNs int64) (int64, error) {
+	t.schedLock.Lock()
+	defer t.schedLock.Unlock()
+	t.scheduler = scheduler
+	nextNs := schedulerNextNs(scheduler, nowNs)
+	if nextNs < 0 {
+		return -1, infra.WrapErrorStackWithMessage(ErrTimingWheelInvalidReschedule,
+			"the scheduler without the next expiration")
+	}
+	atomic.StoreInt64(&t.expirationMs, nextNs/int64(time.Millisecond))
+	atomic.StoreInt64(&t.beginMs, nextNs/int64(time.Millisecond))
+	return nextNs, nil
+}
+
+// checkMsTask returns the error if the repeat task is scheduled by the
+// hi-res scheduler, which is unsupported by the ms timing wheels.
+func checkMsTask(task Task) error {
+	sTask, ok := task.(*xScheduledTask)
+	if !ok {
+		return nil
+	}
+	sTask.schedLock.Lock()
+	sched := sTask.scheduler
+	sTask.schedLock.Unlock()
+	return checkMsScheduler(task.GetJobID(), sched)
+}
+
+// misfire continues the task from now if the fire is misfired, so the
+// next expiration is calculated from now instead of the missed one.
+func (t *xScheduledTask) misfire(nowMs int64) bool {
+	opt := t.getJobExecOption()
+	if opt.misfire == MisfireFireAllMissed || opt.misfireThresholdMs <= 0 ||
+		nowMs-t.GetExpiredMs() <= opt.misfireThresholdMs {
+		return true
+	}
+	atomic.StoreInt64(&t.beginMs, nowMs)
+	return opt.misfire == MisfireFireOnceNow
+}
+
 func (t *xScheduledTask) GetRestLoopCount() int64 {
+	t.schedLock.Lock()
+	defer t.schedLock.Unlock()
 	return t.scheduler.GetRestLoopCount()
 }
 
@@ -183,25 +361,53 @@ func NewOnceTask(
 	jobID JobID,
 	expiredMs int64,
 	job Job,
+	opts ...TaskOption,
+) Task {
+	if ctx == nil {
+		return nil
+	}
+	return newXTask(ctx, jobID, "", expiredMs, errJobAdapter(job), opts...)
+}
+
+// NewOnceErrTask creates a once task with the job returning the error.
+func NewOnceErrTask(
+	ctx context.Context,
+	jobID JobID,
+	expiredMs int64,
+	job ErrJob,
+	opts ...TaskOption,
 ) Task {
 	if ctx == nil {
 		return nil
 	}
+	return newXTask(ctx, jobID, "", expiredMs, job, opts...)
+}
 
-	t := &xTask{
+// newXTask creates the once task, the Job is adapted from the ErrJob.
+func newXTask(
+	ctx context.Context,
+	jobID JobID,
+	jobName string,
+	expiredMs int64,
+	job ErrJob,
+	opts ...TaskOption,
+) *xTask {
+	return &xTask{
 		task: &task{
 			jobMetadata: &jobMetadata{
 				jobID:        jobID,
+				jobName:      jobName,
 				expirationMs: expiredMs,
 				loopCount:    1,
-				job:          job,
+				job:          jobAdapter(job),
 				jobType:      OnceJob,
 			},
+			errJob:    job,
 			cancelled: &atomic.Bool{},
+			execOpt:   newJobExecOption(opts...),
 		},
 		ctx: ctx,
 	}
-	return t
 }
 
 func NewRepeatTask(
@@ -210,25 +416,118 @@ func NewRepeatTask(
 	beginMs int64,
 	scheduler Scheduler,
 	job Job,
+	opts ...TaskOption,
+) ScheduledTask {
+	return NewRepeatErrTask(ctx, jobID, beginMs, scheduler, errJobAdapter(job), opts...)
+}
+
+// NewRepeatErrTask creates a repeat task with the job returning the error.
+func NewRepeatErrTask(
+	ctx context.Context,
+	jobID JobID,
+	beginMs int64,
+	scheduler Scheduler,
+	job ErrJob,
+	opts ...TaskOption,
 ) ScheduledTask {
 	if ctx == nil || scheduler == nil || job == nil {
 		return nil
 	}
-	t := &xScheduledTask{
+	t := newXScheduledTask(ctx, jobID, "", beginMs, scheduler, job, opts...)
+	t.UpdateNextScheduledMs()
+	return t
+}
+
+// newXScheduledTask creates the repeat task without the first expiration.
+func newXScheduledTask(
+	ctx context.Context,
+	jobID JobID,
+	jobName string,
+	beginMs int64,
+	scheduler Scheduler,
+	job ErrJob,
+	opts ...TaskOption,
+) *xScheduledTask {
+	return &xScheduledTask{
 		xTask: &xTask{
 			task: &task{
 				jobMetadata: &jobMetadata{
 					jobID:   jobID,
-					job:     job,
+					jobName: jobName,
+					job:     jobAdapter(job),
 					jobType: RepeatedJob,
 				},
+				errJob:    job,
 				cancelled: &atomic.Bool{},
+				execOpt:   newJobExecOption(opts...),
 			},
 			ctx: ctx,
 		},
 		scheduler: scheduler,
 		beginMs:   beginMs,
 	}
-	t.UpdateNextScheduledMs()
+}
+
+// NewNamedOnceTask creates a once task with the registered job name,
+// so the task is able to be persisted and recovered by the TaskStore.
+func NewNamedOnceTask(
+	ctx context.Context,
+	jobID JobID,
+	jobName string,
+	expiredMs int64,
+	job Job,
+	opts ...TaskOption,
+) Task {
+	return NewNamedOnceErrTask(ctx, jobID, jobName, expiredMs, errJobAdapter(job), opts...)
+}
+
+// NewNamedOnceErrTask is the same as the NewNamedOnceTask, but the job
+// returns the error.
+func NewNamedOnceErrTask(
+	ctx context.Context,
+	jobID JobID,
+	jobName string,
+	expiredMs int64,
+	job ErrJob,
+	opts ...TaskOption,
+) Task {
+	if ctx == nil {
+		return nil
+	}
+	return newXTask(ctx, jobID, jobName, expiredMs, job, opts...)
+}
+
+// NewNamedRepeatTask creates a repeat task with the registered job name,
+// so the task is able to be persisted and recovered by the TaskStore.
+// The scheduler must be created by NewFiniteScheduler, NewInfiniteScheduler
+// or NewCronScheduler.
+func NewNamedRepeatTask(
+	ctx context.Context,
+	jobID JobID,
+	jobName string,
+	beginMs int64,
+	scheduler Scheduler,
+	job Job,
+	opts ...TaskOption,
+) ScheduledTask {
+	return NewNamedRepeatErrTask(ctx, jobID, jobName, beginMs, scheduler, errJobAdapter(job), opts...)
+}
+
+// NewNamedRepeatErrTask is the same as the NewNamedRepeatTask, but the
+// job returns the error.
+func NewNamedRepeatErrTask(
+	ctx context.Context,
+	jobID JobID,
+	jobName string,
+	beginMs int64,
+	scheduler Scheduler,
+	job ErrJob,
+	opts ...TaskOption,
+) ScheduledTask {
+	t := NewRepeatErrTask(ctx, jobID, beginMs, scheduler, job, opts...)
+	if t == nil {
+		return nil
+	}
+	t.(*xScheduledTask).jobName = jobName
 	return t
 }
@@ -0,0 +1,766 @@
+package timer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+	"github.com/benz9527/xboot/lib/id"
+	"github.com/benz9527/xboot/lib/infra"
+	"github.com/benz9527/xboot/lib/queue"
+)
+
+// hiResEntry is the task in the hi-res timing wheels. The expiration of
+// the task is kept in ns, the task's expiredMs is truncated from it.
+type hiResEntry struct {
+	task   Task
+	expNs  int64
+	bucket *hiResBucket
+	due    bool // Waits to be fired by the schedule goroutine
+}
+
+// hiResBucket is the slot of a level, it is expired at the expNs.
+type hiResBucket struct {
+	level   int64
+	slotID  int64
+	expNs   int64
+	queued  bool
+	entries map[*hiResEntry]struct{}
+}
+
+type hiResLevel struct {
+	tickNs     int64
+	intervalNs int64
+	currentNs  int64 // Truncated by the tickNs
+	buckets    []*hiResBucket
+}
+
+// xHiResTimingWheels is the hierarchical timing wheels in ns units, the
+// precision is the tick of the lowest level, which is able to be less
+// than 1ms.
+// All the levels and buckets are guarded by the lock, and the expired
+// buckets are flushed by a single goroutine, which sleeps until the
+// earliest bucket expired by the clock's timer. The tasks expired once
+// added are fired by the same goroutine, instead of the callers.
+type xHiResTimingWheels struct {
+	ctx         context.Context
+	levels      []*hiResLevel
+	bucketQ     queue.PriorityQueue[*hiResBucket] // Ordered by the expNs
+	entries     map[JobID]*hiResEntry
+	dueEntries  []*hiResEntry
+	lock        sync.Mutex
+	wakeUpC     chan struct{}
+	stopC       chan struct{}
+	timerHolds  atomic.Int64 // The clock's holds released once the expired buckets fired
+	gPool       *ants.Pool
+	isRunning   *atomic.Bool
+	clock       hrtime.Clock
+	idGenerator id.Gen
+	jobDLockOpt jobDLockOption
+	jobExecOpt  jobExecOption
+	name        string
+	startNs     int64
+	tickNs      int64
+}
+
+var _ HiResTimingWheels = (*xHiResTimingWheels)(nil)
+
+func (xtw *xHiResTimingWheels) GetTickMs() int64 {
+	return xtw.tickNs / int64(time.Millisecond)
+}
+
+func (xtw *xHiResTimingWheels) GetStartMs() int64 {
+	return xtw.startNs / int64(time.Millisecond)
+}
+
+func (xtw *xHiResTimingWheels) nowNs() int64 {
+	return xtw.clock.NowInDefaultTZ().UnixNano()
+}
+
+func (xtw *xHiResTimingWheels) Shutdown() {
+	if xtw == nil {
+		return
+	}
+	if old := xtw.isRunning.Swap(false); !old {
+		slog.Warn("[x-timing-wheels hi-res] x-timing-wheels has been shutdown!")
+		return
+	}
+	close(xtw.stopC)
+	xtw.gPool.Release()
+}
+
+// AddTask adds the task in ms precision, the AddTaskNs, AfterFunc and
+// ScheduleFunc are in ns precision.
+func (xtw *xHiResTimingWheels) AddTask(task Task) error {
+	if len(task.GetJobID()) <= 0 {
+		return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
+	}
+	if task.GetJob() == nil {
+		return infra.WrapErrorStack(ErrTimingWheelEmptyJob)
+	}
+	return xtw.addEntry(&hiResEntry{
+		task:  task,
+		expNs: task.GetExpiredMs() * int64(time.Millisecond),
+	})
+}
+
+func (xtw *xHiResTimingWheels) AddTaskNs(task Task, expiredNs int64) error {
+	entry, err := newHiResEntry(task, expiredNs)
+	if err != nil {
+		return err
+	}
+	return xtw.addEntry(entry)
+}
+
+// newHiResEntry replaces the task's expiredMs by the truncated expiredNs.
+func newHiResEntry(task Task, expiredNs int64) (*hiResEntry, error) {
+	if task == nil || len(task.GetJobID()) <= 0 {
+		return nil, infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
+	}
+	if task.GetJob() == nil {
+		return nil, infra.WrapErrorStack(ErrTimingWheelEmptyJob)
+	}
+	if expiredNs < int64(time.Millisecond) {
+		return nil, fmt.Errorf("[x-timing-wheels hi-res] job %s invalid expiration ns %d, %w",
+			task.GetJobID(), expiredNs, ErrTimingWheelTaskIsExpired)
+	}
+	if err := task.reschedule(expiredNs/int64(time.Millisecond), nil, 0); err != nil {
+		return nil, err
+	}
+	return &hiResEntry{task: task, expNs: expiredNs}, nil
+}
+
+func (xtw *xHiResTimingWheels) AfterFunc(delay time.Duration, fn Job) (Task, error) {
+	if delay.Nanoseconds() < xtw.tickNs {
+		return nil, fmt.Errorf("[x-timing-wheels hi-res] job's delay %s is less than tick %s, %w",
+			delay, time.Duration(xtw.tickNs), ErrTimingWheelTaskTooShortExpiration)
+	}
+	if fn == nil {
+		return nil, infra.WrapErrorStack(ErrTimingWheelEmptyJob)
+	}
+
+	expNs := xtw.nowNs() + delay.Nanoseconds()
+	task := NewOnceTask(
+		xtw.ctx,
+		JobID(fmt.Sprintf("%v", xtw.idGenerator())),
+		expNs/int64(time.Millisecond),
+		fn,
+	)
+	if err := xtw.addEntry(&hiResEntry{task: task, expNs: expNs}); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (xtw *xHiResTimingWheels) ScheduleFunc(schedFn func() Scheduler, fn Job) (Task, error) {
+	if schedFn == nil {
+		return nil, infra.WrapErrorStack(ErrTimingWheelUnknownScheduler)
+	}
+	if fn == nil {
+		return nil, infra.WrapErrorStack(ErrTimingWheelEmptyJob)
+	}
+	sched := schedFn()
+	if sched == nil {
+		return nil, infra.WrapErrorStack(ErrTimingWheelUnknownScheduler)
+	}
+
+	// The expiration is calculated in ns instead of the NewRepeatTask.
+	nowNs := xtw.nowNs()
+	expNs := schedulerNextNs(sched, nowNs)
+	if expNs < 0 {
+		return nil, infra.WrapErrorStack(ErrTimingWheelUnknownScheduler)
+	}
+	task := newXScheduledTask(
+		xtw.ctx,
+		JobID(fmt.Sprintf("%v", xtw.idGenerator())),
+		"",
+		expNs/int64(time.Millisecond),
+		sched,
+		errJobAdapter(fn),
+	)
+	task.expirationMs = expNs / int64(time.Millisecond)
+	if err := xtw.addEntry(&hiResEntry{task: task, expNs: expNs}); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (xtw *xHiResTimingWheels) CancelTask(jobID JobID) error {
+	if len(jobID) <= 0 {
+		return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
+	}
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	if !xtw.cancel(jobID) {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	return nil
+}
+
+// AddTasks adds the tasks with the lock held once.
+func (xtw *xHiResTimingWheels) AddTasks(tasks []Task) error {
+	entries := make([]*hiResEntry, 0, len(tasks))
+	for _, task := range tasks {
+		if task == nil || len(task.GetJobID()) <= 0 {
+			return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
+		}
+		if task.GetJob() == nil {
+			return infra.WrapErrorStack(ErrTimingWheelEmptyJob)
+		}
+		entries = append(entries, &hiResEntry{
+			task:  task,
+			expNs: task.GetExpiredMs() * int64(time.Millisecond),
+		})
+	}
+	return xtw.addEntries(entries)
+}
+
+func (xtw *xHiResTimingWheels) AddTasksNs(tasks []HiResTask) error {
+	entries := make([]*hiResEntry, 0, len(tasks))
+	for _, task := range tasks {
+		entry, err := newHiResEntry(task.Task, task.ExpiredNs)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	return xtw.addEntries(entries)
+}
+
+func (xtw *xHiResTimingWheels) addEntries(entries []*hiResEntry) error {
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	for _, entry := range entries {
+		xtw.put(entry)
+	}
+	return nil
+}
+
+func (xtw *xHiResTimingWheels) CancelTasks(jobIDs []JobID) error {
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	for _, jobID := range jobIDs {
+		xtw.cancel(jobID)
+	}
+	return nil
+}
+
+func (xtw *xHiResTimingWheels) CancelTasksByPrefix(prefix string) (int, error) {
+	if !xtw.isRunning.Load() {
+		return 0, infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	count := 0
+	for jobID := range xtw.entries {
+		if strings.HasPrefix(string(jobID), prefix) && xtw.cancel(jobID) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// cancel returns false if the task is not found.
+// It has to be called with the lock.
+func (xtw *xHiResTimingWheels) cancel(jobID JobID) bool {
+	entry, ok := xtw.entries[jobID]
+	if !ok {
+		return false
+	}
+	xtw.removeFromBucket(entry)
+	delete(xtw.entries, jobID)
+	entry.task.Cancel()
+	return true
+}
+
+func (xtw *xHiResTimingWheels) PauseTask(jobID JobID) error {
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	entry, ok := xtw.entries[jobID]
+	if !ok {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	if entry.task.Paused() {
+		return nil
+	}
+	// The firing task is parked once it comes back.
+	entry.task.pause(xtw.removeFromBucket(entry))
+	return nil
+}
+
+func (xtw *xHiResTimingWheels) ResumeTask(jobID JobID) error {
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	xtw.lock.Lock()
+	entry, ok := xtw.entries[jobID]
+	if !ok {
+		xtw.lock.Unlock()
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	if entry.task.resume() {
+		xtw.addOrDue(entry)
+	}
+	xtw.lock.Unlock()
+	return nil
+}
+
+// RescheduleTask moves the task in ms precision. The next expirations of
+// the repeat task are calculated by the scheduler in ns precision.
+func (xtw *xHiResTimingWheels) RescheduleTask(jobID JobID, expiredMs int64, scheduler Scheduler) error {
+	if expiredMs <= 0 && scheduler == nil {
+		return infra.WrapErrorStack(ErrTimingWheelInvalidReschedule)
+	}
+	if expiredMs <= 0 {
+		return xtw.RescheduleTaskNs(jobID, 0, scheduler)
+	}
+	return xtw.RescheduleTaskNs(jobID, expiredMs*int64(time.Millisecond), scheduler)
+}
+
+func (xtw *xHiResTimingWheels) RescheduleTaskNs(jobID JobID, expiredNs int64, scheduler Scheduler) error {
+	if expiredNs <= 0 && scheduler == nil {
+		return infra.WrapErrorStack(ErrTimingWheelInvalidReschedule)
+	}
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	entry, ok := xtw.entries[jobID]
+	if !ok {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	removed := xtw.removeFromBucket(entry)
+	err := xtw.reschedule(entry, expiredNs, scheduler)
+	if removed || entry.due {
+		xtw.addOrDue(entry)
+	}
+	return err
+}
+
+// reschedule moves the entry to the expiredNs, or the next ns of the
+// scheduler from now if the expiredNs is not positive.
+// It has to be called with the lock.
+func (xtw *xHiResTimingWheels) reschedule(entry *hiResEntry, expiredNs int64, scheduler Scheduler) error {
+	nowNs := xtw.nowNs()
+	if sTask, ok := entry.task.(*xScheduledTask); ok && expiredNs <= 0 && scheduler != nil {
+		nextNs, err := sTask.rescheduleNs(scheduler, nowNs)
+		if err != nil {
+			return err
+		}
+		entry.expNs = nextNs
+		return nil
+	}
+	if err := entry.task.reschedule(expiredNs/int64(time.Millisecond), scheduler, nowNs/int64(time.Millisecond)); err != nil {
+		return err
+	}
+	entry.expNs = expiredNs
+	return nil
+}
+
+func (xtw *xHiResTimingWheels) GetTask(jobID JobID) (TaskInfo, error) {
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	entry, ok := xtw.entries[jobID]
+	if !ok {
+		return TaskInfo{}, infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	return entry.info(), nil
+}
+
+func (xtw *xHiResTimingWheels) ListTasks(filter TaskFilter) []TaskInfo {
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	infos := make([]TaskInfo, 0, len(xtw.entries))
+	for _, entry := range xtw.entries {
+		if info := entry.info(); filter == nil || filter(info) {
+			infos = append(infos, info)
+		}
+	}
+	return sortTaskInfos(infos)
+}
+
+// Snapshot returns the occupancy in ms, the sub-ms tick and interval of
+// the levels are truncated to 0.
+func (xtw *xHiResTimingWheels) Snapshot() TimingWheelsSnapshot {
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	snapshot := TimingWheelsSnapshot{
+		Name:       xtw.name,
+		StartMs:    xtw.GetStartMs(),
+		TaskCount:  len(xtw.entries),
+		NextFireMs: -1,
+		Levels:     make([]TimingWheelLevelSnapshot, 0, len(xtw.levels)),
+	}
+	for i, lvl := range xtw.levels {
+		levelSnapshot := TimingWheelLevelSnapshot{
+			Level:         int64(i),
+			TickMs:        lvl.tickNs / int64(time.Millisecond),
+			Interval:      lvl.intervalNs / int64(time.Millisecond),
+			CurrentTimeMs: lvl.currentNs / int64(time.Millisecond),
+			SlotSize:      int64(len(lvl.buckets)),
+			Slots:         make([]TimingWheelSlotSnapshot, 0, 8),
+		}
+		for _, bucket := range lvl.buckets {
+			if len(bucket.entries) <= 0 {
+				continue
+			}
+			slotSnapshot := TimingWheelSlotSnapshot{
+				SlotID:       bucket.slotID,
+				ExpirationMs: bucket.expNs / int64(time.Millisecond),
+				TaskCount:    int64(len(bucket.entries)),
+				NextFireMs:   -1,
+			}
+			for entry := range bucket.entries {
+				if expMs := entry.expNs / int64(time.Millisecond); slotSnapshot.NextFireMs < 0 || expMs < slotSnapshot.NextFireMs {
+					slotSnapshot.NextFireMs = expMs
+				}
+			}
+			if snapshot.NextFireMs < 0 || slotSnapshot.NextFireMs < snapshot.NextFireMs {
+				snapshot.NextFireMs = slotSnapshot.NextFireMs
+			}
+			levelSnapshot.TaskCount += slotSnapshot.TaskCount
+			levelSnapshot.Slots = append(levelSnapshot.Slots, slotSnapshot)
+		}
+		snapshot.Levels = append(snapshot.Levels, levelSnapshot)
+	}
+	return snapshot
+}
+
+func (entry *hiResEntry) info() TaskInfo {
+	info := TaskInfo{
+		JobID:         entry.task.GetJobID(),
+		JobName:       entry.task.GetJobName(),
+		JobType:       entry.task.GetJobType(),
+		ExpiredMs:     entry.expNs / int64(time.Millisecond),
+		RestLoopCount: entry.task.GetRestLoopCount(),
+		Paused:        entry.task.Paused(),
+		SlotLevel:     -1,
+		SlotID:        -1,
+	}
+	if entry.bucket != nil {
+		info.SlotLevel = entry.bucket.level
+		info.SlotID = entry.bucket.slotID
+	}
+	return info
+}
+
+func (xtw *xHiResTimingWheels) addEntry(entry *hiResEntry) error {
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	xtw.put(entry)
+	return nil
+}
+
+// put replaces the entry with the same jobID. The paused task is kept
+// out of the buckets.
+// It has to be called with the lock.
+func (xtw *xHiResTimingWheels) put(entry *hiResEntry) {
+	entry.task.setSlot(nil)
+	if prev, ok := xtw.entries[entry.task.GetJobID()]; ok {
+		xtw.removeFromBucket(prev)
+	}
+	xtw.entries[entry.task.GetJobID()] = entry
+	if !entry.task.park() {
+		xtw.addOrDue(entry)
+	}
+}
+
+// addOrDue queues the entry to be fired by the schedule goroutine if it
+// has been expired, so the callers are not blocked by the job's dispatch.
+// It has to be called with the lock.
+func (xtw *xHiResTimingWheels) addOrDue(entry *hiResEntry) {
+	if xtw.add(entry) || entry.due {
+		return
+	}
+	entry.due = true
+	xtw.dueEntries = append(xtw.dueEntries, entry)
+	select {
+	case xtw.wakeUpC <- struct{}{}:
+	default:
+	}
+}
+
+// add puts the entry into the bucket of the level by its expiration,
+// returns false if the entry has been expired.
+// It has to be called with the lock.
+func (xtw *xHiResTimingWheels) add(entry *hiResEntry) bool {
+	if entry.expNs < xtw.levels[0].currentNs+xtw.levels[0].tickNs {
+		return false
+	}
+	var (
+		lvl    *hiResLevel
+		expNs  = entry.expNs
+		levelN = len(xtw.levels)
+	)
+	for i := 0; i < levelN; i++ {
+		if lvl = xtw.levels[i]; expNs < lvl.currentNs+lvl.intervalNs {
+			break
+		}
+		if i == levelN-1 {
+			// Out of the top level's interval, waits in the last slot.
+			expNs = lvl.currentNs + lvl.intervalNs - lvl.tickNs
+		}
+	}
+	bucket := lvl.buckets[(expNs/lvl.tickNs)%int64(len(lvl.buckets))]
+	bucket.entries[entry] = struct{}{}
+	entry.bucket = bucket
+	if !bucket.queued {
+		bucket.queued = true
+		bucket.expNs = expNs - expNs%lvl.tickNs
+		xtw.bucketQ.Push(queue.NewPriorityQueueItem(bucket, bucket.expNs))
+		select {
+		case xtw.wakeUpC <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// removeFromBucket returns false if the entry is not in any bucket.
+// It has to be called with the lock.
+func (xtw *xHiResTimingWheels) removeFromBucket(entry *hiResEntry) bool {
+	if entry.bucket == nil {
+		return false
+	}
+	delete(entry.bucket.entries, entry)
+	entry.bucket = nil
+	return true
+}
+
+// advance moves the current time of all levels to the expNs.
+// It has to be called with the lock.
+func (xtw *xHiResTimingWheels) advance(expNs int64) {
+	for _, lvl := range xtw.levels {
+		if expNs >= lvl.currentNs+lvl.tickNs {
+			lvl.currentNs = expNs - expNs%lvl.tickNs
+		}
+	}
+}
+
+// flush pops all the expired buckets, and moves their entries down to
+// the lower levels. The expired entries are returned, including the due
+// entries still in the timing wheels.
+func (xtw *xHiResTimingWheels) flush(nowNs int64) (expired []*hiResEntry, waitNs int64) {
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	for _, entry := range xtw.dueEntries {
+		entry.due = false
+		// Cancelled, replaced or rescheduled after it has been due.
+		if cur, ok := xtw.entries[entry.task.GetJobID()]; ok && cur == entry && entry.bucket == nil {
+			expired = append(expired, entry)
+		}
+	}
+	clear(xtw.dueEntries)
+	xtw.dueEntries = xtw.dueEntries[:0]
+	for xtw.bucketQ.Len() > 0 {
+		if expNs := xtw.bucketQ.Peek().Priority(); expNs > nowNs {
+			return expired, expNs - nowNs
+		}
+		bucket := xtw.bucketQ.Pop().Value()
+		bucket.queued = false
+		xtw.advance(bucket.expNs)
+		for entry := range bucket.entries {
+			delete(bucket.entries, entry)
+			entry.bucket = nil
+			if !xtw.add(entry) {
+				expired = append(expired, entry)
+			}
+		}
+	}
+	return expired, -1
+}
+
+// fire runs the job of the expired entry, and adds the repeat task back
+// by its next expiration.
+func (xtw *xHiResTimingWheels) fire(entry *hiResEntry) {
+	for entry != nil {
+		entry = xtw.fireOnce(entry)
+	}
+}
+
+// fireOnce returns the entry if it is expired again.
+func (xtw *xHiResTimingWheels) fireOnce(entry *hiResEntry) *hiResEntry {
+	t := entry.task
+	if t.Cancelled() || t.park() {
+		return nil
+	}
+	nowNs := xtw.nowNs()
+	baseNs, fire := entry.expNs, true
+	if opt := t.getJobExecOption(); t.GetJobType() == RepeatedJob &&
+		opt.misfire != MisfireFireAllMissed && opt.misfireThresholdMs > 0 &&
+		nowNs-entry.expNs > opt.misfireThresholdMs*int64(time.Millisecond) {
+		baseNs, fire = nowNs, opt.misfire == MisfireFireOnceNow
+	}
+	if fire {
+		job := jobExecWrapper(xtw.jobExecOpt.override(t.getJobExecOption()), t.getErrJob())
+		submitJob(xtw.gPool, xtw.ctx, t, dlockJobWrapper(xtw.jobDLockOpt, job))
+	}
+
+	xtw.lock.Lock()
+	defer xtw.lock.Unlock()
+	if cur, ok := xtw.entries[t.GetJobID()]; !ok || cur != entry {
+		// Cancelled or replaced.
+		return nil
+	}
+	sTask, ok := t.(*xScheduledTask)
+	if !ok || t.GetRestLoopCount() == 0 {
+		delete(xtw.entries, t.GetJobID())
+		return nil
+	}
+	nextNs := sTask.nextScheduledNs(baseNs)
+	if nextNs < 0 {
+		delete(xtw.entries, t.GetJobID())
+		return nil
+	}
+	entry.expNs = nextNs
+	atomic.StoreInt64(&sTask.expirationMs, nextNs/int64(time.Millisecond))
+	if t.park() || xtw.add(entry) {
+		return nil
+	}
+	return entry
+}
+
+func (xtw *xHiResTimingWheels) releaseTimerHolds(holds int64) {
+	for ; holds > 0; holds-- {
+		hrtime.Release(xtw.clock)
+	}
+}
+
+// schedule flushes the expired buckets, and sleeps until the earliest
+// bucket expired or a new bucket is queued.
+func (xtw *xHiResTimingWheels) schedule() {
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Error("[x-timing-wheels hi-res] schedule panic recover", "error", err, "stack", debug.Stack())
+		}
+	}()
+	defer func() {
+		xtw.releaseTimerHolds(xtw.timerHolds.Swap(0))
+	}()
+	for {
+		holds := xtw.timerHolds.Swap(0)
+		expired, waitNs := xtw.flush(xtw.nowNs())
+		for _, entry := range expired {
+			xtw.fire(entry)
+		}
+		xtw.releaseTimerHolds(holds)
+		var timer hrtime.Timer
+		if waitNs > 0 {
+			timer = hrtime.AfterFunc(xtw.clock, time.Duration(waitNs), func() {
+				if !xtw.isRunning.Load() {
+					return
+				}
+				// The hrtime.FakeClock.Advance waits until the expired
+				// buckets fired.
+				hrtime.Hold(xtw.clock)
+				xtw.timerHolds.Add(1)
+				select {
+				case xtw.wakeUpC <- struct{}{}:
+				default:
+				}
+			})
+		}
+		select {
+		case <-xtw.ctx.Done():
+			xtw.Shutdown()
+			return
+		case <-xtw.stopC:
+			return
+		case <-xtw.wakeUpC:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// NewXHiResTimingWheels creates the hi-res timing wheels in ns units,
+// e.g. 100µs precision for the network retransmit timers.
+// The tick and levels are set by the WithTimingWheelsHiResTick and
+// WithTimingWheelsHiResLevels, the ms tick and slot size options are
+// ignored. The task store and stats are not supported.
+// The clock must be in ns precision, e.g. the SdkClock (default).
+func NewXHiResTimingWheels(ctx context.Context, opts ...TimingWheelsOption) HiResTimingWheels {
+	if ctx == nil {
+		return nil
+	}
+
+	xtwOpt := &xTimingWheelsOption{}
+	for _, o := range opts {
+		if o != nil {
+			o(xtwOpt)
+		}
+	}
+	xtwOpt.Validate()
+
+	xtw := &xHiResTimingWheels{
+		ctx:         ctx,
+		bucketQ:     queue.NewArrayPriorityQueue[*hiResBucket](),
+		entries:     make(map[JobID]*hiResEntry),
+		wakeUpC:     make(chan struct{}, 1),
+		stopC:       make(chan struct{}),
+		isRunning:   &atomic.Bool{},
+		clock:       xtwOpt.getClock(),
+		idGenerator: xtwOpt.getIDGenerator(),
+		jobDLockOpt: xtwOpt.getJobDLockOption(),
+		jobExecOpt:  xtwOpt.getJobExecOption(),
+		name:        xtwOpt.getName(),
+		tickNs:      xtwOpt.getHiResTick().Nanoseconds(),
+	}
+	xtw.startNs = xtw.nowNs()
+	tickNs := xtw.tickNs
+	for i, size := range xtwOpt.getHiResSlotSizes() {
+		if tickNs > math.MaxInt64/size {
+			// The rest levels overflow.
+			break
+		}
+		lvl := &hiResLevel{
+			tickNs:     tickNs,
+			intervalNs: tickNs * size,
+			currentNs:  xtw.startNs - xtw.startNs%tickNs,
+			buckets:    make([]*hiResBucket, size),
+		}
+		for j := range lvl.buckets {
+			lvl.buckets[j] = &hiResBucket{
+				level:   int64(i),
+				slotID:  int64(j),
+				entries: make(map[*hiResEntry]struct{}),
+			}
+		}
+		xtw.levels = append(xtw.levels, lvl)
+		tickNs = lvl.intervalNs
+	}
+	if p, err := ants.NewPool(xtwOpt.getWorkerPoolSize(), ants.WithPreAlloc(true)); err != nil {
+		panic(err)
+	} else {
+		xtw.gPool = p
+	}
+	xtw.isRunning.Store(true)
+	go xtw.schedule()
+	return xtw
+}
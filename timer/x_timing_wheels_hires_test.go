@@ -0,0 +1,269 @@
+package timer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/benz9527/xboot/lib/hrtime"
+)
+
+func TestXHiResTimingWheels_SubMs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXHiResTimingWheels(ctx, WithTimingWheelsHiResTick(50*time.Microsecond))
+	defer tw.Shutdown()
+
+	_, err := tw.AfterFunc(10*time.Microsecond, func(ctx context.Context, md JobMetadata) {})
+	require.ErrorIs(t, err, ErrTimingWheelTaskTooShortExpiration)
+
+	firedC := make(chan time.Time, 1)
+	beginAt := time.Now()
+	_, err = tw.AfterFunc(500*time.Microsecond, func(ctx context.Context, md JobMetadata) {
+		firedC <- time.Now()
+	})
+	require.NoError(t, err)
+	select {
+	case firedAt := <-firedC:
+		elapsed := firedAt.Sub(beginAt)
+		require.GreaterOrEqual(t, elapsed, 400*time.Microsecond)
+		require.Less(t, elapsed, 20*time.Millisecond)
+	case <-ctx.Done():
+		t.Fatal("sub-ms task is not fired")
+	}
+
+	var count atomic.Int64
+	task, err := tw.ScheduleFunc(func() Scheduler {
+		return NewHiResInfiniteScheduler(200 * time.Microsecond)
+	}, func(ctx context.Context, md JobMetadata) {
+		count.Add(1)
+	})
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, tw.CancelTask(task.GetJobID()))
+	require.GreaterOrEqual(t, count.Load(), int64(100))
+}
+
+func TestXHiResTimingWheels_FakeClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	clock := hrtime.NewFakeClock(time.Now().Truncate(time.Millisecond))
+	tw := NewXHiResTimingWheels(ctx,
+		WithTimingWheelsClock(clock),
+		WithTimingWheelsHiResTick(100*time.Microsecond),
+		WithTimingWheelsHiResLevels(8, 8, 8),
+	)
+	defer tw.Shutdown()
+
+	var onceCount, repeatCount atomic.Int64
+	// Out of the top level's interval (51.2ms).
+	_, err := tw.AfterFunc(time.Second, func(ctx context.Context, md JobMetadata) {
+		onceCount.Add(1)
+	})
+	require.NoError(t, err)
+	repeat, err := tw.ScheduleFunc(func() Scheduler {
+		return NewHiResInfiniteScheduler(250 * time.Microsecond)
+	}, func(ctx context.Context, md JobMetadata) {
+		repeatCount.Add(1)
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return clock.PendingTimers() == 1
+	}, time.Second, time.Millisecond)
+
+	for i := int64(1); i <= 3; i++ {
+		clock.Advance(time.Millisecond)
+		require.Eventually(t, func() bool {
+			return repeatCount.Load() == 4*i
+		}, time.Second, time.Millisecond)
+	}
+	require.NoError(t, tw.CancelTask(repeat.GetJobID()))
+
+	// The Advance returns once the expired buckets have been fired.
+	clock.Advance(time.Second - 3*time.Millisecond - time.Microsecond)
+	require.Equal(t, int64(0), onceCount.Load())
+	clock.Advance(time.Microsecond)
+	require.Eventually(t, func() bool {
+		return onceCount.Load() == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int64(12), repeatCount.Load())
+	require.Empty(t, tw.ListTasks(nil))
+}
+
+func TestXHiResTimingWheels_PauseResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	clock := hrtime.NewFakeClock(time.Now().Truncate(time.Millisecond))
+	tw := NewXHiResTimingWheels(ctx,
+		WithTimingWheelsClock(clock),
+		WithTimingWheelsHiResTick(time.Millisecond),
+		WithTimingWheelsHiResLevels(16, 16),
+	)
+	defer tw.Shutdown()
+
+	var pausedCount, cancelledCount atomic.Int64
+	paused, err := tw.AfterFunc(10*time.Millisecond, func(ctx context.Context, md JobMetadata) {
+		pausedCount.Add(1)
+	})
+	require.NoError(t, err)
+	cancelled, err := tw.AfterFunc(100*time.Millisecond, func(ctx context.Context, md JobMetadata) {
+		cancelledCount.Add(1)
+	})
+	require.NoError(t, err)
+
+	snapshot := tw.Snapshot()
+	require.Len(t, snapshot.Levels, 2)
+	require.Equal(t, 2, snapshot.TaskCount)
+	require.Equal(t, int64(1), snapshot.Levels[0].TaskCount)
+	require.Equal(t, int64(1), snapshot.Levels[1].TaskCount)
+	require.Equal(t, paused.GetExpiredMs(), snapshot.NextFireMs)
+	infos := tw.ListTasks(nil)
+	require.Len(t, infos, 2)
+	require.Equal(t, paused.GetJobID(), infos[0].JobID)
+
+	require.NoError(t, tw.PauseTask(paused.GetJobID()))
+	require.NoError(t, tw.CancelTask(cancelled.GetJobID()))
+	require.ErrorIs(t, tw.CancelTask(cancelled.GetJobID()), ErrTimingWheelTaskNotFound)
+	clock.Advance(200 * time.Millisecond)
+	require.Equal(t, int64(0), pausedCount.Load())
+	require.Equal(t, int64(0), cancelledCount.Load())
+	info, err := tw.GetTask(paused.GetJobID())
+	require.NoError(t, err)
+	require.True(t, info.Paused)
+	require.Equal(t, int64(-1), info.SlotLevel)
+
+	// Expired while paused, fired once resumed.
+	require.NoError(t, tw.ResumeTask(paused.GetJobID()))
+	require.Eventually(t, func() bool {
+		return pausedCount.Load() == 1
+	}, time.Second, time.Millisecond)
+	_, err = tw.GetTask(paused.GetJobID())
+	require.ErrorIs(t, err, ErrTimingWheelTaskNotFound)
+}
+
+func TestXHiResTimingWheels_Batch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXHiResTimingWheels(ctx)
+	defer tw.Shutdown()
+	testTimingWheelsBatch(t, tw)
+}
+
+func TestXHiResTimingWheels_Ns(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	clock := hrtime.NewFakeClock(time.Now().Truncate(time.Millisecond))
+	tw := NewXHiResTimingWheels(ctx,
+		WithTimingWheelsClock(clock),
+		WithTimingWheelsHiResTick(100*time.Microsecond),
+		WithTimingWheelsHiResLevels(16, 16, 16),
+	)
+	defer tw.Shutdown()
+
+	var onceCount, batchCount, repeatCount atomic.Int64
+	nowNs := clock.NowInDefaultTZ().UnixNano()
+	once := NewOnceTask(ctx, "once", 1, func(ctx context.Context, md JobMetadata) {
+		onceCount.Add(1)
+	})
+	require.ErrorIs(t, tw.AddTaskNs(once, 0), ErrTimingWheelTaskIsExpired)
+	require.NoError(t, tw.AddTaskNs(once, nowNs+int64(500*time.Microsecond)))
+	require.Equal(t, nowNs/int64(time.Millisecond), once.GetExpiredMs())
+	require.NoError(t, tw.AddTasksNs([]HiResTask{
+		{
+			Task: NewOnceTask(ctx, "batch-1", 1, func(ctx context.Context, md JobMetadata) {
+				batchCount.Add(1)
+			}),
+			ExpiredNs: nowNs + int64(300*time.Microsecond),
+		},
+		{
+			Task: NewOnceTask(ctx, "batch-2", 1, func(ctx context.Context, md JobMetadata) {
+				batchCount.Add(1)
+			}),
+			ExpiredNs: nowNs + int64(700*time.Microsecond),
+		},
+	}))
+	repeat, err := tw.ScheduleFunc(func() Scheduler {
+		return NewHiResInfiniteScheduler(time.Second)
+	}, func(ctx context.Context, md JobMetadata) {
+		repeatCount.Add(1)
+	})
+	require.NoError(t, err)
+	require.NoError(t, tw.RescheduleTaskNs(repeat.GetJobID(), 0, NewHiResInfiniteScheduler(400*time.Microsecond)))
+
+	clock.Advance(300 * time.Microsecond)
+	require.Eventually(t, func() bool {
+		return batchCount.Load() == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int64(0), onceCount.Load())
+	clock.Advance(100 * time.Microsecond)
+	require.Eventually(t, func() bool {
+		return repeatCount.Load() == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int64(0), onceCount.Load())
+	clock.Advance(100 * time.Microsecond)
+	require.Eventually(t, func() bool {
+		return onceCount.Load() == 1
+	}, time.Second, time.Millisecond)
+	clock.Advance(200 * time.Microsecond)
+	require.Eventually(t, func() bool {
+		return batchCount.Load() == 2
+	}, time.Second, time.Millisecond)
+
+	// Moved to the sub-ms expiration.
+	require.NoError(t, tw.RescheduleTaskNs(repeat.GetJobID(), clock.NowInDefaultTZ().UnixNano()+int64(200*time.Microsecond), nil))
+	clock.Advance(100 * time.Microsecond)
+	require.Equal(t, int64(1), repeatCount.Load())
+	clock.Advance(100 * time.Microsecond)
+	require.Eventually(t, func() bool {
+		return repeatCount.Load() == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestXHiResTimingWheels_ExpiredNotBlockCaller(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXHiResTimingWheels(ctx, WithTimingWheelsWorkerPoolSize(defaultMinWorkerPoolSize))
+	defer tw.Shutdown()
+
+	var running atomic.Int64
+	releaseC := make(chan struct{})
+	expiredMs := time.Now().UnixMilli() - 1
+	blockers := make([]Task, 0, defaultMinWorkerPoolSize)
+	for i := 0; i < defaultMinWorkerPoolSize; i++ {
+		blockers = append(blockers, NewOnceTask(ctx, JobID(fmt.Sprintf("blocker-%d", i)), expiredMs,
+			func(ctx context.Context, md JobMetadata) {
+				running.Add(1)
+				<-releaseC
+			},
+		))
+	}
+	require.NoError(t, tw.AddTasks(blockers))
+	require.Eventually(t, func() bool {
+		return running.Load() == defaultMinWorkerPoolSize
+	}, time.Second, time.Millisecond)
+
+	// All the workers are busy, the caller is not blocked by the dispatch.
+	firedC := make(chan struct{})
+	addedC := make(chan error, 1)
+	go func() {
+		addedC <- tw.AddTask(NewOnceTask(ctx, "expired", expiredMs, func(ctx context.Context, md JobMetadata) {
+			close(firedC)
+		}))
+	}()
+	select {
+	case err := <-addedC:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("caller is blocked by the expired task")
+	}
+	close(releaseC)
+	select {
+	case <-firedC:
+	case <-ctx.Done():
+		t.Fatal("expired task is not fired")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -193,6 +194,7 @@ type xTimingWheels struct {
 	twEventPool  *timingWheelEventsPool
 	gPool        *ants.Pool
 	stats        *xTimingWheelsStats
+	jobExecOpt   jobExecOption
 	isRunning    *atomic.Bool
 	clock        hrtime.Clock
 	idGenerator  id.Gen
@@ -235,6 +237,9 @@ func (xtw *xTimingWheels) AddTask(task Task) error {
 	if task.GetJob() == nil {
 		return ErrTimingWheelEmptyJob
 	}
+	if err := checkMsTask(task); err != nil {
+		return err
+	}
 	if !xtw.isRunning.Load() {
 		return ErrTimingWheelStopped
 	}
@@ -299,7 +304,7 @@ func (xtw *xTimingWheels) CancelTask(jobID JobID) error {
 		return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
 	}
 
-	if xtw.isRunning.Load() {
+	if !xtw.isRunning.Load() {
 		return infra.WrapErrorStack(ErrTimingWheelStopped)
 	}
 	task, ok := xtw.tasksMap.Get(jobID)
@@ -312,6 +317,137 @@ func (xtw *xTimingWheels) CancelTask(jobID JobID) error {
 	return xtw.twEventC.Send(event)
 }
 
+// AddTasks sends the tasks in a single event.
+func (xtw *xTimingWheels) AddTasks(tasks []Task) error {
+	for _, task := range tasks {
+		if task == nil || len(task.GetJobID()) <= 0 {
+			return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
+		}
+		if task.GetJob() == nil {
+			return infra.WrapErrorStack(ErrTimingWheelEmptyJob)
+		}
+		if err := checkMsTask(task); err != nil {
+			return err
+		}
+	}
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	if len(tasks) <= 0 {
+		return nil
+	}
+	event := xtw.twEventPool.Get()
+	event.AddTasks(tasks)
+	return xtw.twEventC.Send(event)
+}
+
+func (xtw *xTimingWheels) CancelTasks(jobIDs []JobID) error {
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	existed := make([]JobID, 0, len(jobIDs))
+	for _, jobID := range jobIDs {
+		if _, ok := xtw.tasksMap.Get(jobID); ok {
+			existed = append(existed, jobID)
+		}
+	}
+	return xtw.sendCancelTasks(existed)
+}
+
+func (xtw *xTimingWheels) CancelTasksByPrefix(prefix string) (int, error) {
+	if !xtw.isRunning.Load() {
+		return 0, infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	jobIDs := xtw.tasksMap.ListKeys(func(jobID JobID) bool {
+		return strings.HasPrefix(string(jobID), prefix)
+	})
+	if err := xtw.sendCancelTasks(jobIDs); err != nil {
+		return 0, err
+	}
+	return len(jobIDs), nil
+}
+
+func (xtw *xTimingWheels) sendCancelTasks(jobIDs []JobID) error {
+	if len(jobIDs) <= 0 {
+		return nil
+	}
+	event := xtw.twEventPool.Get()
+	event.CancelTasksJobIDs(jobIDs)
+	return xtw.twEventC.Send(event)
+}
+
+func (xtw *xTimingWheels) PauseTask(jobID JobID) error {
+	return xtw.sendTaskEvent(jobID, func(event *timingWheelEvent) {
+		event.PauseTaskJobID(jobID)
+	})
+}
+
+func (xtw *xTimingWheels) ResumeTask(jobID JobID) error {
+	return xtw.sendTaskEvent(jobID, func(event *timingWheelEvent) {
+		event.ResumeTaskJobID(jobID)
+	})
+}
+
+func (xtw *xTimingWheels) RescheduleTask(jobID JobID, expiredMs int64, scheduler Scheduler) error {
+	if expiredMs <= 0 && scheduler == nil {
+		return infra.WrapErrorStack(ErrTimingWheelInvalidReschedule)
+	}
+	if err := checkMsScheduler(jobID, scheduler); err != nil {
+		return err
+	}
+	return xtw.sendTaskEvent(jobID, func(event *timingWheelEvent) {
+		event.RescheduleTask(jobID, expiredMs, scheduler)
+	})
+}
+
+func (xtw *xTimingWheels) GetTask(jobID JobID) (TaskInfo, error) {
+	task, ok := xtw.tasksMap.Get(jobID)
+	if !ok {
+		return TaskInfo{}, infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	return newTaskInfo(task), nil
+}
+
+func (xtw *xTimingWheels) ListTasks(filter TaskFilter) []TaskInfo {
+	return listTaskInfos(xtw.tasksMap.ListValues(), filter)
+}
+
+// Snapshot is taken in the event loop, because the slots are
+// modified by the event loop without lock.
+func (xtw *xTimingWheels) Snapshot() TimingWheelsSnapshot {
+	if !xtw.isRunning.Load() {
+		return newTimingWheelsSnapshot(xtw.name, xtw.tw, len(xtw.tasksMap.ListKeys()))
+	}
+	snapshotC := make(chan TimingWheelsSnapshot, 1)
+	event := xtw.twEventPool.Get()
+	event.SnapshotC(snapshotC)
+	if err := xtw.twEventC.Send(event); err != nil {
+		return newTimingWheelsSnapshot(xtw.name, xtw.tw, len(xtw.tasksMap.ListKeys()))
+	}
+	select {
+	case snapshot := <-snapshotC:
+		return snapshot
+	case <-xtw.stopC:
+		return newTimingWheelsSnapshot(xtw.name, xtw.tw, len(xtw.tasksMap.ListKeys()))
+	}
+}
+
+// sendTaskEvent sends the event of the existed task.
+func (xtw *xTimingWheels) sendTaskEvent(jobID JobID, setup func(event *timingWheelEvent)) error {
+	if len(jobID) <= 0 {
+		return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
+	}
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	if _, ok := xtw.tasksMap.Get(jobID); !ok {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	event := xtw.twEventPool.Get()
+	setup(event)
+	return xtw.twEventC.Send(event)
+}
+
 func (xtw *xTimingWheels) schedule(ctx context.Context) {
 	if ctx == nil {
 		return
@@ -373,6 +509,25 @@ func (xtw *xTimingWheels) schedule(ctx context.Context) {
 					if op == addTask {
 						xtw.stats.RecordJobAliveCount(1)
 					}
+				case addTasks:
+					tasks, ok := event.GetTasks()
+					if !ok {
+						goto recycle
+					}
+					for _, task := range tasks {
+						if err := xtw.addTask(task); errors.Is(err, ErrTimingWheelTaskIsExpired) {
+							xtw.handleTask(task)
+						}
+					}
+					xtw.stats.RecordJobAliveCount(int64(len(tasks)))
+				case cancelTasks:
+					jobIDs, ok := event.GetCancelTasksJobIDs()
+					if !ok || cancelDisabled.(bool) {
+						goto recycle
+					}
+					for _, jobID := range jobIDs {
+						_ = xtw.cancelTask(jobID)
+					}
 				case cancelTask:
 					jobID, ok := event.GetCancelTaskJobID()
 					if !ok || cancelDisabled.(bool) {
@@ -380,6 +535,30 @@ func (xtw *xTimingWheels) schedule(ctx context.Context) {
 					}
 					// Avoid data race
 					_ = xtw.cancelTask(jobID)
+				case pauseTask, resumeTask:
+					jobID, ok := event.GetTaskJobID()
+					if !ok {
+						goto recycle
+					}
+					if op == pauseTask {
+						xtw.pauseTask(jobID)
+					} else {
+						xtw.resumeTask(jobID)
+					}
+				case snapshotTasks:
+					snapshotC, ok := event.GetSnapshotC()
+					if !ok {
+						goto recycle
+					}
+					snapshotC <- newTimingWheelsSnapshot(xtw.name, xtw.tw, len(xtw.tasksMap.ListKeys()))
+				case rescheduleTask:
+					args, ok := event.GetRescheduleTaskArgs()
+					if !ok {
+						goto recycle
+					}
+					if err := xtw.rescheduleTask(args); err != nil {
+						slog.Warn("[x-timing-wheels] reschedule task failed", "job", args.jobID, "error", err)
+					}
 				case unknown:
 					fallthrough
 				default:
@@ -420,6 +599,12 @@ func (xtw *xTimingWheels) addTask(task Task) error {
 	if task == nil || task.Cancelled() || !xtw.isRunning.Load() {
 		return ErrTimingWheelStopped
 	}
+	if task.park() {
+		// Keeps the paused task out of the timing wheels.
+		task.setSlot(nil)
+		xtw.tasksMap.AddOrUpdate(task.GetJobID(), task)
+		return nil
+	}
 	err := xtw.tw.(*timingWheel).addTask(task, 0)
 	if err == nil || errors.Is(err, ErrTimingWheelTaskIsExpired) {
 		xtw.tasksMap.AddOrUpdate(task.GetJobID(), task)
@@ -438,6 +623,10 @@ func (xtw *xTimingWheels) handleTask(t Task) {
 		)
 		return
 	}
+	if t.park() {
+		// Resumes by the resume event.
+		return
+	}
 
 	// [slotExpMs, slotExpMs+interval)
 	var (
@@ -456,14 +645,14 @@ func (xtw *xTimingWheels) handleTask(t Task) {
 		runNow = prevSlotMetadata.GetExpirationMs() == sentinelSlotExpiredMs
 		runNow = runNow || (taskLevel == 0 && t.GetExpiredMs() <= prevSlotMetadata.GetExpirationMs()+xtw.GetTickMs())
 	}
-	runNow = runNow || t.GetExpiredMs() <= xtw.clock.NowInDefaultTZ().UnixMilli()
+	nowMs := xtw.clock.NowInDefaultTZ().UnixMilli()
+	runNow = runNow || t.GetExpiredMs() <= nowMs
 
 	if runNow && !t.Cancelled() {
-		job := t.GetJob()
-		md := t.GetJobMetadata()
-		_ = xtw.gPool.Submit(func() {
-			jobStatsWrapper(xtw.stats, job)(xtw.ctx, md)
-		})
+		if t.misfire(nowMs) {
+			job := jobExecWrapper(xtw.jobExecOpt.override(t.getJobExecOption()), jobStatsWrapper(xtw.stats, t.getErrJob()))
+			submitJob(xtw.gPool, xtw.ctx, t, job)
+		}
 	} else if t.Cancelled() {
 		if slot != nil {
 			slot.RemoveTask(t)
@@ -525,7 +714,7 @@ func (xtw *xTimingWheels) cancelTask(jobID JobID) error {
 		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
 	}
 
-	if task.GetSlot() != nil && !task.GetSlot().RemoveTask(task) {
+	if slot := task.GetSlot(); slot != nil && slot != immediateExpiredSlot && !slot.RemoveTask(task) {
 		return infra.WrapErrorStack(ErrTimingWheelTaskUnableToBeRemoved)
 	}
 
@@ -540,6 +729,47 @@ func (xtw *xTimingWheels) cancelTask(jobID JobID) error {
 	return infra.WrapErrorStack(err)
 }
 
+// pauseTask removes the task from its slot. If the task is not in any
+// slot (e.g. the re-add event is pending), it will be parked once it
+// comes back.
+func (xtw *xTimingWheels) pauseTask(jobID JobID) {
+	task, ok := xtw.tasksMap.Get(jobID)
+	if !ok || task.Paused() {
+		return
+	}
+	slot := task.GetSlot()
+	task.pause(slot != nil && slot != immediateExpiredSlot && slot.RemoveTask(task))
+}
+
+func (xtw *xTimingWheels) resumeTask(jobID JobID) {
+	task, ok := xtw.tasksMap.Get(jobID)
+	if !ok || !task.Paused() || !task.resume() {
+		return
+	}
+	if err := xtw.addTask(task); errors.Is(err, ErrTimingWheelTaskIsExpired) {
+		xtw.handleTask(task)
+	}
+}
+
+// rescheduleTask moves the task to the new slot. If the task is not in
+// any slot, the new expiration takes effect once it comes back.
+// The paused task is still paused.
+func (xtw *xTimingWheels) rescheduleTask(args *rescheduleTaskArgs) error {
+	task, ok := xtw.tasksMap.Get(args.jobID)
+	if !ok {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	slot := task.GetSlot()
+	removed := !task.Paused() && slot != nil && slot != immediateExpiredSlot && slot.RemoveTask(task)
+	err := task.reschedule(args.expiredMs, args.scheduler, xtw.clock.NowInDefaultTZ().UnixMilli())
+	if removed {
+		if err := xtw.addTask(task); errors.Is(err, ErrTimingWheelTaskIsExpired) {
+			xtw.handleTask(task)
+		}
+	}
+	return err
+}
+
 // NewXTimingWheels creates a new timing wheel.
 // The same as the kafka, Time.SYSTEM.hiResClockMs() is used.
 func NewXTimingWheels(ctx context.Context, opts ...TimingWheelsOption) TimingWheels {
@@ -566,6 +796,7 @@ func NewXTimingWheels(ctx context.Context, opts ...TimingWheelsOption) TimingWhe
 		idGenerator:  xtwOpt.getIDGenerator(),
 		twEventPool:  newTimingWheelEventsPool(),
 		stats:        xtwOpt.getStats(),
+		jobExecOpt:   xtwOpt.getJobExecOption(),
 		name:         xtwOpt.getName(),
 	}
 	xtw.isRunning.Store(false)
@@ -574,7 +805,9 @@ func NewXTimingWheels(ctx context.Context, opts ...TimingWheelsOption) TimingWhe
 	} else {
 		xtw.gPool = p
 	}
-	xtw.dq = queue.NewArrayDelayQueue[TimingWheelSlot](ctx, xtwOpt.defaultDelayQueueCapacity())
+	xtw.dq = queue.NewArrayDelayQueue[TimingWheelSlot](ctx, xtwOpt.defaultDelayQueueCapacity(),
+		queue.WithArrayDelayQueueClock[TimingWheelSlot](xtw.clock),
+	)
 	xtw.tw = newTimingWheel(
 		ctx,
 		xtwOpt.getBasicTickMilliseconds(),
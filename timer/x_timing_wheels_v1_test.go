@@ -348,3 +348,27 @@ func TestXTimingWheels_AfterFunc_Slots(t *testing.T) {
 	}
 	<-ctx.Done()
 }
+
+func TestXTimingWheels_PauseResumeReschedule(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXTimingWheels(ctx)
+	defer tw.Shutdown()
+	testTimingWheelsPauseResumeReschedule(t, tw)
+}
+
+func TestXTimingWheels_CancelTask(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXTimingWheels(ctx)
+	defer tw.Shutdown()
+	testTimingWheelsCancelTask(t, tw)
+}
+
+func TestXTimingWheels_Introspection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXTimingWheels(ctx)
+	defer tw.Shutdown()
+	testTimingWheelsIntrospection(t, tw)
+}
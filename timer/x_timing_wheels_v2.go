@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -35,6 +36,13 @@ type xTimingWheelsV2 struct {
 	isRunning        *atomic.Bool
 	clock            hrtime.Clock
 	idGenerator      id.Gen
+	taskStore        TaskStore
+	jobs             map[string]registeredJob
+	dirtyRecords     map[JobID]TaskRecord // The progress of the repeat tasks.
+	dirtyLock        sync.Mutex
+	persistLock      sync.Mutex // Serializes the writes of the task store.
+	jobDLockOpt      jobDLockOption
+	jobExecOpt       jobExecOption
 	name             string
 	schedLock        sync.Mutex // Pay attention to the lock granularity
 	isStatsEnabled   bool
@@ -62,6 +70,7 @@ func (xtw *xTimingWheelsV2) Shutdown() {
 	_ = xtw.expiredSlotC.Close()
 	_ = xtw.twEventDisruptor.Stop()
 	xtw.gPool.Release()
+	xtw.flushTasks()
 
 	runtime.SetFinalizer(xtw, func(xtw *xTimingWheelsV2) {
 		xtw.dq = nil
@@ -76,9 +85,24 @@ func (xtw *xTimingWheelsV2) AddTask(task Task) error {
 	if task.GetJob() == nil {
 		return infra.WrapErrorStack(ErrTimingWheelEmptyJob)
 	}
+	if err := checkMsTask(task); err != nil {
+		return err
+	}
 	if !xtw.isRunning.Load() {
 		return infra.WrapErrorStack(ErrTimingWheelStopped)
 	}
+	if err := xtw.persistTasks(task); err != nil {
+		return err
+	}
+	if err := xtw.publishAddTask(task); err != nil {
+		// The task is not added, so is its record.
+		xtw.rollbackTasks(task.GetJobID())
+		return err
+	}
+	return nil
+}
+
+func (xtw *xTimingWheelsV2) publishAddTask(task Task) error {
 	event := xtw.twEventPool.Get()
 	event.AddTask(task)
 	_, _, err := xtw.twEventDisruptor.Publish(event)
@@ -141,7 +165,7 @@ func (xtw *xTimingWheelsV2) CancelTask(jobID JobID) error {
 		return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
 	}
 
-	if xtw.isRunning.Load() {
+	if !xtw.isRunning.Load() {
 		return infra.WrapErrorStack(ErrTimingWheelStopped)
 	}
 	task, ok := xtw.tasksMap.Get(jobID)
@@ -155,6 +179,160 @@ func (xtw *xTimingWheelsV2) CancelTask(jobID JobID) error {
 	return infra.WrapErrorStack(err)
 }
 
+// AddTasks publishes the tasks in a single event, the tasks are added
+// into the slots with the schedLock held once.
+func (xtw *xTimingWheelsV2) AddTasks(tasks []Task) error {
+	for _, task := range tasks {
+		if task == nil || len(task.GetJobID()) <= 0 {
+			return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
+		}
+		if task.GetJob() == nil {
+			return infra.WrapErrorStack(ErrTimingWheelEmptyJob)
+		}
+		if err := checkMsTask(task); err != nil {
+			return err
+		}
+	}
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	if len(tasks) <= 0 {
+		return nil
+	}
+	if err := xtw.persistTasks(tasks...); err != nil {
+		return err
+	}
+	event := xtw.twEventPool.Get()
+	event.AddTasks(tasks)
+	if _, _, err := xtw.twEventDisruptor.Publish(event); err != nil {
+		// None of the tasks is added, so are their records.
+		jobIDs := make([]JobID, 0, len(tasks))
+		for _, task := range tasks {
+			jobIDs = append(jobIDs, task.GetJobID())
+		}
+		xtw.rollbackTasks(jobIDs...)
+		return infra.WrapErrorStack(err)
+	}
+	return nil
+}
+
+func (xtw *xTimingWheelsV2) CancelTasks(jobIDs []JobID) error {
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	existed := make([]JobID, 0, len(jobIDs))
+	for _, jobID := range jobIDs {
+		if _, ok := xtw.tasksMap.Get(jobID); ok {
+			existed = append(existed, jobID)
+		}
+	}
+	return xtw.publishCancelTasks(existed)
+}
+
+func (xtw *xTimingWheelsV2) CancelTasksByPrefix(prefix string) (int, error) {
+	if !xtw.isRunning.Load() {
+		return 0, infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	jobIDs := xtw.tasksMap.ListKeys(func(jobID JobID) bool {
+		return strings.HasPrefix(string(jobID), prefix)
+	})
+	if err := xtw.publishCancelTasks(jobIDs); err != nil {
+		return 0, err
+	}
+	return len(jobIDs), nil
+}
+
+func (xtw *xTimingWheelsV2) publishCancelTasks(jobIDs []JobID) error {
+	if len(jobIDs) <= 0 {
+		return nil
+	}
+	event := xtw.twEventPool.Get()
+	event.CancelTasksJobIDs(jobIDs)
+	_, _, err := xtw.twEventDisruptor.Publish(event)
+	return infra.WrapErrorStack(err)
+}
+
+func (xtw *xTimingWheelsV2) PauseTask(jobID JobID) error {
+	return xtw.publishTaskEvent(jobID, func(event *timingWheelEvent) {
+		event.PauseTaskJobID(jobID)
+	})
+}
+
+func (xtw *xTimingWheelsV2) ResumeTask(jobID JobID) error {
+	return xtw.publishTaskEvent(jobID, func(event *timingWheelEvent) {
+		event.ResumeTaskJobID(jobID)
+	})
+}
+
+func (xtw *xTimingWheelsV2) RescheduleTask(jobID JobID, expiredMs int64, scheduler Scheduler) error {
+	if expiredMs <= 0 && scheduler == nil {
+		return infra.WrapErrorStack(ErrTimingWheelInvalidReschedule)
+	}
+	if err := checkMsScheduler(jobID, scheduler); err != nil {
+		return err
+	}
+	return xtw.publishTaskEvent(jobID, func(event *timingWheelEvent) {
+		event.RescheduleTask(jobID, expiredMs, scheduler)
+	})
+}
+
+func (xtw *xTimingWheelsV2) GetTask(jobID JobID) (TaskInfo, error) {
+	task, ok := xtw.tasksMap.Get(jobID)
+	if !ok {
+		return TaskInfo{}, infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	xtw.schedLock.Lock()
+	defer xtw.schedLock.Unlock()
+	return newTaskInfo(task), nil
+}
+
+func (xtw *xTimingWheelsV2) ListTasks(filter TaskFilter) []TaskInfo {
+	tasks := xtw.tasksMap.ListValues()
+	xtw.schedLock.Lock()
+	defer xtw.schedLock.Unlock()
+	return listTaskInfos(tasks, filter)
+}
+
+func (xtw *xTimingWheelsV2) Snapshot() TimingWheelsSnapshot {
+	taskCount := len(xtw.tasksMap.ListKeys())
+	xtw.schedLock.Lock()
+	defer xtw.schedLock.Unlock()
+	return newTimingWheelsSnapshot(xtw.name, xtw.tw, taskCount)
+}
+
+// publishTaskEvent publishes the event of the existed task.
+func (xtw *xTimingWheelsV2) publishTaskEvent(jobID JobID, setup func(event *timingWheelEvent)) error {
+	if len(jobID) <= 0 {
+		return infra.WrapErrorStack(ErrTimingWheelTaskEmptyJobID)
+	}
+	if !xtw.isRunning.Load() {
+		return infra.WrapErrorStack(ErrTimingWheelStopped)
+	}
+	if _, ok := xtw.tasksMap.Get(jobID); !ok {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	event := xtw.twEventPool.Get()
+	setup(event)
+	_, _, err := xtw.twEventDisruptor.Publish(event)
+	return infra.WrapErrorStack(err)
+}
+
+// heldSlotSender holds the clock for each expired slot until the slot
+// has been flushed, so the hrtime.FakeClock.Advance waits for them.
+type heldSlotSender struct {
+	infra.SendOnlyChannel[TimingWheelSlot]
+	clock hrtime.Clock
+}
+
+func (s heldSlotSender) Send(slot TimingWheelSlot, nonBlocking ...bool) error {
+	hrtime.Hold(s.clock)
+	if err := s.SendOnlyChannel.Send(slot, nonBlocking...); err != nil {
+		hrtime.Release(s.clock)
+		return err
+	}
+	return nil
+}
+
 func (xtw *xTimingWheelsV2) schedule(ctx context.Context) {
 	if ctx == nil {
 		return
@@ -197,11 +375,16 @@ func (xtw *xTimingWheelsV2) schedule(ctx context.Context) {
 					xtw.schedLock.Lock()
 					slot.setExpirationMs(slotHasBeenFlushedMs)
 					xtw.schedLock.Unlock()
-					_ = xtw.gPool.Submit(func() {
+					if err := xtw.gPool.Submit(func() {
+						defer hrtime.Release(xtw.clock)
 						xtw.schedLock.Lock()
 						defer xtw.schedLock.Unlock()
 						slot.Flush(xtw.handleTask)
-					})
+					}); err != nil {
+						hrtime.Release(xtw.clock)
+					}
+				} else {
+					hrtime.Release(xtw.clock)
 				}
 			}
 		}
@@ -220,7 +403,7 @@ func (xtw *xTimingWheelsV2) schedule(ctx context.Context) {
 			}()
 			xtw.dq.PollToChan(func() int64 {
 				return xtw.clock.NowInDefaultTZ().UnixMilli()
-			}, xtw.expiredSlotC)
+			}, heldSlotSender{xtw.expiredSlotC, xtw.clock})
 		}(ctx.Value(disableTimingWheelsSchedulePoll))
 	})
 	if err := xtw.twEventDisruptor.Start(); err != nil {
@@ -232,37 +415,72 @@ func (xtw *xTimingWheelsV2) schedule(ctx context.Context) {
 func (xtw *xTimingWheelsV2) handleEvent(event *timingWheelEvent) error {
 	switch op := event.GetOperation(); op {
 	case addTask, reAddTask:
+		if op == reAddTask {
+			defer hrtime.Release(xtw.clock)
+		}
 		task, ok := event.GetTask()
 		if !ok {
 			goto recycle
 		}
-		if err := xtw.addTask(task); errors.Is(err, ErrTimingWheelTaskIsExpired) {
-			if err = xtw.gPool.Submit(func() {
-				xtw.handleTask(task)
-			}); err != nil {
-				slog.Warn("[x-timing-wheels v2] submit job to pool failed", "op", op.String(),
-					"job", task.GetJobID(),
-					"execAt", hrtime.MillisToDefaultTzTime(task.GetExpiredMs()),
-					"error", err,
-				)
-			}
-		}
+		xtw.addOrHandleTask(op, task)
 		if op == addTask {
 			xtw.stats.RecordJobAliveCount(1)
 		}
-	case cancelTask:
+	case addTasks:
+		tasks, ok := event.GetTasks()
+		if !ok {
+			goto recycle
+		}
+		xtw.addOrHandleTasks(op, tasks)
+		xtw.stats.RecordJobAliveCount(int64(len(tasks)))
+	case cancelTasks:
+		jobIDs, ok := event.GetCancelTasksJobIDs()
+		if !ok {
+			goto recycle
+		}
+		if err := xtw.gPool.Submit(func() {
+			xtw.cancelTasks(jobIDs)
+		}); err != nil {
+			slog.Warn("[x-timing-wheels v2] submit job to pool failed", "op", op.String(),
+				"jobs", len(jobIDs),
+				"error", err,
+			)
+		}
+	case cancelTask, doneTask:
 		jobID, ok := event.GetCancelTaskJobID()
 		if !ok {
 			goto recycle
 		}
 		if err := xtw.gPool.Submit(func() {
-			_ = xtw.cancelTask(jobID)
+			_ = xtw.cancelTask(jobID, op == cancelTask)
 		}); err != nil {
 			slog.Warn("[x-timing-wheels v2] submit job to pool failed", "op", op.String(),
 				"job", jobID,
 				"error", err,
 			)
 		}
+	case pauseTask, resumeTask:
+		jobID, ok := event.GetTaskJobID()
+		if !ok {
+			goto recycle
+		}
+		var err error
+		if op == pauseTask {
+			err = xtw.pauseTask(jobID)
+		} else {
+			err = xtw.resumeTask(jobID)
+		}
+		if err != nil {
+			slog.Warn("[x-timing-wheels v2] task event failed", "op", op.String(), "job", jobID, "error", err)
+		}
+	case rescheduleTask:
+		args, ok := event.GetRescheduleTaskArgs()
+		if !ok {
+			goto recycle
+		}
+		if err := xtw.rescheduleTask(args); err != nil {
+			slog.Warn("[x-timing-wheels v2] task event failed", "op", op.String(), "job", args.jobID, "error", err)
+		}
 	default:
 
 	}
@@ -277,10 +495,69 @@ func (xtw *xTimingWheelsV2) advanceClock(timeoutMs int64) {
 	xtw.tw.(*timingWheel).advanceClock(timeoutMs)
 }
 
+// addOrHandleTask handles the task immediately if it has been expired.
+func (xtw *xTimingWheelsV2) addOrHandleTask(op timingWheelOperation, task Task) {
+	if err := xtw.addTask(task); errors.Is(err, ErrTimingWheelTaskIsExpired) {
+		xtw.submitExpiredTask(op, task)
+	}
+}
+
+// addOrHandleTasks adds the tasks with the schedLock held once, and
+// handles the expired tasks after the lock released.
+func (xtw *xTimingWheelsV2) addOrHandleTasks(op timingWheelOperation, tasks []Task) {
+	if !xtw.isRunning.Load() {
+		return
+	}
+	expired := make([]Task, 0, 8)
+	xtw.schedLock.Lock()
+	for _, task := range tasks {
+		if task == nil || task.Cancelled() {
+			continue
+		}
+		if task.park() {
+			task.setSlot(nil)
+			xtw.tasksMap.AddOrUpdate(task.GetJobID(), task)
+			continue
+		}
+		err := xtw.tw.(*timingWheel).addTask(task, 0)
+		if err == nil || errors.Is(err, ErrTimingWheelTaskIsExpired) {
+			xtw.tasksMap.AddOrUpdate(task.GetJobID(), task)
+		}
+		if errors.Is(err, ErrTimingWheelTaskIsExpired) {
+			expired = append(expired, task)
+		}
+	}
+	xtw.schedLock.Unlock()
+	for _, task := range expired {
+		xtw.submitExpiredTask(op, task)
+	}
+}
+
+func (xtw *xTimingWheelsV2) submitExpiredTask(op timingWheelOperation, task Task) {
+	hrtime.Hold(xtw.clock)
+	if err := xtw.gPool.Submit(func() {
+		defer hrtime.Release(xtw.clock)
+		xtw.handleTask(task)
+	}); err != nil {
+		hrtime.Release(xtw.clock)
+		slog.Warn("[x-timing-wheels v2] submit job to pool failed", "op", op.String(),
+			"job", task.GetJobID(),
+			"execAt", hrtime.MillisToDefaultTzTime(task.GetExpiredMs()),
+			"error", err,
+		)
+	}
+}
+
 func (xtw *xTimingWheelsV2) addTask(task Task) error {
 	if task == nil || task.Cancelled() || !xtw.isRunning.Load() {
 		return ErrTimingWheelStopped
 	}
+	if task.park() {
+		// Keeps the paused task out of the timing wheels.
+		task.setSlot(nil)
+		xtw.tasksMap.AddOrUpdate(task.GetJobID(), task)
+		return nil
+	}
 	xtw.schedLock.Lock()
 	err := xtw.tw.(*timingWheel).addTask(task, 0)
 	xtw.schedLock.Unlock()
@@ -301,6 +578,10 @@ func (xtw *xTimingWheelsV2) handleTask(t Task) {
 		)
 		return
 	}
+	if t.park() {
+		// Resumes by the resume event.
+		return
+	}
 
 	// [slotExpMs, slotExpMs+interval)
 	var (
@@ -319,14 +600,33 @@ func (xtw *xTimingWheelsV2) handleTask(t Task) {
 		runNow = prevSlotMetadata.GetExpirationMs() == sentinelSlotExpiredMs
 		runNow = runNow || (taskLevel == 0 && t.GetExpiredMs() <= prevSlotMetadata.GetExpirationMs()+xtw.GetTickMs())
 	}
-	runNow = runNow || t.GetExpiredMs() <= xtw.clock.NowInDefaultTZ().UnixMilli()
+	nowMs := xtw.clock.NowInDefaultTZ().UnixMilli()
+	runNow = runNow || t.GetExpiredMs() <= nowMs
+	if !runNow && slot == immediateExpiredSlot && !t.Cancelled() {
+		// Expired within the tick by the timing wheels, but not yet by
+		// the clock. Re-adds it once it is due instead of spinning.
+		hrtime.AfterFunc(xtw.clock, time.Duration(t.GetExpiredMs()-nowMs)*time.Millisecond, func() {
+			xtw.publishReAddTask(t)
+		})
+		return
+	}
 
 	if runNow && !t.Cancelled() {
-		job := t.GetJob()
-		md := t.GetJobMetadata()
-		_ = xtw.gPool.Submit(func() {
-			jobStatsWrapper(xtw.stats, job)(xtw.ctx, md)
-		})
+		// The record of the task is deleted once its last fire is done,
+		// so the fire interrupted by the restart will be recovered.
+		lastFire := t.GetJobType() == OnceJob || t.GetRestLoopCount() == 0
+		fired := false
+		if t.misfire(nowMs) {
+			job := jobExecWrapper(xtw.jobExecOpt.override(t.getJobExecOption()), jobStatsWrapper(xtw.stats, t.getErrJob()))
+			invoke := dlockJobWrapper(xtw.jobDLockOpt, job)
+			if lastFire {
+				invoke = xtw.unpersistJobWrapper(t, invoke)
+			}
+			fired = submitJob(xtw.gPool, xtw.ctx, t, invoke)
+		}
+		if lastFire && !fired {
+			xtw.unpersistTasks(t.GetJobID())
+		}
 	} else if t.Cancelled() {
 		if slot != nil {
 			slot.RemoveTask(t)
@@ -341,12 +641,12 @@ func (xtw *xTimingWheelsV2) handleTask(t Task) {
 	// Lock free.
 	switch t.GetJobType() {
 	case OnceJob:
-		event := xtw.twEventPool.Get()
-		if runNow {
-			event.CancelTaskJobID(t.GetJobID())
-		} else {
-			event.ReAddTask(t)
+		if !runNow {
+			xtw.publishReAddTask(t)
+			return
 		}
+		event := xtw.twEventPool.Get()
+		event.DoneTaskJobID(t.GetJobID())
 		_, _, _ = xtw.twEventDisruptor.Publish(event)
 	case RepeatedJob:
 		var sTask Task
@@ -355,7 +655,7 @@ func (xtw *xTimingWheelsV2) handleTask(t Task) {
 		} else {
 			if t.GetRestLoopCount() == 0 {
 				event := xtw.twEventPool.Get()
-				event.CancelTaskJobID(t.GetJobID())
+				event.DoneTaskJobID(t.GetJobID())
 				_, _, _ = xtw.twEventDisruptor.Publish(event)
 				return
 			}
@@ -366,19 +666,32 @@ func (xtw *xTimingWheelsV2) handleTask(t Task) {
 			_sTask.UpdateNextScheduledMs()
 			sTask = _sTask
 			if sTask.GetExpiredMs() < 0 {
+				xtw.unpersistTasks(sTask.GetJobID())
 				return
 			}
+			xtw.markTaskDirty(sTask)
 		}
 		if sTask != nil {
-			event := xtw.twEventPool.Get()
-			event.ReAddTask(sTask)
-			_, _, _ = xtw.twEventDisruptor.Publish(event)
+			xtw.publishReAddTask(sTask)
 		}
 	}
 	return
 }
 
-func (xtw *xTimingWheelsV2) cancelTask(jobID JobID) error {
+// publishReAddTask holds the clock until the task has been re-added,
+// so the hrtime.FakeClock.Advance waits for the tasks expired by it.
+func (xtw *xTimingWheelsV2) publishReAddTask(t Task) {
+	event := xtw.twEventPool.Get()
+	event.ReAddTask(t)
+	hrtime.Hold(xtw.clock)
+	if _, _, err := xtw.twEventDisruptor.Publish(event); err != nil {
+		hrtime.Release(xtw.clock)
+	}
+}
+
+// cancelTask removes the task, and deletes its record if unpersist
+// is true. The done task's record is deleted by its last fire.
+func (xtw *xTimingWheelsV2) cancelTask(jobID JobID, unpersist bool) error {
 	if !xtw.isRunning.Load() {
 		return infra.WrapErrorStack(ErrTimingWheelStopped)
 	}
@@ -389,7 +702,7 @@ func (xtw *xTimingWheelsV2) cancelTask(jobID JobID) error {
 	}
 
 	xtw.schedLock.Lock()
-	if task.GetSlot() != nil && !task.GetSlot().RemoveTask(task) {
+	if slot := task.GetSlot(); slot != nil && slot != immediateExpiredSlot && !slot.RemoveTask(task) {
 		xtw.schedLock.Unlock()
 		return ErrTimingWheelTaskUnableToBeRemoved
 	}
@@ -401,11 +714,295 @@ func (xtw *xTimingWheelsV2) cancelTask(jobID JobID) error {
 	}()
 
 	task.Cancel()
+	if unpersist {
+		xtw.unpersistTasks(jobID)
+	}
 
 	_, err := xtw.tasksMap.Delete(jobID)
 	return infra.WrapErrorStack(err)
 }
 
+// cancelTasks removes the tasks from their slots with the schedLock
+// held once. The not found tasks are ignored.
+func (xtw *xTimingWheelsV2) cancelTasks(jobIDs []JobID) {
+	if !xtw.isRunning.Load() {
+		return
+	}
+	tasks := make([]Task, 0, len(jobIDs))
+	xtw.schedLock.Lock()
+	for _, jobID := range jobIDs {
+		task, ok := xtw.tasksMap.Get(jobID)
+		if !ok {
+			continue
+		}
+		if slot := task.GetSlot(); slot != nil && slot != immediateExpiredSlot && !slot.RemoveTask(task) {
+			slog.Warn("[x-timing-wheels v2] cancel task failed", "job", jobID,
+				"error", ErrTimingWheelTaskUnableToBeRemoved,
+			)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	xtw.schedLock.Unlock()
+
+	cancelled := make([]JobID, 0, len(tasks))
+	for _, task := range tasks {
+		task.Cancel()
+		cancelled = append(cancelled, task.GetJobID())
+	}
+	xtw.unpersistTasks(cancelled...)
+	for _, jobID := range cancelled {
+		_, _ = xtw.tasksMap.Delete(jobID)
+		xtw.stats.IncreaseJobCancelledCount()
+	}
+	xtw.stats.RecordJobAliveCount(-int64(len(tasks)))
+}
+
+// pauseTask removes the task from its slot. If the task is not in any
+// slot (e.g. firing), it will be parked once it comes back.
+func (xtw *xTimingWheelsV2) pauseTask(jobID JobID) error {
+	task, ok := xtw.tasksMap.Get(jobID)
+	if !ok {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	if task.Paused() {
+		return nil
+	}
+	xtw.schedLock.Lock()
+	slot := task.GetSlot()
+	task.pause(slot != nil && slot != immediateExpiredSlot && slot.RemoveTask(task))
+	xtw.schedLock.Unlock()
+	return xtw.persistTasks(task)
+}
+
+func (xtw *xTimingWheelsV2) resumeTask(jobID JobID) error {
+	task, ok := xtw.tasksMap.Get(jobID)
+	if !ok {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	if !task.Paused() {
+		return nil
+	}
+	if task.resume() {
+		xtw.addOrHandleTask(resumeTask, task)
+	}
+	return xtw.persistTasks(task)
+}
+
+// rescheduleTask moves the task to the new slot. If the task is not in
+// any slot (e.g. firing), the new expiration takes effect once it comes
+// back. The paused task is still paused.
+func (xtw *xTimingWheelsV2) rescheduleTask(args *rescheduleTaskArgs) error {
+	task, ok := xtw.tasksMap.Get(args.jobID)
+	if !ok {
+		return infra.WrapErrorStack(ErrTimingWheelTaskNotFound)
+	}
+	xtw.schedLock.Lock()
+	slot := task.GetSlot()
+	removed := !task.Paused() && slot != nil && slot != immediateExpiredSlot && slot.RemoveTask(task)
+	err := task.reschedule(args.expiredMs, args.scheduler, xtw.clock.NowInDefaultTZ().UnixMilli())
+	xtw.schedLock.Unlock()
+	if removed {
+		xtw.addOrHandleTask(rescheduleTask, task)
+	}
+	if err != nil {
+		return err
+	}
+	return xtw.persistTasks(task)
+}
+
+// persistTasks saves the records of the named tasks in a single
+// transaction, none of them is saved if any task is unpersistable.
+func (xtw *xTimingWheelsV2) persistTasks(tasks ...Task) error {
+	if xtw.taskStore == nil {
+		return nil
+	}
+	records := make([]TaskRecord, 0, len(tasks))
+	for _, task := range tasks {
+		if len(task.GetJobName()) <= 0 {
+			continue
+		}
+		if _, ok := xtw.jobs[task.GetJobName()]; !ok {
+			return infra.WrapErrorStackWithMessage(ErrTimingWheelTaskUnpersistable,
+				fmt.Sprintf("job %s is unregistered", task.GetJobName()))
+		}
+		record, ok := newTaskRecord(task)
+		if !ok {
+			return infra.WrapErrorStackWithMessage(ErrTimingWheelTaskUnpersistable,
+				fmt.Sprintf("job %s with unknown scheduler", task.GetJobName()))
+		}
+		records = append(records, record)
+	}
+	if len(records) <= 0 {
+		return nil
+	}
+	xtw.persistLock.Lock()
+	defer xtw.persistLock.Unlock()
+	for _, record := range records {
+		xtw.cleanTaskDirty(record.JobID)
+	}
+	return infra.WrapErrorStack(xtw.taskStore.Save(records...))
+}
+
+// markTaskDirty defers saving the progress of the repeat task, it will
+// be saved in a batch by the flushTasks.
+func (xtw *xTimingWheelsV2) markTaskDirty(task Task) {
+	if xtw.taskStore == nil || len(task.GetJobName()) <= 0 {
+		return
+	}
+	record, ok := newTaskRecord(task)
+	if !ok {
+		return
+	}
+	xtw.dirtyLock.Lock()
+	xtw.dirtyRecords[record.JobID] = record
+	xtw.dirtyLock.Unlock()
+}
+
+func (xtw *xTimingWheelsV2) cleanTaskDirty(jobID JobID) {
+	xtw.dirtyLock.Lock()
+	delete(xtw.dirtyRecords, jobID)
+	xtw.dirtyLock.Unlock()
+}
+
+// flushTasks saves the progress of the repeat tasks in a single
+// transaction. It is serialized with the other writes, so the record
+// deleted is not saved again.
+func (xtw *xTimingWheelsV2) flushTasks() {
+	if xtw.taskStore == nil {
+		return
+	}
+	xtw.persistLock.Lock()
+	defer xtw.persistLock.Unlock()
+	xtw.dirtyLock.Lock()
+	dirty := xtw.dirtyRecords
+	xtw.dirtyRecords = make(map[JobID]TaskRecord, len(dirty))
+	xtw.dirtyLock.Unlock()
+	if len(dirty) <= 0 {
+		return
+	}
+	records := make([]TaskRecord, 0, len(dirty))
+	for _, record := range dirty {
+		records = append(records, record)
+	}
+	if err := xtw.taskStore.Save(records...); err != nil {
+		slog.Warn("[x-timing-wheels v2] save the progress of tasks failed", "tasks", len(records), "error", err)
+	}
+}
+
+func (xtw *xTimingWheelsV2) flushTasksLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-xtw.stopC:
+			return
+		case <-ticker.C:
+			xtw.flushTasks()
+		}
+	}
+}
+
+// unpersistJobWrapper deletes the record of the named task after the
+// job is done.
+func (xtw *xTimingWheelsV2) unpersistJobWrapper(t Task, invoke Job) Job {
+	if xtw.taskStore == nil || len(t.GetJobName()) <= 0 {
+		return invoke
+	}
+	return func(ctx context.Context, metadata JobMetadata) {
+		defer xtw.unpersistTasks(metadata.GetJobID())
+		invoke(ctx, metadata)
+	}
+}
+
+// unpersistTasks deletes the records in a single transaction.
+func (xtw *xTimingWheelsV2) unpersistTasks(jobIDs ...JobID) {
+	if xtw.taskStore == nil || len(jobIDs) <= 0 {
+		return
+	}
+	xtw.persistLock.Lock()
+	defer xtw.persistLock.Unlock()
+	for _, jobID := range jobIDs {
+		xtw.cleanTaskDirty(jobID)
+	}
+	if err := xtw.taskStore.Delete(jobIDs...); err != nil {
+		slog.Warn("[x-timing-wheels v2] delete persisted tasks failed", "jobs", jobIDs, "error", err)
+	}
+}
+
+// rollbackTasks reverts the records saved for the tasks failed to add.
+// The records of the live tasks with the same job IDs have been replaced,
+// so they are restored from the live tasks, and the others are deleted.
+func (xtw *xTimingWheelsV2) rollbackTasks(jobIDs ...JobID) {
+	if xtw.taskStore == nil || len(jobIDs) <= 0 {
+		return
+	}
+	added := make([]JobID, 0, len(jobIDs))
+	restored := make([]TaskRecord, 0, len(jobIDs))
+	for _, jobID := range jobIDs {
+		task, ok := xtw.tasksMap.Get(jobID)
+		if !ok || len(task.GetJobName()) <= 0 {
+			added = append(added, jobID)
+			continue
+		}
+		if record, ok := newTaskRecord(task); ok {
+			restored = append(restored, record)
+		} else {
+			added = append(added, jobID)
+		}
+	}
+	xtw.unpersistTasks(added...)
+	if len(restored) <= 0 {
+		return
+	}
+	xtw.persistLock.Lock()
+	defer xtw.persistLock.Unlock()
+	if err := xtw.taskStore.Save(restored...); err != nil {
+		slog.Warn("[x-timing-wheels v2] restore persisted tasks failed", "tasks", len(restored), "error", err)
+	}
+}
+
+// recoverTasks reloads the persisted tasks with the registered jobs.
+// The records of unregistered jobs are kept, they may be registered
+// in the next start.
+func (xtw *xTimingWheelsV2) recoverTasks(policy TaskRecoveryPolicy) {
+	if xtw.taskStore == nil {
+		return
+	}
+	records, err := xtw.taskStore.LoadAll()
+	if err != nil {
+		slog.Error("[x-timing-wheels v2] load persisted tasks failed", "error", err)
+		return
+	}
+	nowMs := xtw.clock.NowInDefaultTZ().UnixMilli()
+	for _, record := range records {
+		registered, ok := xtw.jobs[record.JobName]
+		if !ok {
+			slog.Warn("[x-timing-wheels v2] recover task with unregistered job",
+				"job", record.JobID,
+				"name", record.JobName,
+			)
+			continue
+		}
+		task, err := recoverTask(xtw.ctx, record, registered, nowMs, policy)
+		if err != nil {
+			slog.Warn("[x-timing-wheels v2] recover task failed", "job", record.JobID, "error", err)
+			continue
+		}
+		if task == nil {
+			xtw.unpersistTasks(record.JobID)
+			continue
+		}
+		// The record is kept if failed, it will be recovered in the next start.
+		if err = xtw.persistTasks(task); err == nil {
+			err = xtw.publishAddTask(task)
+		}
+		if err != nil {
+			slog.Warn("[x-timing-wheels v2] recover task failed", "job", record.JobID, "error", err)
+		}
+	}
+}
+
 // NewXTimingWheelsV2 creates a new timing wheel.
 // The same as the kafka, Time.SYSTEM.hiResClockMs() is used.
 func NewXTimingWheelsV2(ctx context.Context, opts ...TimingWheelsOption) TimingWheels {
@@ -431,6 +1028,11 @@ func NewXTimingWheelsV2(ctx context.Context, opts ...TimingWheelsOption) TimingW
 		idGenerator:  xtwOpt.getIDGenerator(),
 		twEventPool:  newTimingWheelEventsPool(),
 		stats:        xtwOpt.getStats(),
+		taskStore:    xtwOpt.getTaskStore(),
+		jobs:         xtwOpt.getJobs(),
+		dirtyRecords: make(map[JobID]TaskRecord),
+		jobDLockOpt:  xtwOpt.getJobDLockOption(),
+		jobExecOpt:   xtwOpt.getJobExecOption(),
 		name:         xtwOpt.getName(),
 	}
 	xtw.isRunning.Store(false)
@@ -444,7 +1046,9 @@ func NewXTimingWheelsV2(ctx context.Context, opts ...TimingWheelsOption) TimingW
 		ipc.NewXGoSchedBlockStrategy(),
 		xtw.handleEvent,
 	)
-	xtw.dq = queue.NewArrayDelayQueue[TimingWheelSlot](ctx, xtwOpt.defaultDelayQueueCapacity())
+	xtw.dq = queue.NewArrayDelayQueue[TimingWheelSlot](ctx, xtwOpt.defaultDelayQueueCapacity(),
+		queue.WithArrayDelayQueueClock[TimingWheelSlot](xtw.clock),
+	)
 	xtw.tw = newTimingWheel(
 		ctx,
 		xtwOpt.getBasicTickMilliseconds(),
@@ -456,5 +1060,9 @@ func NewXTimingWheelsV2(ctx context.Context, opts ...TimingWheelsOption) TimingW
 	)
 	xtw.isRunning.Store(true)
 	xtw.schedule(ctx)
+	xtw.recoverTasks(xtwOpt.getRecoveryPolicy())
+	if xtw.taskStore != nil {
+		go xtw.flushTasksLoop(xtwOpt.getTaskStoreFlushInterval())
+	}
 	return xtw
 }
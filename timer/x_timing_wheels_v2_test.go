@@ -3,8 +3,11 @@ package timer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"runtime"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -15,6 +18,7 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/sdk/metric"
 
+	"github.com/benz9527/xboot/lib/hrtime"
 	"github.com/benz9527/xboot/lib/id"
 	"github.com/benz9527/xboot/observability"
 )
@@ -306,6 +310,388 @@ func TestXTimingWheelsV2_AfterFunc_Slots(t *testing.T) {
 	<-ctx.Done()
 }
 
+func testTimingWheelsPauseResumeReschedule(t *testing.T, tw TimingWheels) {
+	ctx := context.Background()
+	var repeatCount, onceCount atomic.Int64
+	nowMs := time.Now().UnixMilli()
+	repeat := NewRepeatTask(ctx, "repeat", nowMs, NewFiniteScheduler(
+		100*time.Millisecond,
+		100*time.Millisecond,
+		100*time.Millisecond,
+		100*time.Millisecond,
+	), func(ctx context.Context, md JobMetadata) {
+		repeatCount.Add(1)
+	})
+	require.NoError(t, tw.AddTask(repeat))
+	once := NewOnceTask(ctx, "once", nowMs+5000, func(ctx context.Context, md JobMetadata) {
+		onceCount.Add(1)
+	})
+	require.NoError(t, tw.AddTask(once))
+
+	require.True(t, errors.Is(tw.PauseTask("not-found"), ErrTimingWheelTaskNotFound))
+	require.True(t, errors.Is(tw.RescheduleTask("once", 0, nil), ErrTimingWheelInvalidReschedule))
+
+	require.Eventually(t, func() bool {
+		return repeatCount.Load() == 1
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, tw.PauseTask("repeat"))
+	require.Eventually(t, repeat.Paused, time.Second, 5*time.Millisecond)
+	restLoopCount := repeat.GetRestLoopCount()
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, int64(1), repeatCount.Load())
+	require.Equal(t, restLoopCount, repeat.GetRestLoopCount())
+
+	// Reschedules the paused task without resuming it.
+	require.NoError(t, tw.RescheduleTask("repeat", time.Now().UnixMilli()+200, nil))
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, int64(1), repeatCount.Load())
+	require.True(t, repeat.Paused())
+
+	// The rest loop count is preserved.
+	require.NoError(t, tw.ResumeTask("repeat"))
+	require.Eventually(t, func() bool {
+		return repeatCount.Load() == 4
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, int64(4), repeatCount.Load())
+
+	require.NoError(t, tw.RescheduleTask("once", time.Now().UnixMilli()+100, nil))
+	require.Eventually(t, func() bool {
+		return onceCount.Load() == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestXTimingWheelsV2_PauseResumeReschedule(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx)
+	defer tw.Shutdown()
+	testTimingWheelsPauseResumeReschedule(t, tw)
+
+	var count atomic.Int64
+	task, err := tw.ScheduleFunc(func() Scheduler {
+		return NewInfiniteScheduler(time.Hour)
+	}, func(ctx context.Context, md JobMetadata) {
+		count.Add(1)
+	})
+	require.NoError(t, err)
+	cronSched, err := NewCronScheduler("* * * * * *", 0)
+	require.NoError(t, err)
+	// The task is added asynchronously.
+	require.Eventually(t, func() bool {
+		return tw.RescheduleTask(task.GetJobID(), 0, cronSched) == nil
+	}, time.Second, 5*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return count.Load() >= 2
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(-1), task.GetRestLoopCount())
+}
+
+func TestXTimingWheelsV2_RescheduleRunningTask(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx)
+	defer tw.Shutdown()
+
+	var count atomic.Int64
+	task := NewRepeatTask(ctx, "repeat", time.Now().UnixMilli(), NewInfiniteScheduler(5*time.Millisecond),
+		func(ctx context.Context, md JobMetadata) {
+			count.Add(1)
+		},
+	)
+	require.NoError(t, tw.AddTask(task))
+	require.Eventually(t, func() bool {
+		return count.Load() >= 1
+	}, time.Second, time.Millisecond)
+
+	// Replaces the scheduler while the task is firing, it must be free
+	// of the data race (-race).
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_ = task.GetRestLoopCount()
+			_ = task.GetExpiredMs()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		interval := time.Duration(i%5+3) * time.Millisecond
+		require.NoError(t, tw.RescheduleTask("repeat", 0, NewFiniteScheduler(interval, interval, interval)))
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool {
+		return task.GetRestLoopCount() == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func testTimingWheelsIntrospection(t *testing.T, tw TimingWheels) {
+	ctx := context.Background()
+	job := func(ctx context.Context, md JobMetadata) {}
+	nowMs := time.Now().UnixMilli()
+	require.NoError(t, tw.AddTask(NewOnceTask(ctx, "once-3", nowMs+30_000, job)))
+	require.NoError(t, tw.AddTask(NewOnceTask(ctx, "once-1", nowMs+2_000, job)))
+	require.NoError(t, tw.AddTask(NewNamedOnceTask(ctx, "once-2", "named", nowMs+10_000, job)))
+	require.NoError(t, tw.AddTask(NewRepeatTask(ctx, "repeat", nowMs, NewFiniteScheduler(
+		5*time.Second,
+		time.Second,
+	), job)))
+	require.Eventually(t, func() bool {
+		return len(tw.ListTasks(nil)) == 4
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, tw.PauseTask("once-3"))
+	require.Eventually(t, func() bool {
+		info, err := tw.GetTask("once-3")
+		return err == nil && info.Paused
+	}, time.Second, 5*time.Millisecond)
+
+	_, err := tw.GetTask("not-found")
+	require.True(t, errors.Is(err, ErrTimingWheelTaskNotFound))
+	info, err := tw.GetTask("once-2")
+	require.NoError(t, err)
+	require.Equal(t, "named", info.JobName)
+	require.Equal(t, OnceJob, info.JobType)
+	require.Equal(t, nowMs+10_000, info.ExpiredMs)
+	require.Equal(t, int64(1), info.RestLoopCount)
+	require.False(t, info.Paused)
+	require.Greater(t, info.SlotLevel, int64(0))
+	info, err = tw.GetTask("once-3")
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), info.SlotLevel)
+
+	infos := tw.ListTasks(nil)
+	jobIDs := make([]JobID, 0, len(infos))
+	for _, info := range infos {
+		jobIDs = append(jobIDs, info.JobID)
+	}
+	require.Equal(t, []JobID{"once-1", "repeat", "once-2", "once-3"}, jobIDs)
+	infos = tw.ListTasks(func(info TaskInfo) bool {
+		return info.JobType == RepeatedJob
+	})
+	require.Len(t, infos, 1)
+	require.Equal(t, int64(1), infos[0].RestLoopCount)
+
+	snapshot := tw.Snapshot()
+	require.Equal(t, 4, snapshot.TaskCount)
+	require.Equal(t, nowMs+2_000, snapshot.NextFireMs)
+	var slotTaskCount int64
+	for i, level := range snapshot.Levels {
+		require.Equal(t, int64(i), level.Level)
+		for _, slot := range level.Slots {
+			require.Greater(t, slot.TaskCount, int64(0))
+			require.GreaterOrEqual(t, slot.NextFireMs, slot.ExpirationMs)
+		}
+		slotTaskCount += level.TaskCount
+	}
+	// The paused task is not in any slot.
+	require.Equal(t, int64(3), slotTaskCount)
+}
+
+func TestXTimingWheelsV2_HiResSchedulerRejected(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx)
+	defer tw.Shutdown()
+
+	job := func(ctx context.Context, md JobMetadata) {}
+	_, err := tw.ScheduleFunc(func() Scheduler {
+		return NewHiResInfiniteScheduler(500 * time.Microsecond)
+	}, job)
+	require.ErrorIs(t, err, ErrTimingWheelHiResScheduler)
+	hiRes := NewRepeatTask(ctx, "hi-res", time.Now().UnixMilli(),
+		NewHiResFiniteScheduler(time.Second, 500*time.Microsecond), job)
+	require.ErrorIs(t, tw.AddTasks([]Task{hiRes}), ErrTimingWheelHiResScheduler)
+	_, err = tw.GetTask("hi-res")
+	require.ErrorIs(t, err, ErrTimingWheelTaskNotFound)
+
+	// The hi-res scheduler in ms intervals is accepted.
+	task, err := tw.ScheduleFunc(func() Scheduler {
+		return NewHiResInfiniteScheduler(time.Second)
+	}, job)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := tw.GetTask(task.GetJobID())
+		return err == nil
+	}, time.Second, time.Millisecond)
+	require.ErrorIs(t, tw.RescheduleTask(task.GetJobID(), 0, NewHiResInfiniteScheduler(500*time.Microsecond)),
+		ErrTimingWheelHiResScheduler)
+	require.NoError(t, tw.RescheduleTask(task.GetJobID(), 0, NewHiResInfiniteScheduler(2*time.Second)))
+}
+
+func TestXTimingWheelsV2_Introspection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx)
+	defer tw.Shutdown()
+	testTimingWheelsIntrospection(t, tw)
+}
+
+func testTimingWheelsCancelTask(t *testing.T, tw TimingWheels) {
+	var count atomic.Int64
+	task, err := tw.AfterFunc(200*time.Millisecond, func(ctx context.Context, md JobMetadata) {
+		count.Add(1)
+	})
+	require.NoError(t, err)
+	require.ErrorIs(t, tw.CancelTask(""), ErrTimingWheelTaskEmptyJobID)
+	require.ErrorIs(t, tw.CancelTask("not-found"), ErrTimingWheelTaskNotFound)
+	// The task is added asynchronously.
+	require.Eventually(t, func() bool {
+		_, err := tw.GetTask(task.GetJobID())
+		return err == nil
+	}, time.Second, time.Millisecond)
+	require.NoError(t, tw.CancelTask(task.GetJobID()))
+	require.Eventually(t, func() bool {
+		_, err := tw.GetTask(task.GetJobID())
+		return errors.Is(err, ErrTimingWheelTaskNotFound)
+	}, time.Second, 5*time.Millisecond)
+	require.True(t, task.Cancelled())
+	time.Sleep(400 * time.Millisecond)
+	require.Equal(t, int64(0), count.Load())
+
+	task, err = tw.AfterFunc(time.Minute, func(ctx context.Context, md JobMetadata) {})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := tw.GetTask(task.GetJobID())
+		return err == nil
+	}, time.Second, time.Millisecond)
+	tw.Shutdown()
+	require.ErrorIs(t, tw.CancelTask(task.GetJobID()), ErrTimingWheelStopped)
+}
+
+func TestXTimingWheelsV2_CancelTask(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx)
+	defer tw.Shutdown()
+	testTimingWheelsCancelTask(t, tw)
+}
+
+func testTimingWheelsBatch(t *testing.T, tw TimingWheels) {
+	ctx := context.Background()
+	job := func(ctx context.Context, md JobMetadata) {}
+	nowMs := time.Now().UnixMilli()
+	require.ErrorIs(t, tw.AddTasks([]Task{
+		NewOnceTask(ctx, "invalid-1", nowMs+60_000, job),
+		NewOnceTask(ctx, "invalid-2", nowMs+60_000, nil),
+	}), ErrTimingWheelEmptyJob)
+
+	var expiredCount atomic.Int64
+	tasks := make([]Task, 0, 12)
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, NewOnceTask(ctx, JobID(fmt.Sprintf("session-%d", i)), nowMs+60_000+int64(i), job))
+	}
+	tasks = append(tasks,
+		NewOnceTask(ctx, "other", nowMs+60_000, job),
+		NewOnceTask(ctx, "expired", nowMs-1_000, func(ctx context.Context, md JobMetadata) {
+			expiredCount.Add(1)
+		}),
+	)
+	require.NoError(t, tw.AddTasks(tasks))
+	require.Eventually(t, func() bool {
+		return len(tw.ListTasks(nil)) == 11 && expiredCount.Load() == 1
+	}, time.Second, 5*time.Millisecond)
+	_, err := tw.GetTask("invalid-1")
+	require.ErrorIs(t, err, ErrTimingWheelTaskNotFound)
+
+	require.NoError(t, tw.CancelTasks([]JobID{"session-0", "session-1", "not-found"}))
+	require.Eventually(t, func() bool {
+		return len(tw.ListTasks(nil)) == 9
+	}, time.Second, 5*time.Millisecond)
+	require.True(t, tasks[0].Cancelled())
+	count, err := tw.CancelTasksByPrefix("session-")
+	require.NoError(t, err)
+	require.Equal(t, 8, count)
+	require.Eventually(t, func() bool {
+		infos := tw.ListTasks(nil)
+		return len(infos) == 1 && infos[0].JobID == "other"
+	}, time.Second, 5*time.Millisecond)
+	count, err = tw.CancelTasksByPrefix("session-")
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
+func TestXTimingWheelsV2_Batch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tw := NewXTimingWheelsV2(ctx)
+	defer tw.Shutdown()
+	testTimingWheelsBatch(t, tw)
+}
+
+const benchmarkBatchSize = 1024
+
+func newBenchmarkSessionTasks(round int) []Task {
+	ctx := context.Background()
+	nowMs := time.Now().UnixMilli()
+	tasks := make([]Task, benchmarkBatchSize)
+	for i := range tasks {
+		tasks[i] = NewOnceTask(ctx, JobID(fmt.Sprintf("session-%d-%d", round, i)), nowMs+3_600_000+int64(i),
+			func(ctx context.Context, md JobMetadata) {})
+	}
+	return tasks
+}
+
+// benchmarkTimingWheelsV2Batch measures the add and cancel of a batch of
+// session tasks per round, the tasks are generated out of the timer.
+func benchmarkTimingWheelsV2Batch(b *testing.B, add func(tw TimingWheels, tasks []Task), cancel func(tw TimingWheels, tasks []Task)) {
+	tw := NewXTimingWheelsV2(
+		context.Background(),
+		WithTimingWheelsTickMs(1*time.Millisecond),
+		WithTimingWheelsSlotSize(20),
+	)
+	defer tw.Shutdown()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tasks := newBenchmarkSessionTasks(i)
+		b.StartTimer()
+		add(tw, tasks)
+		for len(tw.ListTasks(nil)) < len(tasks) {
+			runtime.Gosched()
+		}
+		cancel(tw, tasks)
+		for len(tw.ListTasks(nil)) > 0 {
+			runtime.Gosched()
+		}
+	}
+	b.ReportAllocs()
+}
+
+func BenchmarkXTimingWheelsV2_PerTask(b *testing.B) {
+	benchmarkTimingWheelsV2Batch(b, func(tw TimingWheels, tasks []Task) {
+		for _, task := range tasks {
+			require.NoError(b, tw.AddTask(task))
+		}
+	}, func(tw TimingWheels, tasks []Task) {
+		for _, task := range tasks {
+			require.NoError(b, tw.CancelTask(task.GetJobID()))
+		}
+	})
+}
+
+func BenchmarkXTimingWheelsV2_Batch(b *testing.B) {
+	benchmarkTimingWheelsV2Batch(b, func(tw TimingWheels, tasks []Task) {
+		require.NoError(b, tw.AddTasks(tasks))
+	}, func(tw TimingWheels, tasks []Task) {
+		jobIDs := make([]JobID, 0, len(tasks))
+		for _, task := range tasks {
+			jobIDs = append(jobIDs, task.GetJobID())
+		}
+		require.NoError(b, tw.CancelTasks(jobIDs))
+	})
+}
+
+func BenchmarkXTimingWheelsV2_BatchByPrefix(b *testing.B) {
+	benchmarkTimingWheelsV2Batch(b, func(tw TimingWheels, tasks []Task) {
+		require.NoError(b, tw.AddTasks(tasks))
+	}, func(tw TimingWheels, tasks []Task) {
+		_, err := tw.CancelTasksByPrefix("session-")
+		require.NoError(b, err)
+	})
+}
+
 func BenchmarkNewTimingWheelsV2_AfterFunc(b *testing.B) {
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, disableTimingWheelsScheduleCancelTask, true)
@@ -324,3 +710,43 @@ func BenchmarkNewTimingWheelsV2_AfterFunc(b *testing.B) {
 	}
 	b.ReportAllocs()
 }
+
+func TestXTimingWheelsV2_FakeClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	clock := hrtime.NewFakeClock(time.Now())
+	tw := NewXTimingWheelsV2(ctx, WithTimingWheelsClock(clock))
+	defer tw.Shutdown()
+
+	var onceCount, repeatCount atomic.Int64
+	_, err := tw.AfterFunc(time.Hour, func(ctx context.Context, md JobMetadata) {
+		onceCount.Add(1)
+	})
+	require.NoError(t, err)
+	_, err = tw.ScheduleFunc(func() Scheduler {
+		return NewInfiniteScheduler(time.Minute)
+	}, func(ctx context.Context, md JobMetadata) {
+		repeatCount.Add(1)
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return clock.PendingTimers() == 1
+	}, time.Second, time.Millisecond)
+
+	for i := int64(1); i <= 3; i++ {
+		clock.Advance(time.Minute)
+		require.Eventually(t, func() bool {
+			return repeatCount.Load() == i
+		}, time.Second, time.Millisecond)
+	}
+	// The Advance returns once the expired slots have been flushed.
+	clock.Advance(57*time.Minute - time.Millisecond)
+	require.Equal(t, int64(0), onceCount.Load())
+	require.Eventually(t, func() bool {
+		return repeatCount.Load() == 59
+	}, time.Second, time.Millisecond)
+	clock.Advance(time.Millisecond)
+	require.Eventually(t, func() bool {
+		return onceCount.Load() == 1 && repeatCount.Load() == 60
+	}, time.Second, time.Millisecond)
+}